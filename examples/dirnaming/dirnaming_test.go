@@ -0,0 +1,34 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dirnaming
+
+import (
+	"testing"
+
+	"github.com/bazelbuild/rules_go/examples/dirnaming/greeting"
+)
+
+func TestGreet(t *testing.T) {
+	if got, want := Greet(), "Hello, world"; got != want {
+		t.Errorf("Greet() = %q; want %q", got, want)
+	}
+}
+
+func TestPkgPath(t *testing.T) {
+	if got, want := greeting.PkgPath(), "github.com/bazelbuild/rules_go/examples/dirnaming/greeting"; got != want {
+		t.Errorf("greeting.PkgPath() = %q; want %q", got, want)
+	}
+}
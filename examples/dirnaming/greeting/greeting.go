@@ -0,0 +1,31 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package greeting is a library named after its directory.
+package greeting
+
+import "reflect"
+
+// Hello returns a greeting to "name".
+func Hello(name string) string {
+	return "Hello, " + name
+}
+
+type dummy struct{}
+
+// PkgPath returns the package importpath of this package.
+func PkgPath() string {
+	return reflect.TypeOf(dummy{}).PkgPath()
+}
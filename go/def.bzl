@@ -35,10 +35,24 @@ _DEFAULT_LIB = "go_default_library"
 _VENDOR_PREFIX = "/vendor/"
 
 go_filetype = FileType([".go", ".s", ".S"])
+# go_library also takes the headers included by its assembly sources.
+go_library_filetype = FileType([".go", ".s", ".S", ".h"])
 # be consistent to cc_library.
 hdr_exts = ['.h', '.hh', '.hpp', '.hxx', '.inc']
 cc_hdr_filetype = FileType(hdr_exts)
 
+# Known values of GOOS and GOARCH which go/build recognizes as file name
+# suffixes.
+_GOOS_LIST = [
+    "android", "darwin", "dragonfly", "freebsd", "linux", "nacl", "netbsd",
+    "openbsd", "plan9", "solaris", "windows", "zos",
+]
+_GOARCH_LIST = [
+    "386", "amd64", "amd64p32", "arm", "armbe", "arm64", "arm64be", "ppc64",
+    "ppc64le", "mips", "mipsle", "mips64", "mips64le", "mips64p32",
+    "mips64p32le", "ppc", "s390", "s390x", "sparc", "sparc64",
+]
+
 ################
 
 def _go_prefix(ctx):
@@ -117,17 +131,26 @@ def _emit_generate_params_action(cmds, ctx, fn):
   ctx.file_action( output = f, content = cmds_all_str, executable = True)
   return f
 
-def emit_go_asm_action(ctx, source, out_obj):
+def emit_go_asm_action(ctx, source, hdrs, out_obj):
   """Construct the command line for compiling Go Assembly code.
   Constructs a symlink tree to accomodate for workspace name.
   Args:
     ctx: The skylark Context.
     source: a source code artifact
+    hdrs: an iterable of the header artifacts which source may include
     out_obj: the artifact (configured target?) that should be produced
   """
-  args = [
-      ctx.file.go_tool.path, "tool", "asm",
-      "-I", ctx.file.go_include.path,
+  env = go_environment_vars(ctx)
+  includes = [source.dirname]
+  for h in hdrs:
+    if h.dirname not in includes:
+      includes += [h.dirname]
+  args = [ctx.file.go_tool.path, "tool", "asm"]
+  for i in includes + [ctx.file.go_include.path]:
+    args += ["-I", i]
+  args += [
+      "-D", "GOOS_" + env["GOOS"],
+      "-D", "GOARCH_" + env["GOARCH"],
       "-o", out_obj.path,
       source.path,
   ]
@@ -140,12 +163,84 @@ def emit_go_asm_action(ctx, source, out_obj):
   f = _emit_generate_params_action(cmds, ctx, out_obj.path + ".GoAsmCompileFile.params")
 
   ctx.action(
-      inputs = [source] + ctx.files.toolchain,
+      inputs = [source] + list(hdrs) + ctx.files.toolchain,
       outputs = [out_obj],
       mnemonic = "GoAsmCompile",
       executable = f,
   )
 
+def emit_go_pack_action(ctx, in_lib, objects, out_lib):
+  """Construct the command line for adding object files to a Go archive.
+
+  Args:
+    ctx: The skylark Context.
+    in_lib: the archive compiled from the Go sources
+    objects: an iterable of object files to be added to the archive
+    out_lib: the artifact that should be produced
+  """
+  cmds = [
+      "export GOROOT=$(pwd)/" + ctx.file.go_tool.dirname + "/..",
+      "cp -f %s %s" % (in_lib.path, out_lib.path),
+      "chmod +w " + out_lib.path,
+      " ".join([ctx.file.go_tool.path, "tool", "pack", "r", out_lib.path] +
+               [o.path for o in objects]),
+  ]
+
+  f = _emit_generate_params_action(cmds, ctx, out_lib.path + ".GoPackFile.params")
+
+  ctx.action(
+      inputs = [in_lib] + list(objects) + ctx.files.toolchain,
+      outputs = [out_lib],
+      mnemonic = "GoPack",
+      executable = f,
+  )
+
+def _matches_platform(ctx, src):
+  """Returns whether the GOOS and GOARCH suffixes of the file name of src, if
+  any, match the target platform, as in go/build.
+  """
+  env = go_environment_vars(ctx)
+  name = src.basename
+  if name.find(".") >= 0:
+    name = name[:name.find(".")]
+  if name.find("_") < 0:
+    return True
+  parts = name[name.find("_"):].split("_")
+  if parts[-1] == "test":
+    parts = parts[:-1]
+  n = len(parts)
+  if n >= 2 and parts[n-2] in _GOOS_LIST and parts[n-1] in _GOARCH_LIST:
+    return parts[n-2] == env["GOOS"] and parts[n-1] == env["GOARCH"]
+  if n >= 1 and parts[n-1] in _GOOS_LIST:
+    return parts[n-1] == env["GOOS"]
+  if n >= 1 and parts[n-1] in _GOARCH_LIST:
+    return parts[n-1] == env["GOARCH"]
+  return True
+
+def _go_prefix_relative_package(ctx):
+  """Returns the package of the rule relative to the package of its go_prefix.
+
+  A go_prefix declared in a subpackage, e.g. at the root of a subtree which
+  holds a separate Go project, is the importpath of that package. So the
+  packages under it are imported by their paths relative to it.
+
+  Args:
+    ctx: The skylark Context
+
+  Returns:
+    The relative package, or the package itself if it is not under the
+    package of its go_prefix
+  """
+  package = ctx.label.package
+  root = ctx.attr.go_prefix.label.package
+  if not root:
+    return package
+  if package == root:
+    return ""
+  if package.startswith(root + "/"):
+    return package[len(root) + 1:]
+  return package
+
 def _go_importpath(ctx):
   """Returns the expected importpath of the go_library being built.
 
@@ -156,9 +251,13 @@ def _go_importpath(ctx):
     Go importpath of the library
   """
   path = _go_prefix(ctx)[:-1]
-  if ctx.label.package:
-    path += "/" + ctx.label.package
-  if ctx.label.name != _DEFAULT_LIB:
+  package = _go_prefix_relative_package(ctx)
+  if package:
+    path += "/" + package
+  # A go_library with dir_importpath set, e.g. //foo/bar:bar as named by
+  # "gazelle -naming=dir", has the importpath of its directory, like
+  # go_default_library.
+  if ctx.label.name != _DEFAULT_LIB and not getattr(ctx.attr, "dir_importpath", False):
     path += "/" + ctx.label.name
   if path.rfind(_VENDOR_PREFIX) != -1:
     path = path[len(_VENDOR_PREFIX) + path.rfind(_VENDOR_PREFIX):]
@@ -166,7 +265,8 @@ def _go_importpath(ctx):
     path = path[1:]
   return path
 
-def emit_go_compile_action(ctx, sources, deps, out_lib, extra_objects=[]):
+def emit_go_compile_action(ctx, sources, deps, out_lib, extra_objects=[],
+                           asmhdr=None):
   """Construct the command line for compiling Go code.
   Constructs a symlink tree to accommodate for workspace name.
 
@@ -178,6 +278,8 @@ def emit_go_compile_action(ctx, sources, deps, out_lib, extra_objects=[]):
     out_lib: the artifact (configured target?) that should be produced
     extra_objects: an iterable of extra object files to be added to the
       output archive file.
+    asmhdr: if given, the artifact of the go_asm.h header that should be
+      written for assembly sources.
   """
   tree_layout = {}
   inputs = []
@@ -202,6 +304,10 @@ def emit_go_compile_action(ctx, sources, deps, out_lib, extra_objects=[]):
       "-o", ('../' * out_depth) + out_lib.path, "-pack",
       "-I", "."
   ]
+  outputs = [out_lib]
+  if asmhdr:
+    args += ["-asmhdr", ('../' * out_depth) + asmhdr.path]
+    outputs += [asmhdr]
 
   # Set -p to the import path of the library, ie.
   # (ctx.label.package + "/" ctx.label.name) for now.
@@ -219,7 +325,7 @@ def emit_go_compile_action(ctx, sources, deps, out_lib, extra_objects=[]):
 
   ctx.action(
       inputs = inputs + extra_inputs,
-      outputs = [out_lib],
+      outputs = outputs,
       mnemonic = "GoCompile",
       executable = f,
       env = go_environment_vars(ctx))
@@ -227,9 +333,11 @@ def emit_go_compile_action(ctx, sources, deps, out_lib, extra_objects=[]):
 def go_library_impl(ctx):
   """Implements the go_library() rule."""
 
-  sources = set(ctx.files.srcs)
+  sources = set([s for s in ctx.files.srcs if _matches_platform(ctx, s)])
   go_srcs = set([s for s in sources if s.basename.endswith('.go')])
   asm_srcs = [s for s in sources if s.basename.endswith('.s') or s.basename.endswith('.S')]
+  asm_hdrs = [s for s in ctx.files.srcs if s.basename.endswith('.h')]
+  asmhdr = ctx.attr.asmhdr
   deps = ctx.attr.deps
 
   cgo_object = None
@@ -239,6 +347,8 @@ def go_library_impl(ctx):
   if ctx.attr.library:
     go_srcs += ctx.attr.library.go_sources
     asm_srcs += ctx.attr.library.asm_sources
+    asm_hdrs += ctx.attr.library.asm_headers
+    asmhdr = asmhdr or ctx.attr.library.asmhdr
     deps += ctx.attr.library.direct_deps
     if ctx.attr.library.cgo_object:
       if cgo_object:
@@ -255,14 +365,28 @@ def go_library_impl(ctx):
     transitive_cgo_deps += cgo_object.cgo_deps
 
   extra_objects = [cgo_object.cgo_obj] if cgo_object else []
-  for src in asm_srcs:
-    obj = ctx.new_file(src, "%s.dir/%s.o" % (ctx.label.name, src.basename[:-2]))
-    emit_go_asm_action(ctx, src, obj)
-    extra_objects += [obj]
-
   out_lib = ctx.outputs.lib
-  emit_go_compile_action(ctx, go_srcs, deps, out_lib,
-                         extra_objects=extra_objects)
+  if asmhdr and asm_srcs:
+    # The assembly sources include go_asm.h, which the compiler writes. So
+    # they are assembled after the Go sources are compiled, and packed into
+    # the archive by a separate action.
+    go_lib = ctx.new_file("%s.dir/_go_.a" % ctx.label.name)
+    go_asm_h = ctx.new_file("%s.dir/go_asm.h" % ctx.label.name)
+    emit_go_compile_action(ctx, go_srcs, deps, go_lib,
+                           extra_objects=extra_objects, asmhdr=go_asm_h)
+    asm_objects = []
+    for src in asm_srcs:
+      obj = ctx.new_file(src, "%s.dir/%s.o" % (ctx.label.name, src.basename[:-2]))
+      emit_go_asm_action(ctx, src, asm_hdrs + [go_asm_h], obj)
+      asm_objects += [obj]
+    emit_go_pack_action(ctx, go_lib, asm_objects, out_lib)
+  else:
+    for src in asm_srcs:
+      obj = ctx.new_file(src, "%s.dir/%s.o" % (ctx.label.name, src.basename[:-2]))
+      emit_go_asm_action(ctx, src, asm_hdrs, obj)
+      extra_objects += [obj]
+    emit_go_compile_action(ctx, go_srcs, deps, out_lib,
+                           extra_objects=extra_objects)
 
   transitive_libs = set([out_lib])
   transitive_importmap = {out_lib.path: _go_importpath(ctx)}
@@ -283,6 +407,8 @@ def go_library_impl(ctx):
     runfiles = runfiles,
     go_sources = go_srcs,
     asm_sources = asm_srcs,
+    asm_headers = asm_hdrs,
+    asmhdr = asmhdr,
     go_library_object = out_lib,
     transitive_go_library_object = transitive_libs,
     cgo_object = cgo_object,
@@ -514,7 +640,8 @@ go_library_attrs = go_env_attrs + {
         allow_files = True,
         cfg = "data",
     ),
-    "srcs": attr.label_list(allow_files = go_filetype),
+    "srcs": attr.label_list(allow_files = go_library_filetype),
+    "asmhdr": attr.bool(default = False),
     "deps": attr.label_list(
         providers = [
             "direct_deps",
@@ -525,7 +652,13 @@ go_library_attrs = go_env_attrs + {
         ],
     ),
     "library": attr.label(
-        providers = ["go_sources", "asm_sources", "cgo_object"],
+        providers = [
+            "go_sources",
+            "asm_sources",
+            "asm_headers",
+            "asmhdr",
+            "cgo_object",
+        ],
     ),
 }
 
@@ -545,6 +678,7 @@ go_library = rule(
         "cgo_object": attr.label(
             providers = ["cgo_obj", "cgo_deps"],
         ),
+        "dir_importpath": attr.bool(default = False),
     },
     fragments = ["cpp"],
     outputs = go_library_outputs,
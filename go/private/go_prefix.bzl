@@ -32,7 +32,11 @@ _go_prefix_rule = rule(
 )
 
 def go_prefix(prefix):
-  """go_prefix sets the Go import name to be used for this workspace."""
+  """go_prefix sets the Go import name to be used for this workspace.
+
+  A go_prefix declared in a subpackage sets the import name of that package
+  instead. The Go rules under it use it through their go_prefix attribute.
+  """
   _go_prefix_rule(name = "go_prefix",
     prefix = prefix,
     visibility = ["//visibility:public" ]
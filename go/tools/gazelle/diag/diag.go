@@ -0,0 +1,88 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package diag provides structured diagnostics for problems gazelle finds in
+// existing BUILD files and in the Go sources they are generated from.
+package diag
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	bzl "github.com/bazelbuild/buildifier/core"
+)
+
+// An Error describes a problem in a BUILD file or a source file.
+type Error struct {
+	// Path is the path to the file.
+	Path string
+	// Line and Column locate the problem in the file. They are 1-based and
+	// zero if unknown.
+	Line, Column int
+	// Rule is the offending rule or statement, if any.
+	Rule string
+	// Msg describes the problem.
+	Msg string
+	// Fix suggests how to fix the problem, if gazelle knows how.
+	Fix string
+}
+
+// New returns an Error about the expression "x" in the file at "path".
+func New(path string, x bzl.Expr, msg string) *Error {
+	start, _ := x.Span()
+	return &Error{
+		Path:   path,
+		Line:   start.Line,
+		Column: start.LineRune,
+		Rule:   bzl.FormatString(x),
+		Msg:    msg,
+	}
+}
+
+// parseErrorPos matches the "file:line:column: " prefix which bzl.Parse puts
+// on syntax errors.
+var parseErrorPos = regexp.MustCompile(`(?s)^.*?:(\d+):(\d+): (.*)$`)
+
+// ParseError converts an error returned by bzl.Parse for the file at "path"
+// into an Error.
+func ParseError(path string, err error) *Error {
+	e := &Error{
+		Path: path,
+		Msg:  err.Error(),
+		Fix:  "fix the syntax error; gazelle does not update a BUILD file it cannot parse",
+	}
+	if m := parseErrorPos.FindStringSubmatch(err.Error()); m != nil {
+		e.Line, _ = strconv.Atoi(m[1])
+		e.Column, _ = strconv.Atoi(m[2])
+		e.Msg = m[3]
+	}
+	return e
+}
+
+func (e *Error) Error() string {
+	pos := e.Path
+	if e.Line > 0 {
+		pos = fmt.Sprintf("%s:%d:%d", pos, e.Line, e.Column)
+	}
+	msg := fmt.Sprintf("%s: %s", pos, e.Msg)
+	if e.Rule != "" {
+		msg += fmt.Sprintf("\n\tin: %s", e.Rule)
+	}
+	if e.Fix != "" {
+		msg += fmt.Sprintf("\n\tfix: %s", e.Fix)
+	}
+	return msg
+}
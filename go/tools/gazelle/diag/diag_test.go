@@ -0,0 +1,58 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diag
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseError(t *testing.T) {
+	err := errors.New("foo/BUILD:3:7: syntax error near )")
+	e := ParseError("foo/BUILD", err)
+	if got, want := *e, (Error{
+		Path:   "foo/BUILD",
+		Line:   3,
+		Column: 7,
+		Msg:    "syntax error near )",
+		Fix:    e.Fix,
+	}); got != want {
+		t.Errorf("ParseError(%q, %v) = %#v; want %#v", "foo/BUILD", err, got, want)
+	}
+}
+
+func TestParseErrorWithoutPosition(t *testing.T) {
+	err := errors.New("unexpected EOF")
+	e := ParseError("BUILD", err)
+	if got, want := e.Error(), "BUILD: unexpected EOF\n\tfix: "+e.Fix; got != want {
+		t.Errorf("ParseError(%q, %v).Error() = %q; want %q", "BUILD", err, got, want)
+	}
+}
+
+func TestErrorString(t *testing.T) {
+	e := &Error{
+		Path:   "BUILD",
+		Line:   1,
+		Column: 1,
+		Rule:   `go_prefix("a", "b")`,
+		Msg:    "go_prefix takes exactly one argument",
+		Fix:    `go_prefix("a")`,
+	}
+	want := "BUILD:1:1: go_prefix takes exactly one argument\n\tin: go_prefix(\"a\", \"b\")\n\tfix: go_prefix(\"a\")"
+	if got := e.Error(); got != want {
+		t.Errorf("e.Error() = %q; want %q", got, want)
+	}
+}
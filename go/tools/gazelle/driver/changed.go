@@ -0,0 +1,201 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	bzl "github.com/bazelbuild/buildifier/core"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/label"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/packages"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/rules"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/wspace"
+)
+
+// ChangedPackages returns the directories of the packages whose BUILD files
+// need to be regenerated after the files in "changed" have been modified,
+// added or deleted, e.g. as listed by "git diff --name-only". Relative paths
+// in "changed" are relative to "repoRoot". Labels qualified with "repoName",
+// as written with Config.QualifyLabels, are labels of the repository itself;
+// "repoName" may be empty.
+//
+// The directories are those which contain the changed files and still hold
+// a Go package. A directory which no longer does, because its package was
+// moved or deleted, is replaced with the directories whose BUILD files
+// reference labels in it or under it. Such a directory is one whose BUILD
+// file still declares Go rules, or, if the directory or its BUILD file is
+// gone, one with a changed Go file. Other changes in directories without Go
+// packages, e.g. to documentation, affect no package, and neither do changes
+// in directories which packages.Walk skips. The directories are absolute and
+// sorted.
+func ChangedPackages(repoRoot, repoName string, changed []string) ([]string, error) {
+	repoRoot, err := filepath.Abs(repoRoot)
+	if err != nil {
+		return nil, err
+	}
+	dirs := make(map[string]bool)
+	seen := make(map[string]bool)
+	var deleted []string
+	for _, f := range changed {
+		if !filepath.IsAbs(f) {
+			f = filepath.Join(repoRoot, f)
+		}
+		dir := filepath.Dir(filepath.Clean(f))
+		rel, err := filepath.Rel(repoRoot, dir)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("changed file %s is not under the repository root %s", f, repoRoot)
+		}
+		if rel == "." {
+			rel = ""
+		}
+		rel = filepath.ToSlash(rel)
+		if dirs[dir] || seen[dir] || packages.Skipped(repoRoot, rel) {
+			continue
+		}
+		if isGoPackage(dir) {
+			dirs[dir] = true
+			continue
+		}
+		hasRules, err := hasGoRules(filepath.Join(dir, "BUILD"))
+		if os.IsNotExist(err) {
+			hasRules, err = filepath.Ext(f) == ".go", nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !hasRules {
+			continue
+		}
+		seen[dir] = true
+		deleted = append(deleted, rel)
+	}
+
+	if len(deleted) > 0 {
+		refs, err := referencingDirs(repoRoot, repoName, deleted)
+		if err != nil {
+			return nil, err
+		}
+		for _, dir := range refs {
+			dirs[dir] = true
+		}
+	}
+
+	var list []string
+	for dir := range dirs {
+		list = append(list, dir)
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+// isGoPackage determines if the directory "dir" exists and contains
+// buildable Go files.
+func isGoPackage(dir string) bool {
+	bctx := build.Default
+	bctx.GOROOT = ""
+	bctx.GOPATH = ""
+	_, err := bctx.ImportDir(dir, build.ImportComment)
+	if err == nil {
+		return true
+	}
+	_, ok := err.(*build.MultiplePackageError)
+	return ok
+}
+
+// hasGoRules determines if the BUILD file at "path" declares Go rules. A file
+// which cannot be parsed is assumed to declare some.
+func hasGoRules(path string) (bool, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	f, err := bzl.Parse(path, b)
+	if err != nil {
+		return true, nil
+	}
+	for _, r := range f.Rules("") {
+		if kind := r.Kind(); strings.HasPrefix(kind, "go_") || kind == "cgo_library" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// referencingDirs returns the directories under "repoRoot" whose BUILD files
+// reference a label in one of the Bazel packages "pkgs" or under them.
+func referencingDirs(repoRoot, repoName string, pkgs []string) ([]string, error) {
+	var dirs []string
+	err := filepath.Walk(repoRoot, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if base := info.Name(); p != repoRoot && (base[0] == '.' || base[0] == '_' || base == "testdata" || wspace.IsRoot(p)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Name() != "BUILD" {
+			return nil
+		}
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		f, err := bzl.Parse(p, b)
+		if err != nil {
+			// The file is reported when its package is generated.
+			return nil
+		}
+		if referencesAny(f, repoName, pkgs) {
+			dirs = append(dirs, filepath.Dir(p))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dirs, nil
+}
+
+// referencesAny determines if a rule in "f" has an attribute with a label in
+// one of the Bazel packages "pkgs" or under them. Labels in the repository
+// "repoName" are in the current one.
+func referencesAny(f *bzl.File, repoName string, pkgs []string) bool {
+	found := false
+	for _, r := range f.Rules("") {
+		for _, key := range r.AttrKeys() {
+			rules.WalkStrings(r.Attr(key), func(s *bzl.StringExpr) {
+				l, err := label.Parse(s.Value)
+				if err != nil || l.Repo != "" && l.Repo != repoName || l.Relative {
+					return
+				}
+				for _, pkg := range pkgs {
+					if l.Pkg == pkg || strings.HasPrefix(l.Pkg, pkg+"/") {
+						found = true
+					}
+				}
+			})
+		}
+	}
+	return found
+}
@@ -0,0 +1,83 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestChangedPackages(t *testing.T) {
+	repo := writeRepo(t, map[string]string{
+		"WORKSPACE":        "",
+		"BUILD":            `go_prefix("example.com/repo")` + "\n",
+		"lib/lib.go":       "package lib\n",
+		"lib/README.md":    "",
+		"bin/main.go":      "package main\n",
+		"bin/BUILD":        `go_binary(name = "bin", srcs = ["main.go"], deps = ["//old:go_default_library"])` + "\n",
+		"other/other.go":   "package other\n",
+		"other/BUILD":      `go_library(name = "go_default_library", deps = ["//old/sub"])` + "\n",
+		"unrelated/u.go":   "package unrelated\n",
+		"unrelated/BUILD":  `go_library(name = "go_default_library", deps = ["//oldish:go_default_library"])` + "\n",
+		"docs/BUILD":       `filegroup(name = "docs", srcs = ["README.md"])` + "\n",
+		"site/BUILD":       `filegroup(name = "site", srcs = ["//docs", "//gone:notes"])` + "\n",
+		"stale/BUILD":      `go_library(name = "go_default_library", srcs = ["stale.go"])` + "\n",
+		"user/BUILD":       `go_library(name = "go_default_library", deps = ["//stale:go_default_library"])` + "\n",
+		"qualified/q.go":   "package qualified\n",
+		"qualified/BUILD":  `go_library(name = "go_default_library", deps = ["@com_example_repo//moved:go_default_library"])` + "\n",
+		"external/BUILD":   `go_library(name = "go_default_library", deps = ["@other//moved:go_default_library"])` + "\n",
+		"testdata/x/x.go":  "package x\n",
+		"_hidden/h/h.go":   "package h\n",
+		"nested/WORKSPACE": "",
+		"nested/pkg/p.go":  "package pkg\n",
+	})
+	defer os.RemoveAll(repo)
+
+	changed := []string{
+		"lib/lib.go",
+		filepath.Join(repo, "lib", "README.md"),
+		"old/old.go",
+		"new/new.go",
+		"docs/README.md",
+		"gone/notes.txt",
+		"stale/README.md",
+		"moved/moved.go",
+		"testdata/x/x.go",
+		"_hidden/h/h.go",
+		"nested/pkg/p.go",
+	}
+	got, err := ChangedPackages(repo, "com_example_repo", changed)
+	if err != nil {
+		t.Fatalf("ChangedPackages(%q, %q) failed with %v; want success", repo, changed, err)
+	}
+	want := []string{
+		filepath.Join(repo, "bin"),
+		filepath.Join(repo, "lib"),
+		filepath.Join(repo, "other"),
+		filepath.Join(repo, "qualified"),
+		filepath.Join(repo, "user"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ChangedPackages(%q, %q) = %q; want %q", repo, changed, got, want)
+	}
+
+	changed = []string{"../elsewhere/x.go"}
+	if got, err := ChangedPackages(repo, "", changed); err == nil {
+		t.Errorf("ChangedPackages(%q, %q) = %q; want error", repo, changed, got)
+	}
+}
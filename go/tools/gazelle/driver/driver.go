@@ -0,0 +1,386 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package driver runs gazelle in-process. It generates BUILD files for the
+// Go packages in a repository and merges them into the existing ones, like
+// the gazelle command does, but returns the results to the caller instead
+// of writing them out.
+package driver
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	bzl "github.com/bazelbuild/buildifier/core"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/diag"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/generator"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/merger"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/packages"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/rules"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/wspace"
+)
+
+// Config configures a run of gazelle.
+type Config struct {
+	// RepoRoot is the root directory of the repository. It is required.
+	RepoRoot string
+	// GoPrefix is the go_prefix of the repository. If empty, it is read from
+	// the BUILD file in RepoRoot, or else inferred by
+	// generator.InferGoPrefix.
+	GoPrefix string
+	// Dirs are the directories to generate BUILD files for, together with
+	// their subdirectories. They must be RepoRoot or under it. If empty,
+	// RepoRoot is used.
+	Dirs []string
+	// KeepGoing makes Run skip packages it fails to import or to generate
+	// rules for, instead of returning an error.
+	KeepGoing bool
+	// UseImportComments is passed to generator.Generator.
+	UseImportComments bool
+	// StdPackages is passed to generator.Generator.
+	StdPackages map[string]bool
+	// Macros maps the name of a macro to the kind of the Go rule it wraps.
+	Macros map[string]string
+	// Naming is the convention for naming libraries and tests.
+	Naming rules.Naming
+	// Plugins generate rules of other kinds than the Go rules in the same
+	// pass. Existing rules of their kinds are merged as their
+	// rules.KindInfo tell.
+	Plugins []rules.Plugin
+	// GoGenerate is passed to generator.Generator.
+	GoGenerate bool
+	// FollowSymlinks is passed to generator.Generator.
+	FollowSymlinks bool
+	// ChangedFiles are files which have been modified, added or deleted. If
+	// set, Run generates BUILD files only for the packages ChangedPackages
+	// returns for them, instead of the ones in Dirs, and reads the Go files
+	// of no other packages. See generator.Generator.Incremental.
+	ChangedFiles []string
+	// CheckRepositories is passed to generator.Generator.
+	CheckRepositories bool
+	// Unresolved tells what to do with imports which cannot be resolved.
+	// With rules.UnresolvedError, the BUILD files of the packages which have
+	// such imports are Skipped. Either way, the imports are listed in
+	// Result.Unresolved.
+	Unresolved rules.UnresolvedPolicy
+	// QualifyLabels makes labels of targets in the repository fully
+	// qualified with RepoName, e.g. "@repo//pkg:name". See also
+	// rules.Config.RepoName.
+	QualifyLabels bool
+	// RepoName is the name of the repository. If empty, it is read from
+	// workspace(name = ...) in the WORKSPACE file in RepoRoot. It is used only
+	// with QualifyLabels.
+	RepoName string
+	// OutDir is the root of a tree which mirrors the repository, to write
+	// BUILD files into instead of the source tree, e.g. when the source tree
+	// is read-only. Each generated file is merged into the existing BUILD
+	// file in OutDir, or else into the one in the source tree. If empty, the
+	// BUILD files are written in the source tree.
+	OutDir string
+	// RepairGoPrefix is passed to merger.Options. It should be set only when
+	// the merged files are written back, as in gazelle's fix mode.
+	RepairGoPrefix bool
+	// InferTestOnly is passed to generator.Generator.
+	InferTestOnly bool
+}
+
+// Status describes how the merged BUILD file differs from the file on disk.
+type Status int
+
+const (
+	// Unchanged means the merged file has the same content as the existing
+	// one.
+	Unchanged Status = iota
+	// Modified means the merged file differs from the existing one.
+	Modified
+	// Created means there is no existing file.
+	Created
+	// Skipped means the file could not be merged. File.Err tells why.
+	Skipped
+)
+
+func (s Status) String() string {
+	switch s {
+	case Unchanged:
+		return "unchanged"
+	case Modified:
+		return "modified"
+	case Created:
+		return "created"
+	case Skipped:
+		return "skipped"
+	}
+	return fmt.Sprintf("Status(%d)", int(s))
+}
+
+// File is the result of a run of gazelle for a BUILD file.
+type File struct {
+	// Path is the absolute path of the BUILD file, in Config.OutDir if it is
+	// set.
+	Path string
+	// Generated is the file generated from the Go package.
+	Generated *bzl.File
+	// Merged is Generated merged into the existing file and formatted.
+	// It is nil if Status is Skipped.
+	Merged *bzl.File
+	// Content is Merged formatted as it would be written to Path.
+	Content []byte
+	Status  Status
+	// Err is the problem which made gazelle skip the file. It is a
+	// *diag.Error if the existing file cannot be parsed.
+	Err error
+}
+
+// Result is the result of Run.
+type Result struct {
+	// RepoRoot is the absolute path of Config.RepoRoot.
+	RepoRoot string
+	// GoPrefix is the go_prefix used for the run.
+	GoPrefix string
+	// GoPrefixSource describes where GoPrefix was inferred from. It is empty
+	// if GoPrefix was given in Config or read from the root BUILD file.
+	GoPrefixSource string
+	// Files has an entry for each BUILD file gazelle generated.
+	Files []*File
+	// PackageErrors lists the packages skipped with Config.KeepGoing.
+	PackageErrors packages.ErrorList
+	// Unresolved lists the imports which could not be resolved, once for
+	// each importing package.
+	Unresolved []rules.UnresolvedImport
+	// Diagnostics lists the problems found in the sources and existing
+	// BUILD files which did not keep gazelle from generating the files.
+	Diagnostics []*diag.Error
+}
+
+// Problems returns the number of skipped files and packages, and of
+// diagnostics.
+func (r *Result) Problems() int {
+	n := len(r.PackageErrors) + len(r.Diagnostics)
+	for _, f := range r.Files {
+		if f.Status == Skipped {
+			n++
+		}
+	}
+	return n
+}
+
+// Run generates and merges the BUILD files for the packages in c.Dirs, or
+// the packages affected by c.ChangedFiles. It does not modify any file.
+//
+// Existing BUILD files which cannot be parsed are reported as Skipped files.
+// With c.KeepGoing, so are files which fail to merge for any other reason,
+// and packages which fail are listed in Result.PackageErrors. Other errors
+// stop the run and are returned.
+func Run(c Config) (Result, error) {
+	if c.RepoRoot == "" {
+		return Result{}, fmt.Errorf("repository root not set")
+	}
+	repoRoot, err := filepath.Abs(c.RepoRoot)
+	if err != nil {
+		return Result{}, err
+	}
+	outDir := repoRoot
+	if c.OutDir != "" {
+		if outDir, err = filepath.Abs(c.OutDir); err != nil {
+			return Result{}, err
+		}
+	}
+	res := Result{RepoRoot: repoRoot, GoPrefix: c.GoPrefix}
+	if res.GoPrefix == "" {
+		if res.GoPrefix, res.GoPrefixSource, err = GoPrefix(repoRoot); err != nil {
+			return Result{}, err
+		}
+	}
+
+	g, err := generator.New(repoRoot, res.GoPrefix)
+	if err != nil {
+		return Result{}, err
+	}
+	g.KeepGoing = c.KeepGoing
+	g.UseImportComments = c.UseImportComments
+	g.StdPackages = c.StdPackages
+	g.Plugins = c.Plugins
+	g.Naming = c.Naming
+	g.GoGenerate = c.GoGenerate
+	g.FollowSymlinks = c.FollowSymlinks
+	g.CheckRepositories = c.CheckRepositories
+	g.Unresolved = c.Unresolved
+	g.CollectUnresolved = true
+	g.CollectDiagnostics = true
+	g.Incremental = len(c.ChangedFiles) > 0
+	g.InferTestOnly = c.InferTestOnly
+	if c.QualifyLabels {
+		if g.RepoName, err = repoName(repoRoot, c.RepoName); err != nil {
+			return Result{}, err
+		}
+	}
+
+	opts := merger.Options{
+		Macros:         c.Macros,
+		MergeableAttrs: make(map[string][]string),
+		RepairGoPrefix: c.RepairGoPrefix,
+	}
+	for kind, info := range rules.PluginKinds(c.Plugins) {
+		opts.MergeableAttrs[kind] = info.MergeableAttrs
+	}
+	if c.GoGenerate {
+		opts.MergeableAttrs["genrule"] = []string{"srcs", "outs", "cmd", "tools"}
+	}
+	if len(g.MappedKinds) > 0 {
+		opts.MappedKinds = make(map[string]string)
+		for _, m := range g.MappedKinds {
+			opts.MappedKinds[m.From] = m.To
+		}
+	}
+
+	dirs, generate := c.Dirs, g.Generate
+	switch {
+	case len(c.ChangedFiles) > 0:
+		if dirs, err = ChangedPackages(repoRoot, g.RepoName, c.ChangedFiles); err != nil {
+			return Result{}, err
+		}
+		generate = g.GeneratePackage
+	case len(dirs) == 0:
+		dirs = []string{repoRoot}
+	}
+	for _, d := range dirs {
+		files, err := generate(d)
+		if errs, ok := err.(packages.ErrorList); ok {
+			res.PackageErrors = append(res.PackageErrors, errs...)
+		} else if err != nil {
+			return Result{}, err
+		}
+		var unresolved map[string]unresolvedError
+		if c.Unresolved == rules.UnresolvedError {
+			unresolved = make(map[string]unresolvedError)
+			for _, u := range g.UnresolvedImports() {
+				unresolved[u.Dir] = append(unresolved[u.Dir], u)
+			}
+		}
+		for _, f := range files {
+			rel := filepath.ToSlash(filepath.Dir(f.Path))
+			if rel == "." {
+				rel = ""
+			}
+			existing := filepath.Join(repoRoot, f.Path)
+			f.Path = filepath.Join(outDir, f.Path)
+			if errs, ok := unresolved[rel]; ok {
+				res.Files = append(res.Files, &File{Path: f.Path, Generated: f, Status: Skipped, Err: errs})
+				continue
+			}
+			if _, err := os.Stat(f.Path); !os.IsNotExist(err) {
+				existing = f.Path
+			}
+			fileOpts := opts
+			fileOpts.OwnVisibility = g.OwnsVisibility(rel)
+			fileOpts.Pkg = rel
+			file, err := mergeFile(f, existing, fileOpts)
+			if err != nil {
+				if _, ok := err.(*diag.Error); !ok && !c.KeepGoing {
+					return Result{}, err
+				}
+				file = &File{Path: f.Path, Generated: f, Status: Skipped, Err: err}
+			}
+			res.Files = append(res.Files, file)
+		}
+	}
+	res.Unresolved = g.UnresolvedImports()
+	res.Diagnostics = g.Diagnostics()
+	return res, nil
+}
+
+// unresolvedError lists the imports of a package which could not be
+// resolved.
+type unresolvedError []rules.UnresolvedImport
+
+func (e unresolvedError) Error() string {
+	msgs := []string{"unresolved imports:"}
+	for _, u := range e {
+		msgs = append(msgs, fmt.Sprintf("\t%q: %v", u.ImportPath, u.Err))
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// GoPrefix reads the go_prefix of the repository at "repoRoot" from its root
+// BUILD file, or else infers it. "source" describes where an inferred
+// go_prefix comes from, and is empty if it was read from the BUILD file.
+func GoPrefix(repoRoot string) (prefix, source string, err error) {
+	prefix, err = generator.LoadGoPrefix(repoRoot)
+	if err == nil {
+		return prefix, "", nil
+	}
+	if err != generator.ErrNoGoPrefix && !os.IsNotExist(err) {
+		return "", "", err
+	}
+	if prefix, source, err = generator.InferGoPrefix(repoRoot); err != nil {
+		return "", "", fmt.Errorf("go_prefix not set, no go_prefix in root BUILD file, and %v", err)
+	}
+	return prefix, source, nil
+}
+
+// repoName returns "name", or the name declared in the WORKSPACE file in
+// "repoRoot" if "name" is empty.
+func repoName(repoRoot, name string) (string, error) {
+	if name != "" {
+		return name, nil
+	}
+	name, err := wspace.Name(repoRoot)
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		return "", fmt.Errorf("repository name not set, and %s does not declare one with workspace(name = ...)", filepath.Join(repoRoot, "WORKSPACE"))
+	}
+	return name, nil
+}
+
+// mergeFile merges "f" into the existing BUILD file at "existing", which may
+// differ from f.Path if the file is written into Config.OutDir.
+func mergeFile(f *bzl.File, existing string, opts merger.Options) (*File, error) {
+	// MergeWithFile changes the rules of "f" while merging them, and may
+	// return "f" itself, so keep a deep copy of it.
+	generated, err := bzl.Parse(f.Path, bzl.Format(f))
+	if err != nil {
+		return nil, err
+	}
+	merged, err := merger.MergeWithFile(f, existing, opts)
+	if err != nil {
+		return nil, err
+	}
+	merged.Path = f.Path
+	bzl.Rewrite(merged, nil) // have buildifier 'format' our rules.
+	file := &File{
+		Path:      f.Path,
+		Generated: generated,
+		Merged:    merged,
+		Content:   bzl.Format(merged),
+	}
+	old, err := ioutil.ReadFile(f.Path)
+	switch {
+	case os.IsNotExist(err):
+		file.Status = Created
+	case err != nil:
+		return nil, err
+	case bytes.Equal(old, file.Content):
+		file.Status = Unchanged
+	default:
+		file.Status = Modified
+	}
+	return file, nil
+}
@@ -0,0 +1,247 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	bzl "github.com/bazelbuild/buildifier/core"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/diag"
+)
+
+func writeRepo(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func statuses(res Result) map[string]Status {
+	m := make(map[string]Status)
+	for _, f := range res.Files {
+		rel, _ := filepath.Rel(res.RepoRoot, f.Path)
+		m[filepath.ToSlash(rel)] = f.Status
+	}
+	return m
+}
+
+func TestRun(t *testing.T) {
+	repo := writeRepo(t, map[string]string{
+		"WORKSPACE":   "",
+		"BUILD":       `go_prefix("example.com/repo")` + "\n",
+		"lib/lib.go":  "package lib\n",
+		"bin/main.go": "package main\n\nimport _ \"example.com/repo/lib\"\n",
+		"bad/BUILD":   "go_library(\n",
+		"bad/bad.go":  "package bad\n",
+	})
+	defer os.RemoveAll(repo)
+
+	res, err := Run(Config{RepoRoot: repo})
+	if err != nil {
+		t.Fatalf("Run failed with %v; want success", err)
+	}
+	if res.GoPrefix != "example.com/repo" || res.GoPrefixSource != "" {
+		t.Errorf("GoPrefix, GoPrefixSource = %q, %q; want %q, %q", res.GoPrefix, res.GoPrefixSource, "example.com/repo", "")
+	}
+	got := statuses(res)
+	for rel, want := range map[string]Status{
+		"lib/BUILD": Created,
+		"bin/BUILD": Created,
+		"bad/BUILD": Skipped,
+	} {
+		if got[rel] != want {
+			t.Errorf("status of %s = %v; want %v", rel, got[rel], want)
+		}
+	}
+	if n := res.Problems(); n != 1 {
+		t.Errorf("res.Problems() = %d; want 1", n)
+	}
+	for _, f := range res.Files {
+		if f.Status == Skipped {
+			if _, ok := f.Err.(*diag.Error); !ok {
+				t.Errorf("%s: Err = %#v; want *diag.Error", f.Path, f.Err)
+			}
+			continue
+		}
+		if f.Generated == nil || f.Merged == nil {
+			t.Errorf("%s: Generated = %v, Merged = %v; want both set", f.Path, f.Generated, f.Merged)
+			continue
+		}
+		if err := ioutil.WriteFile(f.Path, f.Content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Once the merged files are written, running again changes nothing.
+	res, err = Run(Config{RepoRoot: repo})
+	if err != nil {
+		t.Fatalf("Run failed with %v; want success", err)
+	}
+	for rel, status := range statuses(res) {
+		if status != Unchanged && rel != "bad/BUILD" {
+			t.Errorf("status of %s after writing = %v; want %v", rel, status, Unchanged)
+		}
+	}
+}
+
+func TestRunDiagnostics(t *testing.T) {
+	repo := writeRepo(t, map[string]string{
+		"WORKSPACE": "",
+		"BUILD":     `go_prefix("example.com/repo")` + "\n",
+		"a/a.go":    "package a // import \"example.org/a\"\n",
+	})
+	defer os.RemoveAll(repo)
+
+	res, err := Run(Config{RepoRoot: repo})
+	if err != nil {
+		t.Fatalf("Run failed with %v; want success", err)
+	}
+	if got := statuses(res)["a/BUILD"]; got != Created {
+		t.Errorf("status of a/BUILD = %v; want %v", got, Created)
+	}
+	if len(res.Diagnostics) != 1 || res.Diagnostics[0].Path != filepath.Join(repo, "a", "a.go") {
+		t.Errorf("Diagnostics = %v; want one about %s", res.Diagnostics, filepath.Join(repo, "a", "a.go"))
+	}
+	if n := res.Problems(); n != 1 {
+		t.Errorf("res.Problems() = %d; want 1", n)
+	}
+}
+
+func TestRunUnknownDotlessImport(t *testing.T) {
+	repo := writeRepo(t, map[string]string{
+		"WORKSPACE":  "",
+		"BUILD":      `go_prefix("example.com/repo")` + "\n",
+		"lib/lib.go": "package lib\n\nimport _ \"mycorp/lib\"\n",
+	})
+	defer os.RemoveAll(repo)
+
+	// With the default policy, the import is reported, but it does not keep
+	// the BUILD file from being generated.
+	res, err := Run(Config{RepoRoot: repo})
+	if err != nil {
+		t.Fatalf("Run failed with %v; want success", err)
+	}
+	if got := statuses(res)["lib/BUILD"]; got != Created {
+		t.Errorf("status of lib/BUILD = %v; want %v", got, Created)
+	}
+	if len(res.Unresolved) != 0 {
+		t.Errorf("Unresolved = %v; want none", res.Unresolved)
+	}
+	if len(res.Diagnostics) != 1 || res.Diagnostics[0].Path != filepath.Join(repo, "lib", "BUILD") {
+		t.Errorf("Diagnostics = %v; want one about %s", res.Diagnostics, filepath.Join(repo, "lib", "BUILD"))
+	}
+}
+
+func TestRunKeepsGenerated(t *testing.T) {
+	repo := writeRepo(t, map[string]string{
+		"WORKSPACE":  "",
+		"BUILD":      `go_prefix("example.com/repo")` + "\n",
+		"lib/lib.go": "package lib\n",
+		"lib/BUILD":  `package(default_visibility = ["//visibility:public"])` + "\n",
+	})
+	defer os.RemoveAll(repo)
+
+	res, err := Run(Config{RepoRoot: repo})
+	if err != nil {
+		t.Fatalf("Run failed with %v; want success", err)
+	}
+	for _, f := range res.Files {
+		if filepath.Base(filepath.Dir(f.Path)) != "lib" {
+			continue
+		}
+		// The merge leaves out the default visibility, but only from the
+		// merged file.
+		for _, r := range f.Merged.Rules("go_library") {
+			if vis := r.AttrStrings("visibility"); vis != nil {
+				t.Errorf("visibility of the merged go_library = %q; want none", vis)
+			}
+		}
+		for _, r := range f.Generated.Rules("go_library") {
+			if vis, want := r.AttrStrings("visibility"), []string{"//visibility:public"}; !reflect.DeepEqual(vis, want) {
+				t.Errorf("visibility of the generated go_library = %q; want %q", vis, want)
+			}
+		}
+	}
+}
+
+func TestRunRequiresRepoRoot(t *testing.T) {
+	if _, err := Run(Config{}); err == nil {
+		t.Errorf("Run(Config{}) succeeded; want failure")
+	}
+}
+
+func TestRunOutDir(t *testing.T) {
+	repo := writeRepo(t, map[string]string{
+		"WORKSPACE":   "",
+		"BUILD":       `go_prefix("example.com/repo")` + "\n",
+		"lib/lib.go":  "package lib\n",
+		"lib/BUILD":   "go_library(\n    name = \"go_default_library\",\n    srcs = [\"lib.go\"],\n)\n",
+		"bin/main.go": "package main\n",
+	})
+	defer os.RemoveAll(repo)
+	out := writeRepo(t, map[string]string{
+		"bin/BUILD": "package(default_visibility = [\"//visibility:public\"])\n\ngo_binary(\n    name = \"bin\",\n    srcs = [\"main.go\"],\n)\n",
+	})
+	defer os.RemoveAll(out)
+
+	res, err := Run(Config{RepoRoot: repo, OutDir: out})
+	if err != nil {
+		t.Fatalf("Run failed with %v; want success", err)
+	}
+	got := make(map[string]Status)
+	for _, f := range res.Files {
+		rel, err := filepath.Rel(out, f.Path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			t.Errorf("%s is not in -out_dir %s", f.Path, out)
+			continue
+		}
+		got[filepath.ToSlash(rel)] = f.Status
+	}
+	// lib/BUILD is merged into the file in the source tree, but it is new in
+	// the output tree. bin/BUILD is merged into the file in the output tree.
+	if got["lib/BUILD"] != Created {
+		t.Errorf("status of lib/BUILD = %v; want %v", got["lib/BUILD"], Created)
+	}
+	if got["bin/BUILD"] == Created {
+		t.Errorf("status of bin/BUILD = %v; want it merged into the existing file", got["bin/BUILD"])
+	}
+	for _, f := range res.Files {
+		if strings.HasSuffix(f.Path, filepath.Join("lib", "BUILD")) && len(f.Merged.Rules("go_library")) != 1 {
+			t.Errorf("%s: got %d go_library rules; want 1", f.Path, len(f.Merged.Rules("go_library")))
+		}
+		// The default_visibility of the file in the output tree applies.
+		for _, r := range f.Merged.Rules("go_binary") {
+			if vis := r.Attr("visibility"); vis != nil {
+				t.Errorf("%s: visibility = %s; want none", f.Path, bzl.FormatString(vis))
+			}
+		}
+	}
+}
@@ -16,11 +16,267 @@ limitations under the License.
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 
 	bzl "github.com/bazelbuild/buildifier/core"
 )
 
-func fixFile(file *bzl.File) error {
-	return ioutil.WriteFile(file.Path, bzl.Format(file), 0644)
+// backupManifest is the name of the file in a backup directory which lists
+// the BUILD files the backup covers.
+const backupManifest = "MANIFEST.json"
+
+// A manifest describes a backup of BUILD files taken before fix mode
+// overwrote them.
+type manifest struct {
+	// RepoRoot is the absolute path to the repository root.
+	RepoRoot string       `json:"repo_root"`
+	Files    []backupFile `json:"files"`
+}
+
+// A backupFile is a BUILD file covered by a backup.
+type backupFile struct {
+	// Path is a slash-separated path relative to the repository root.
+	Path string `json:"path"`
+	// Existed is false if gazelle created the file. Such files are removed
+	// on restore.
+	Existed bool `json:"existed"`
+}
+
+// writeTemp writes "content" to a new temporary file in the directory of
+// "path" and returns the name of the temporary file. Since it is in the same
+// directory, the temporary file can be renamed to "path" atomically. The
+// directory is created if it does not exist, as in -out_dir.
+func writeTemp(path string, content []byte) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	return writeTempIn(filepath.Dir(path), filepath.Base(path)+".gazelle", filePerm(path), content)
+}
+
+// filePerm returns the permissions of the file at "path", or 0644 if there
+// is no such file. Files which replace "path" get these permissions.
+func filePerm(path string) os.FileMode {
+	if fi, err := os.Stat(path); err == nil {
+		return fi.Mode().Perm()
+	}
+	return 0644
+}
+
+// writeTempIn writes "content" to a new temporary file in the directory
+// "dir", whose name starts with "prefix", and returns the name of the file.
+// The file gets the permissions "perm".
+func writeTempIn(dir, prefix string, perm os.FileMode, content []byte) (string, error) {
+	f, err := ioutil.TempFile(dir, prefix)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(content)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), perm)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// A fixer implements fix mode. It stages every updated BUILD file in a
+// temporary file first and only moves them in place on commit, so that a
+// failure in the middle of a run does not leave the tree half-updated. The
+// staged files are kept in a single directory under repoRoot, which is
+// removed by commit and abort.
+type fixer struct {
+	repoRoot, backupDir string
+	// stageDir is the directory of the staged files. It is created by the
+	// first call of stage.
+	stageDir string
+	staged   []stagedFile
+}
+
+type stagedFile struct {
+	path, tmp string
+}
+
+// stagePrefix is the prefix of the name of the staging directory. It starts
+// with a dot so that gazelle does not walk into the directory.
+const stagePrefix = ".gazelle-staging"
+
+// stage writes "file" into a temporary file in the staging directory.
+func (f *fixer) stage(file *bzl.File) error {
+	if f.stageDir == "" {
+		if err := os.MkdirAll(f.repoRoot, 0755); err != nil {
+			return err
+		}
+		dir, err := ioutil.TempDir(f.repoRoot, stagePrefix)
+		if err != nil {
+			return err
+		}
+		f.stageDir = dir
+	}
+	tmp, err := writeTempIn(f.stageDir, filepath.Base(file.Path), filePerm(file.Path), bzl.Format(file))
+	if err != nil {
+		return err
+	}
+	f.staged = append(f.staged, stagedFile{path: file.Path, tmp: tmp})
+	return nil
+}
+
+// abort discards the staged files.
+func (f *fixer) abort() {
+	if f.stageDir != "" {
+		os.RemoveAll(f.stageDir)
+	}
+	f.stageDir, f.staged = "", nil
+}
+
+// A previousFile is the content a BUILD file had before commit replaced it,
+// or nil if the file did not exist.
+type previousFile struct {
+	path    string
+	content []byte
+}
+
+// commit backs up the current BUILD files if f.backupDir is set, and then
+// renames the staged files over them. The current contents are also kept in
+// memory: if a rename fails, the files renamed so far are rolled back, so
+// the tree is left as it was.
+func (f *fixer) commit() error {
+	defer f.abort()
+	if f.backupDir != "" {
+		if err := f.backup(); err != nil {
+			return fmt.Errorf("failed to back up BUILD files into %s: %v", f.backupDir, err)
+		}
+	}
+	var previous []previousFile
+	for _, s := range f.staged {
+		b, err := ioutil.ReadFile(s.path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		previous = append(previous, previousFile{path: s.path, content: b})
+	}
+	for i, s := range f.staged {
+		err := os.MkdirAll(filepath.Dir(s.path), 0755)
+		if err == nil {
+			err = os.Rename(s.tmp, s.path)
+		}
+		if err != nil {
+			if rerr := f.rollback(previous[:i]); rerr != nil {
+				if f.backupDir != "" {
+					return fmt.Errorf("%v; rolling back failed with %v; run \"gazelle restore -backup_dir=%s\" to roll back", err, rerr, f.backupDir)
+				}
+				return fmt.Errorf("%v; rolling back failed with %v", err, rerr)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// rollback puts the previous contents of "files" back, or removes the files
+// which did not exist. It goes on after an error and returns the first one.
+func (f *fixer) rollback(files []previousFile) error {
+	var first error
+	for _, p := range files {
+		var err error
+		if p.content == nil {
+			err = os.Remove(p.path)
+		} else {
+			var tmp string
+			if tmp, err = writeTempIn(f.stageDir, filepath.Base(p.path), filePerm(p.path), p.content); err == nil {
+				if err = os.Rename(tmp, p.path); err != nil {
+					os.Remove(tmp)
+				}
+			}
+		}
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (f *fixer) backup() error {
+	root, err := filepath.Abs(f.repoRoot)
+	if err != nil {
+		return err
+	}
+	m := manifest{RepoRoot: root}
+	for _, s := range f.staged {
+		path, err := filepath.Abs(s.path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		b, err := ioutil.ReadFile(s.path)
+		if os.IsNotExist(err) {
+			m.Files = append(m.Files, backupFile{Path: filepath.ToSlash(rel)})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(f.backupDir, rel)
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(dest, b, 0644); err != nil {
+			return err
+		}
+		m.Files = append(m.Files, backupFile{Path: filepath.ToSlash(rel), Existed: true})
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(f.backupDir, backupManifest), b, 0644)
+}
+
+// restore rolls back the BUILD files covered by the backup in "backupDir".
+func restore(backupDir string) error {
+	b, err := ioutil.ReadFile(filepath.Join(backupDir, backupManifest))
+	if err != nil {
+		return err
+	}
+	var m manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return fmt.Errorf("%s: %v", filepath.Join(backupDir, backupManifest), err)
+	}
+	for _, file := range m.Files {
+		rel := filepath.FromSlash(file.Path)
+		path := filepath.Join(m.RepoRoot, rel)
+		if !file.Existed {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(backupDir, rel))
+		if err != nil {
+			return err
+		}
+		tmp, err := writeTemp(path, content)
+		if err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	return nil
 }
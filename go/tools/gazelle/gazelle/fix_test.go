@@ -24,7 +24,7 @@ import (
 	bzl "github.com/bazelbuild/buildifier/core"
 )
 
-func TestFixFile(t *testing.T) {
+func TestFixerKeepsPermissions(t *testing.T) {
 	tmpdir := os.Getenv("TEST_TMPDIR")
 	dir, err := ioutil.TempDir(tmpdir, "")
 	if err != nil {
@@ -48,11 +48,28 @@ func TestFixFile(t *testing.T) {
 		},
 	}
 
-	if err := fixFile(stubFile); err != nil {
-		t.Errorf("fixFile(%#v) failed with %v; want success", stubFile, err)
-		return
+	if err := ioutil.WriteFile(stubFile.Path, []byte("# old\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(stubFile.Path, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	fx := &fixer{repoRoot: dir}
+	if err := fx.stage(stubFile); err != nil {
+		t.Fatalf("fx.stage(%#v) failed with %v; want success", stubFile, err)
+	}
+	if err := fx.commit(); err != nil {
+		t.Fatalf("fx.commit() failed with %v; want success", err)
 	}
 
+	fi, err := os.Stat(stubFile.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fi.Mode().Perm(), os.FileMode(0755); got != want {
+		t.Errorf("mode = %v; want %v", got, want)
+	}
 	buf, err := ioutil.ReadFile(stubFile.Path)
 	if err != nil {
 		t.Errorf("ioutil.ReadFile(%q) failed with %v; want success", stubFile.Path, err)
@@ -62,3 +79,99 @@ func TestFixFile(t *testing.T) {
 		t.Errorf("buf = %q; want %q", got, want)
 	}
 }
+
+func TestFixerBackupAndRestore(t *testing.T) {
+	tmpdir := os.Getenv("TEST_TMPDIR")
+	dir, err := ioutil.TempDir(tmpdir, "")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(%q, %q) failed with %v; want success", tmpdir, "", err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo, backupDir := filepath.Join(dir, "repo"), filepath.Join(dir, "backup")
+	if err := os.MkdirAll(filepath.Join(repo, "lib"), 0755); err != nil {
+		t.Fatalf("os.MkdirAll(%q, 0755) failed with %v; want success", filepath.Join(repo, "lib"), err)
+	}
+	oldPath, newPath := filepath.Join(repo, "BUILD"), filepath.Join(repo, "lib", "BUILD")
+	const oldContent = "# old\n"
+	if err := ioutil.WriteFile(oldPath, []byte(oldContent), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile(%q, %q, 0644) failed with %v; want success", oldPath, oldContent, err)
+	}
+
+	fx := &fixer{repoRoot: repo, backupDir: backupDir}
+	for _, p := range []string{oldPath, newPath} {
+		f := &bzl.File{Path: p}
+		if err := fx.stage(f); err != nil {
+			t.Fatalf("fx.stage(%#v) failed with %v; want success", f, err)
+		}
+	}
+	if b, err := ioutil.ReadFile(oldPath); err != nil || string(b) != oldContent {
+		t.Errorf("ioutil.ReadFile(%q) = %q, %v before commit; want %q, nil", oldPath, b, err, oldContent)
+	}
+	if _, err := os.Stat(newPath); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(%q) = %v before commit; want not exist", newPath, err)
+	}
+
+	if err := fx.commit(); err != nil {
+		t.Fatalf("fx.commit() failed with %v; want success", err)
+	}
+	if b, err := ioutil.ReadFile(oldPath); err != nil || string(b) == oldContent {
+		t.Errorf("ioutil.ReadFile(%q) = %q, %v after commit; want new content", oldPath, b, err)
+	}
+	if _, err := os.Stat(newPath); err != nil {
+		t.Errorf("os.Stat(%q) failed with %v after commit; want success", newPath, err)
+	}
+	if staged, _ := filepath.Glob(filepath.Join(repo, stagePrefix+"*")); len(staged) > 0 {
+		t.Errorf("staging directories %q left after commit; want none", staged)
+	}
+
+	if err := restore(backupDir); err != nil {
+		t.Fatalf("restore(%q) failed with %v; want success", backupDir, err)
+	}
+	if b, err := ioutil.ReadFile(oldPath); err != nil || string(b) != oldContent {
+		t.Errorf("ioutil.ReadFile(%q) = %q, %v after restore; want %q, nil", oldPath, b, err, oldContent)
+	}
+	if _, err := os.Stat(newPath); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(%q) = %v after restore; want not exist", newPath, err)
+	}
+}
+
+func TestFixerRollsBackFailedCommit(t *testing.T) {
+	tmpdir := os.Getenv("TEST_TMPDIR")
+	repo, err := ioutil.TempDir(tmpdir, "")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(%q, %q) failed with %v; want success", tmpdir, "", err)
+	}
+	defer os.RemoveAll(repo)
+
+	oldPath, newPath := filepath.Join(repo, "BUILD"), filepath.Join(repo, "lib", "BUILD")
+	const oldContent = "# old\n"
+	if err := ioutil.WriteFile(oldPath, []byte(oldContent), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile(%q, %q, 0644) failed with %v; want success", oldPath, oldContent, err)
+	}
+	// A BUILD file cannot be renamed over a non-empty directory.
+	badPath := filepath.Join(repo, "bad", "BUILD")
+	if err := os.MkdirAll(filepath.Join(badPath, "sub"), 0755); err != nil {
+		t.Fatalf("os.MkdirAll(%q, 0755) failed with %v; want success", filepath.Join(badPath, "sub"), err)
+	}
+
+	fx := &fixer{repoRoot: repo}
+	for _, p := range []string{oldPath, newPath, badPath} {
+		f := &bzl.File{Path: p, Stmt: []bzl.Expr{&bzl.CallExpr{X: &bzl.LiteralExpr{Token: "go_prefix"}}}}
+		if err := fx.stage(f); err != nil {
+			t.Fatalf("fx.stage(%#v) failed with %v; want success", f, err)
+		}
+	}
+	if err := fx.commit(); err == nil {
+		t.Fatalf("fx.commit() succeeded; want failure")
+	}
+	if b, err := ioutil.ReadFile(oldPath); err != nil || string(b) != oldContent {
+		t.Errorf("ioutil.ReadFile(%q) = %q, %v after failed commit; want %q, nil", oldPath, b, err, oldContent)
+	}
+	if _, err := os.Stat(newPath); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(%q) = %v after failed commit; want not exist", newPath, err)
+	}
+	if staged, _ := filepath.Glob(filepath.Join(repo, stagePrefix+"*")); len(staged) > 0 {
+		t.Errorf("staging directories %q left after failed commit; want none", staged)
+	}
+}
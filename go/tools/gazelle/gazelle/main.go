@@ -18,65 +18,126 @@ limitations under the License.
 package main
 
 import (
-	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
 	bzl "github.com/bazelbuild/buildifier/core"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/driver"
 	"github.com/bazelbuild/rules_go/go/tools/gazelle/generator"
-	"github.com/bazelbuild/rules_go/go/tools/gazelle/merger"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/migrate"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/rules"
 	"github.com/bazelbuild/rules_go/go/tools/gazelle/wspace"
 )
 
 var (
-	goPrefix = flag.String("go_prefix", "", "go_prefix of the target workspace")
-	repoRoot = flag.String("repo_root", "", "path to a directory which corresponds to go_prefix, otherwise gazelle searches for it.")
-	mode     = flag.String("mode", "fix", "print: prints all of the updated BUILD files\n\tfix: rewrites all of the BUILD files in place\n\tdiff: computes the rewrite but then just does a diff")
+	goPrefix          = flag.String("go_prefix", "", "go_prefix of the target workspace. If not set, gazelle reads it from the root BUILD file, or else infers it")
+	repoRoot          = flag.String("repo_root", "", "path to a directory which corresponds to go_prefix, otherwise gazelle searches for it.")
+	mode              = flag.String("mode", "fix", "print: prints all of the updated BUILD files\n\tfix: rewrites all of the BUILD files in place\n\tdiff: computes the rewrite but then just does a diff")
+	keepGoing         = flag.Bool("keep_going", false, "if true, gazelle skips packages it fails to process, continues with the others and reports all errors at the end")
+	useImportComments = flag.Bool("use_import_comments", false, "if true, imports which match the import comment of a package in the repository resolve into the package even if it is not where go_prefix implies")
+	goVersion         = flag.String("go_version", "", "Go release, e.g. go1.8, whose standard library gazelle recognizes. Defaults to the packages in the Go installation gazelle was built with, or else its release")
+	goroot            = flag.String("goroot", "", "if set, gazelle recognizes the standard library of the Go installation in this directory instead of -go_version")
+	naming            = flag.String("naming", "default", "default: names libraries go_default_library\n\tdir: names libraries and tests after their directories, e.g. //foo/bar:bar and //foo/bar:bar_test. Such libraries set dir_importpath, so that they keep the import paths of their directories")
+	goGenerate        = flag.Bool("go_generate", false, "if true, gazelle translates //go:generate directives which run stringer, mockgen, go-bindata or protoc into genrules, uses them in srcs in place of the generated files, and lists the directives it cannot translate")
+	unresolved        = flag.String("unresolved", "error", "what to do with imports which cannot be resolved into labels: error skips the BUILD files of the importing packages and fails; warn leaves the imports out of deps; placeholder puts labels guessed from the import paths in deps. All of them are listed at the end")
+	checkRepos        = flag.Bool("check_repos", false, "if true, imports resolve into the repositories declared in WORKSPACE with matching importpath attributes, and imports which would resolve into undeclared repositories are unresolved")
+	repoName          = flag.String("repo_name", "", "name of the repository, used to qualify the labels of its targets; implies -qualify_labels")
+	qualifyLabels     = flag.Bool("qualify_labels", false, "if true, gazelle qualifies the labels of targets in other packages of the repository with the repository name, e.g. @repo_name//pkg:name. The name is -repo_name, or else the one declared with workspace(name = ...) in WORKSPACE")
+	outDir            = flag.String("out_dir", "", "directory to write BUILD files into instead of the source tree, mirroring its layout. Generated files are merged into the existing BUILD files in this directory, or else into the ones in the source tree")
+	followSymlinks    = flag.Bool("follow_symlinks", false, "if true, gazelle descends into symbolic links to directories, generates one BUILD file per real directory in the repository and resolves imports through links into the labels of the real directories")
+	changedFiles      = flag.String("changed_files", "", "path of a file which lists changed files, one per line, relative to the repository root, e.g. the output of \"git diff --name-only\". \"-\" reads the list from stdin. If set, gazelle regenerates only the packages which contain the files, and the packages whose BUILD files reference deleted packages, instead of the directories in the arguments")
+	inferTestOnly     = flag.Bool("infer_testonly", false, "if true, gazelle reads the imports of the whole repository and marks libraries imported only by the tests of other packages testonly. Otherwise libraries keep the testonly attribute of their existing BUILD files")
+	backupDir         = flag.String("backup_dir", "", "in fix mode, a directory to save the previous contents of the updated BUILD files into. \"gazelle restore -backup_dir=DIR\" rolls them back")
+	macros            = make(macroFlag)
 )
 
 func init() {
+	flag.Var(macros, "macro", "macro=kind: tells gazelle that calls of the macro in existing BUILD files wrap the Go rule kind. May be repeated.")
 	// See also #135.
 	// TODO(yugui): Remove this flag when we drop support of Bazel 0.3.2
 	flag.StringVar(&generator.GoRulesBzl, "go_rules_bzl_only_for_internal_use", "@io_bazel_rules_go//go:def.bzl", "hacky flag to build rules_go repository itself")
 }
 
+// modeFromName maps the modes which only emit BUILD files to their emit
+// functions. Fix mode stages the files in a fixer instead.
 var modeFromName = map[string]func(*bzl.File) error{
 	"print": printFile,
-	"fix":   fixFile,
 	"diff":  diffFile,
 }
 
-func run(dirs []string, emit func(*bzl.File) error) error {
-	g, err := generator.New(*repoRoot, *goPrefix)
+// run runs gazelle on the packages under dirs and emits the merged BUILD
+// files. Existing BUILD files which gazelle cannot merge into are skipped.
+// With -keep_going, packages which fail for other reasons are skipped too.
+// The problems are returned together as a *report after every other file
+// has been emitted, along with the problems found in the sources which did
+// not keep gazelle from emitting files.
+func run(c driver.Config, emit func(*bzl.File) error) error {
+	res, err := driver.Run(c)
 	if err != nil {
 		return err
 	}
+	if res.GoPrefixSource != "" {
+		log.Printf("-go_prefix not set; using %q inferred from %s", res.GoPrefix, res.GoPrefixSource)
+	}
+	if len(res.Unresolved) > 0 {
+		log.Print(unresolvedSummary(res.Unresolved))
+	}
 
-	for _, d := range dirs {
-		files, err := g.Generate(d)
-		if err != nil {
-			return err
+	rep := newReport(res.RepoRoot)
+	if len(res.PackageErrors) > 0 {
+		rep.addError(res.RepoRoot, res.PackageErrors)
+	}
+	for _, d := range res.Diagnostics {
+		rep.addError(res.RepoRoot, d)
+	}
+	for _, f := range res.Files {
+		dir := filepath.Dir(f.Path)
+		if f.Status == driver.Skipped {
+			rep.addError(dir, f.Err)
+			continue
 		}
-		for _, f := range files {
-			f.Path = filepath.Join(*repoRoot, f.Path)
-			if f, err = merger.MergeWithExisting(f); err != nil {
-				return err
-			}
-			bzl.Rewrite(f, nil) // have buildifier 'format' our rules.
-			if err := emit(f); err != nil {
+		if err := emit(f.Merged); err != nil {
+			if !c.KeepGoing {
 				return err
 			}
+			rep.addError(dir, err)
 		}
 	}
+	if !rep.empty() {
+		return rep
+	}
+	return nil
+}
+
+// macroFlag is a flag.Value which collects "macro=kind" pairs.
+type macroFlag map[string]string
+
+func (f macroFlag) String() string {
+	var pairs []string
+	for m, k := range f {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", m, k))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (f macroFlag) Set(value string) error {
+	i := strings.Index(value, "=")
+	if i <= 0 || i == len(value)-1 {
+		return fmt.Errorf("invalid macro %q; want macro=kind", value)
+	}
+	f[value[:i]] = value[i+1:]
 	return nil
 }
 
 func usage() {
 	fmt.Fprintln(os.Stderr, `usage: gazelle [flags...] [package-dirs...]
+       gazelle restore -backup_dir=DIR
+       gazelle migrate_naming [-aliases] [flags...]
 
 Gazel is a BUILD file generator for Go projects.
 
@@ -90,17 +151,110 @@ It recursively traverses its subpackages.
 All the directories must be under the directory specified in -repo_root.
 [if -repo_root is not given, gazelle searches $pwd and up for the WORKSPACE file]
 
+Subdirectories which hold Go projects with their own import path prefix can
+declare it with a "# gazelle:prefix <importpath>" comment in their BUILD file.
+
+A "# gazelle:map_kind <kind> <mapped_kind> <bzl_file>" comment in the root
+BUILD file makes gazelle emit rules of mapped_kind, loaded from bzl_file, in
+place of the rules of kind it generates, e.g. to use wrapper macros.
+
+A "# gazelle:visibility <pattern> <label>..." comment in the root BUILD file
+sets the visibility of the rules generated in the directories matching
+pattern, e.g. "api/..." for api and its subdirectories, or "..." for all. The
+last matching comment applies, and replaces the visibility of existing
+rules. Otherwise rules are public, except that packages under internal or
+vendor directories are visible only to the tree of their parent, and the
+visibility of existing rules is kept. Rules are not given a visibility equal
+to the default_visibility of their package.
+
+With -go_generate, "//go:generate" directives which run stringer, mockgen (in
+source mode), go-bindata or protoc become genrules, and the files they
+generate are replaced with the genrules in srcs. Checked-in copies of the
+generated files must be removed. Other directives are listed as warnings.
+
+Imports which cannot be resolved, because the repository of the import path
+cannot be found or, with -check_repos, because it is not declared in
+WORKSPACE, are handled as -unresolved tells and listed together at the end.
+Imports without a dot in their first element which are not in the standard
+library, e.g. "mycorp/lib", are left out of deps and reported as problems
+whatever -unresolved is, since their repositories are not known.
+
+With -qualify_labels, labels of targets in other packages of the repository
+are written as "@repo_name//pkg:name", so that they still refer to the
+repository when another workspace uses it as an external repository.
+Labels of the same package, like ":go_default_library", and visibility are
+left as they are, since they always refer to the repository of the BUILD file.
+
+With -out_dir, BUILD files are written into a separate tree which mirrors the
+repository, e.g. for read-only checkouts or vendored trees, and the source
+tree is not modified. Directives like "# gazelle:prefix" are still read from
+the BUILD files in the source tree.
+
+With -changed_files, gazelle regenerates only the BUILD files of the packages
+which contain the listed files, e.g. "git diff --name-only | gazelle
+-changed_files=- -mode fix". If a file is in a deleted package, the BUILD
+files which refer to that package are regenerated instead.
+
 There are several modes of gazelle.
 In print mode, gazelle prints reconciled BUILD files to stdout.
 In fix mode, gazelle creates BUILD files or updates existing ones.
+It writes them only after every package has been processed, replacing each
+file atomically; if replacing a file fails, the files replaced so far are put
+back as they were. If -backup_dir is given, the previous contents are saved
+there first, and "gazelle restore" puts them back.
 In diff mode, gazelle shows diff.
 
+"gazelle migrate_naming" renames the libraries and tests in the existing BUILD
+files from the default names to the ones of -naming=dir, and rewrites the
+labels in the repository which refer to them. With -aliases, it leaves an
+alias named go_default_library next to each renamed library.
+
+Existing BUILD files which gazelle cannot parse are left untouched. Gazelle
+still processes every other package, then reports all such problems and exits
+with a non-zero status. With -keep_going, gazelle does the same for packages
+it fails to import or to generate rules for. Problems which do not keep gazelle
+from generating a BUILD file, such as an import comment which does not match
+go_prefix, are reported the same way, after the files have been emitted.
+
 FLAGS:
 `)
 	flag.PrintDefaults()
 }
 
+// readChangedFiles returns the paths listed one per line in the file at
+// "path", or in stdin if "path" is "-".
+func readChangedFiles(path string) ([]string, error) {
+	var b []byte
+	var err error
+	if path == "-" {
+		b, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		b, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, line := range strings.Split(string(b), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			files = append(files, line)
+		}
+	}
+	return files, nil
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "restore":
+			restoreMain(os.Args[2:])
+			return
+		case "migrate_naming":
+			migrateNamingMain(os.Args[2:])
+			return
+		}
+	}
+
 	flag.Usage = usage
 	flag.Parse()
 
@@ -110,63 +264,156 @@ func main() {
 			log.Fatal(err)
 		}
 	}
-	if *goPrefix == "" {
-		var err error
-		if *goPrefix, err = loadGoPrefix(*repoRoot); err != nil {
-			if !os.IsNotExist(err) {
-				log.Fatal(err)
-			}
-			log.Fatalf("-go_prefix not set and no root BUILD file found")
-		}
+
+	args := flag.Args()
+	if len(args) == 0 {
+		args = append(args, ".")
 	}
 
 	emit := modeFromName[*mode]
-	if emit == nil {
+	var fx *fixer
+	switch {
+	case *mode == "fix":
+		root := *repoRoot
+		if *outDir != "" {
+			root = *outDir
+		}
+		fx = &fixer{repoRoot: root, backupDir: *backupDir}
+		emit = fx.stage
+	case emit == nil:
 		log.Fatalf("unrecognized mode %s", *mode)
 	}
 
-	args := flag.Args()
-	if len(args) == 0 {
-		args = append(args, ".")
+	c := driver.Config{
+		RepoRoot:          *repoRoot,
+		GoPrefix:          *goPrefix,
+		Dirs:              args,
+		KeepGoing:         *keepGoing,
+		UseImportComments: *useImportComments,
+		Macros:            macros,
+		GoGenerate:        *goGenerate,
+		FollowSymlinks:    *followSymlinks,
+		CheckRepositories: *checkRepos,
+		QualifyLabels:     *qualifyLabels || *repoName != "",
+		RepoName:          *repoName,
+		OutDir:            *outDir,
+		RepairGoPrefix:    *mode == "fix",
+		InferTestOnly:     *inferTestOnly,
+	}
+	var err error
+	if c.Naming, err = rules.ParseNaming(*naming); err != nil {
+		log.Fatal(err)
+	}
+	if c.Unresolved, err = rules.ParseUnresolvedPolicy(*unresolved); err != nil {
+		log.Fatal(err)
+	}
+	if *changedFiles != "" {
+		if len(flag.Args()) > 0 {
+			log.Fatal("-changed_files cannot be used together with directory arguments")
+		}
+		if c.ChangedFiles, err = readChangedFiles(*changedFiles); err != nil {
+			log.Fatal(err)
+		}
+		if len(c.ChangedFiles) == 0 {
+			return
+		}
+	}
+	switch {
+	case *goroot != "":
+		c.StdPackages, err = rules.LoadStdPackages(*goroot)
+	case *goVersion != "":
+		c.StdPackages, err = rules.StdPackages(*goVersion)
+	}
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	if err := run(args, emit); err != nil {
+	err = run(c, emit)
+	if fx != nil {
+		// Problems in a report are about packages gazelle skipped on purpose,
+		// so the files of the other packages are still written.
+		if _, ok := err.(*report); err == nil || ok {
+			if err := fx.commit(); err != nil {
+				log.Fatal(err)
+			}
+		} else {
+			fx.abort()
+		}
+	}
+	if err != nil {
+		if r, ok := err.(*report); ok {
+			fmt.Fprintln(os.Stderr, r)
+			os.Exit(1)
+		}
 		log.Fatal(err)
 	}
 }
 
-func loadGoPrefix(repo string) (string, error) {
-	p := filepath.Join(repo, "BUILD")
-	b, err := ioutil.ReadFile(p)
-	if err != nil {
-		return "", err
+func restoreMain(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	dir := fs.String("backup_dir", "", "directory which a previous run of gazelle in fix mode saved BUILD files into")
+	fs.Parse(args)
+	if *dir == "" || fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: gazelle restore -backup_dir=DIR")
+		os.Exit(2)
 	}
-	f, err := bzl.Parse(p, b)
-	if err != nil {
-		return "", err
+	if err := restore(*dir); err != nil {
+		log.Fatal(err)
 	}
-	for _, s := range f.Stmt {
-		c, ok := s.(*bzl.CallExpr)
-		if !ok {
-			continue
-		}
-		l, ok := c.X.(*bzl.LiteralExpr)
-		if !ok {
-			continue
+}
+
+func migrateNamingMain(args []string) {
+	fs := flag.NewFlagSet("migrate_naming", flag.ExitOnError)
+	root := fs.String("repo_root", "", "path to the repository root. If not set, gazelle searches for it")
+	prefix := fs.String("go_prefix", "", "go_prefix of the repository. If not set, gazelle reads it from the root BUILD file, or else infers it")
+	aliases := fs.Bool("aliases", false, "if true, leaves an alias named go_default_library next to each renamed library")
+	mode := fs.String("mode", "fix", "print, fix or diff, like in the main command")
+	backup := fs.String("backup_dir", "", "in fix mode, a directory to save the previous contents of the updated BUILD files into")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: gazelle migrate_naming [-aliases] [flags...]")
+		os.Exit(2)
+	}
+
+	if *root == "" {
+		var err error
+		if *root, err = repo(nil); err != nil {
+			log.Fatal(err)
 		}
-		if l.Token != "go_prefix" {
-			continue
+	}
+	if *prefix == "" {
+		var err error
+		if *prefix, _, err = driver.GoPrefix(*root); err != nil {
+			log.Fatal(err)
 		}
-		if len(c.List) != 1 {
-			return "", fmt.Errorf("found go_prefix(%v) with too many args", c.List)
+	}
+	emit := modeFromName[*mode]
+	var fx *fixer
+	switch {
+	case *mode == "fix":
+		fx = &fixer{repoRoot: *root, backupDir: *backup}
+		emit = fx.stage
+	case emit == nil:
+		log.Fatalf("unrecognized mode %s", *mode)
+	}
+
+	files, err := migrate.Naming(*root, migrate.NamingOptions{GoPrefix: *prefix, Aliases: *aliases})
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, f := range files {
+		if err := emit(f); err != nil {
+			if fx != nil {
+				fx.abort()
+			}
+			log.Fatal(err)
 		}
-		v, ok := c.List[0].(*bzl.StringExpr)
-		if !ok {
-			return "", fmt.Errorf("found go_prefix(%v) which is not a string", c.List)
+	}
+	if fx != nil {
+		if err := fx.commit(); err != nil {
+			log.Fatal(err)
 		}
-		return v.Value, nil
 	}
-	return "", errors.New("-go_prefix not set, and no go_prefix in root BUILD file")
 }
 
 func repo(args []string) (string, error) {
@@ -0,0 +1,112 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/diag"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/packages"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/rules"
+)
+
+// A report collects the problems found during a run of gazelle, grouped by
+// the package directory they belong to.
+type report struct {
+	root string
+	errs map[string][]error
+}
+
+func newReport(root string) *report {
+	return &report{root: root, errs: make(map[string][]error)}
+}
+
+// add records "err" as a problem in the package directory "dir".
+func (r *report) add(dir string, err error) {
+	if rel, relErr := filepath.Rel(r.root, dir); relErr == nil {
+		dir = filepath.ToSlash(rel)
+	}
+	r.errs[dir] = append(r.errs[dir], err)
+}
+
+// addError records "err", finding out the package directory it belongs to
+// from its type.
+func (r *report) addError(dir string, err error) {
+	switch err := err.(type) {
+	case packages.ErrorList:
+		for _, e := range err {
+			r.add(e.Dir, e.Err)
+		}
+	case *diag.Error:
+		r.add(filepath.Dir(err.Path), err)
+	default:
+		r.add(dir, err)
+	}
+}
+
+func (r *report) empty() bool {
+	return len(r.errs) == 0
+}
+
+func (r *report) Error() string {
+	var dirs []string
+	for d := range r.errs {
+		dirs = append(dirs, d)
+	}
+	sort.Strings(dirs)
+
+	msgs := []string{fmt.Sprintf("gazelle: found problems in %d package(s):", len(dirs))}
+	for _, d := range dirs {
+		msgs = append(msgs, d+":")
+		for _, err := range r.errs[d] {
+			msgs = append(msgs, "\t"+strings.Replace(err.Error(), "\n", "\n\t", -1))
+		}
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// unresolvedSummary lists the imports in "us" by import path, each with the
+// packages which import it.
+func unresolvedSummary(us []rules.UnresolvedImport) string {
+	byPath := make(map[string][]rules.UnresolvedImport)
+	for _, u := range us {
+		byPath[u.ImportPath] = append(byPath[u.ImportPath], u)
+	}
+	var paths []string
+	for p := range byPath {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	msgs := []string{fmt.Sprintf("gazelle: %d import path(s) could not be resolved:", len(paths))}
+	for _, p := range paths {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", p, byPath[p][0].Err))
+		var dirs []string
+		for _, u := range byPath[p] {
+			dir := "\t//" + u.Dir
+			if u.Label != "" {
+				dir += " (using " + u.Label + ")"
+			}
+			dirs = append(dirs, dir)
+		}
+		sort.Strings(dirs)
+		msgs = append(msgs, dirs...)
+	}
+	return strings.Join(msgs, "\n")
+}
@@ -0,0 +1,70 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/diag"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/packages"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/rules"
+)
+
+func TestReport(t *testing.T) {
+	root := filepath.FromSlash("/repo")
+	r := newReport(root)
+	if !r.empty() {
+		t.Errorf("newReport(%q).empty() = false; want true", root)
+	}
+
+	r.addError(root, packages.ErrorList{
+		{Dir: filepath.Join(root, "b"), Err: errors.New("import failed")},
+	})
+	r.addError(filepath.Join(root, "b"), &diag.Error{
+		Path: filepath.Join(root, "a", "BUILD"),
+		Line: 1, Column: 2,
+		Msg: "syntax error",
+	})
+
+	want := `gazelle: found problems in 2 package(s):
+a:
+	` + filepath.Join(root, "a", "BUILD") + `:1:2: syntax error
+b:
+	import failed`
+	if got := r.Error(); got != want {
+		t.Errorf("r.Error() = %q; want %q", got, want)
+	}
+}
+
+func TestUnresolvedSummary(t *testing.T) {
+	notFound := errors.New("repository @com_example_x is not declared in WORKSPACE")
+	got := unresolvedSummary([]rules.UnresolvedImport{
+		{ImportPath: "example.com/x", Dir: "b", Label: "@com_example_x//:go_default_library", Err: notFound},
+		{ImportPath: "bad.example/y", Dir: "a", Err: errors.New("unrecognized import path")},
+		{ImportPath: "example.com/x", Dir: "a", Label: "@com_example_x//:go_default_library", Err: notFound},
+	})
+	want := `gazelle: 2 import path(s) could not be resolved:
+bad.example/y: unrecognized import path
+	//a
+example.com/x: repository @com_example_x is not declared in WORKSPACE
+	//a (using @com_example_x//:go_default_library)
+	//b (using @com_example_x//:go_default_library)`
+	if got != want {
+		t.Errorf("unresolvedSummary(...) = %q; want %q", got, want)
+	}
+}
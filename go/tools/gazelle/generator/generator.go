@@ -20,10 +20,13 @@ package generator
 import (
 	"fmt"
 	"go/build"
+	"log"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	bzl "github.com/bazelbuild/buildifier/core"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/diag"
 	"github.com/bazelbuild/rules_go/go/tools/gazelle/packages"
 	"github.com/bazelbuild/rules_go/go/tools/gazelle/rules"
 )
@@ -39,10 +42,92 @@ var (
 
 // Generator generates BUILD files for a Go repository.
 type Generator struct {
+	// KeepGoing makes Generate continue with the other packages when it fails
+	// to generate a BUILD file for a package.
+	KeepGoing bool
+	// UseImportComments makes Generate resolve an import path which matches
+	// the import comment of a package in the repository into the package, even
+	// if it does not match the import path implied by go_prefix. Such a
+	// package gets a go_prefix rule of its own, so that it is compiled with
+	// the import path in its comment.
+	UseImportComments bool
+	// StdPackages is the set of import paths in the Go standard library.
+	// If nil, rules.DefaultStdPackages is used.
+	StdPackages map[string]bool
+	// Plugins generate rules of other kinds than the Go rules, and load
+	// statements for them.
+	Plugins []rules.Plugin
+	// MappedKinds are the kinds emitted in place of the ones gazelle
+	// generates. New reads them from the root BUILD file of the repository.
+	MappedKinds []MappedKind
+	// Naming is the convention for naming libraries and tests.
+	Naming rules.Naming
+	// Visibility sets the visibility of generated rules by directory. New
+	// reads it from the root BUILD file of the repository. See also
+	// OwnsVisibility.
+	Visibility []rules.VisibilityRule
+	// FollowSymlinks makes Generate descend into symbolic links to
+	// directories in the repository. Each real directory gets one BUILD
+	// file, and imports through links resolve into the labels of the real
+	// directories. See also packages.Options and rules.Config.Symlink.
+	FollowSymlinks bool
+	// GoGenerate makes Generate translate //go:generate directives into
+	// genrules. See also rules.Config.GoGenerate.
+	GoGenerate bool
+	// CheckRepositories makes Generate resolve imports into the repositories
+	// declared in the WORKSPACE file of the repository, and treat imports
+	// which resolve into other external repositories as unresolved.
+	CheckRepositories bool
+	// Unresolved tells what to do with imports which cannot be resolved.
+	// With rules.UnresolvedError, a package with such an import fails,
+	// unless the imports are collected with CollectUnresolved.
+	Unresolved rules.UnresolvedPolicy
+	// CollectUnresolved makes Generate collect the imports it cannot resolve
+	// instead of logging them or failing. They are returned by
+	// UnresolvedImports.
+	CollectUnresolved bool
+	// CollectDiagnostics makes Generate collect the problems it finds in the
+	// sources and existing BUILD files of the repository, which do not keep
+	// it from generating BUILD files, instead of logging them. They are
+	// returned by Diagnostics.
+	CollectDiagnostics bool
+	// RepoName qualifies the labels of targets in the repository. See also
+	// rules.Config.RepoName.
+	RepoName string
+	// Incremental makes Generate read only the Go files of the packages it
+	// generates BUILD files for, instead of those of the whole repository.
+	// The go_prefix rules of subdirectories stand in for the import comments
+	// of other packages, and InferTestOnly has no effect.
+	Incremental bool
+	// InferTestOnly makes Generate walk the whole repository to find the
+	// libraries which are imported only by the tests of other packages, and
+	// mark them testonly. Otherwise a library is testonly if its existing
+	// BUILD file says so, and imports of testonly libraries are checked only
+	// for the generated packages.
+	InferTestOnly bool
+
 	repoRoot string
 	goPrefix string
-	bctx     build.Context
-	g        rules.Generator
+	// roots are the import roots of nested workspaces and prefix subtrees,
+	// and prefixRules those of the go_prefix rules in subdirectories. They
+	// are found on the first call of Generate.
+	roots       []rules.ImportRoot
+	prefixRules []rules.ImportRoot
+	// commentRoots are the import roots of the packages whose import
+	// comments are used with UseImportComments.
+	commentRoots []rules.ImportRoot
+	bctx         build.Context
+	// g is created on the first call of Generate, after the exported
+	// fields have been set.
+	g rules.Generator
+	// testOnly is the set of the directories of test-only libraries. With
+	// InferTestOnly, it is computed on the first call of Generate.
+	testOnly map[string]bool
+	// unresolved are the imports collected with CollectUnresolved.
+	unresolved []rules.UnresolvedImport
+	// diagnostics are the problems found so far, whether they are collected
+	// or logged.
+	diagnostics []*diag.Error
 }
 
 // New returns a new Generator which is responsible for a Go repository.
@@ -50,6 +135,14 @@ type Generator struct {
 // "repoRoot" is a path to the root directory of the repository.
 // "goPrefix" is the go_prefix corresponding to the repository root directory.
 // See also https://github.com/bazelbuild/rules_go#go_prefix.
+//
+// Workspaces nested under "repoRoot" are not traversed. Imports of their
+// packages are resolved into labels in the repositories they are declared
+// as, or named by their WORKSPACE files, or else into the ones
+// rules.RepositoryName derives from their go_prefix. A subtree of the repository can have its own import path
+// prefix, declared by a "# gazelle:prefix <importpath>" line in the BUILD
+// file at its root. A go_prefix rule with the prefix is generated at the root
+// of the subtree, and the Go rules in the subtree use it.
 func New(repoRoot, goPrefix string) (*Generator, error) {
 	bctx := build.Default
 	// Ignore source files in $GOROOT and $GOPATH
@@ -60,11 +153,21 @@ func New(repoRoot, goPrefix string) (*Generator, error) {
 	if err != nil {
 		return nil, err
 	}
+	repoRoot = filepath.Clean(repoRoot)
+	mapped, err := LoadMappedKinds(repoRoot)
+	if err != nil {
+		return nil, err
+	}
+	vis, err := LoadVisibilityRules(repoRoot)
+	if err != nil {
+		return nil, err
+	}
 	return &Generator{
-		repoRoot: filepath.Clean(repoRoot),
-		goPrefix: goPrefix,
-		bctx:     bctx,
-		g:        rules.NewGenerator(goPrefix),
+		MappedKinds: mapped,
+		Visibility:  vis,
+		repoRoot:    repoRoot,
+		goPrefix:    goPrefix,
+		bctx:        bctx,
 	}, nil
 }
 
@@ -72,7 +175,25 @@ func New(repoRoot, goPrefix string) (*Generator, error) {
 // the given directory.
 // The directory must be the repository root directory the caller
 // passed to New, or its subdirectory.
+//
+// If g.KeepGoing is true and some packages fail, Generate returns the files
+// for the other packages together with a packages.ErrorList.
+//
+// The go_library of a package imported only by tests, or by other such
+// packages, is marked testonly. Imports of libraries declared testonly in
+// existing BUILD files by non-test code are reported.
 func (g *Generator) Generate(dir string) ([]*bzl.File, error) {
+	return g.generate(dir, true)
+}
+
+// GeneratePackage is like Generate, but it generates a BUILD file only for
+// the Go package in the directory "dir", if there is one, and not for the
+// packages in its subdirectories.
+func (g *Generator) GeneratePackage(dir string) ([]*bzl.File, error) {
+	return g.generate(dir, false)
+}
+
+func (g *Generator) generate(dir string, recursive bool) ([]*bzl.File, error) {
 	dir, err := filepath.Abs(dir)
 	if err != nil {
 		return nil, err
@@ -81,9 +202,73 @@ func (g *Generator) Generate(dir string) ([]*bzl.File, error) {
 	if !isDescendingDir(dir, g.repoRoot) {
 		return nil, fmt.Errorf("dir %s is not under the repository root %s", dir, g.repoRoot)
 	}
+	if g.g == nil {
+		if g.roots, g.prefixRules, err = importRoots(g.repoRoot, g.goPrefix); err != nil {
+			return nil, err
+		}
+		roots := g.roots
+		if g.UseImportComments {
+			if g.Incremental {
+				g.commentRoots = g.prefixRules
+			} else if g.commentRoots, err = g.importCommentRoots(); err != nil {
+				return nil, err
+			}
+			roots = append(g.commentRoots, roots...)
+		}
+		var symlink func(rel string) (link, real string, err error)
+		if g.FollowSymlinks {
+			symlink = func(rel string) (link, real string, err error) {
+				return packages.Symlink(g.repoRoot, rel)
+			}
+		}
+		var repos map[string]bool
+		if g.CheckRepositories {
+			var repoRoots []rules.ImportRoot
+			if repos, repoRoots, err = declaredRepos(g.repoRoot); err != nil {
+				return nil, err
+			}
+			roots = append(roots, repoRoots...)
+		}
+		var onUnresolved func(rules.UnresolvedImport)
+		if g.CollectUnresolved {
+			onUnresolved = g.addUnresolved
+		}
+		std := g.StdPackages
+		if std == nil {
+			if std, err = rules.DefaultStdPackages(); err != nil {
+				return nil, err
+			}
+		}
+		g.g = rules.NewGenerator(rules.Config{
+			GoPrefix:     g.goPrefix,
+			ImportRoots:  roots,
+			StdPackages:  std,
+			Plugins:      g.Plugins,
+			Naming:       g.Naming,
+			GoGenerate:   g.GoGenerate,
+			Visibility:   g.Visibility,
+			Symlink:      symlink,
+			Repositories: repos,
+			Unresolved:   g.Unresolved,
+			OnUnresolved: onUnresolved,
+			OnDiagnostic: g.diagnoseRel,
+			RepoName:     g.RepoName,
+		})
+	}
+	if g.testOnly == nil && g.infersTestOnly() {
+		ig, err := g.loadImportGraph()
+		if err != nil {
+			return nil, err
+		}
+		g.testOnly = ig.testOnly()
+		g.checkTestOnly(ig, g.testOnly)
+	}
 
+	opts := g.walkOptions(g.KeepGoing)
+	opts.SkipSubdirs = !recursive
+	opts.EmptyDirs = true
 	var files []*bzl.File
-	err = packages.Walk(g.bctx, dir, func(pkg *build.Package) error {
+	err = packages.WalkWithOptions(g.bctx, dir, opts, func(pkg *build.Package) error {
 		rel, err := filepath.Rel(g.repoRoot, pkg.Dir)
 		if err != nil {
 			return err
@@ -91,26 +276,93 @@ func (g *Generator) Generate(dir string) ([]*bzl.File, error) {
 		if rel == "." {
 			rel = ""
 		}
-		if len(files) == 0 && rel != "" {
+		if recursive && len(files) == 0 && rel != "" {
 			// "dir" was not a buildable Go package but still need a BUILD file
 			// for go_prefix.
 			files = append(files, emptyToplevel(g.goPrefix))
 		}
+		g.checkImportComment(rel, pkg)
+		if !g.infersTestOnly() {
+			g.checkTestOnlyImports(rel, pkg)
+		}
 
 		file, err := g.generateOne(rel, pkg)
 		if err != nil {
 			return err
 		}
+		if file == nil {
+			return nil
+		}
 
 		files = append(files, file)
 		return nil
 	})
+	if _, ok := err.(packages.ErrorList); ok {
+		return files, err
+	}
 	if err != nil {
 		return nil, err
 	}
 	return files, nil
 }
 
+// UnresolvedImports returns the imports collected with CollectUnresolved by
+// the calls of Generate so far. Each import is listed once per importing
+// package.
+func (g *Generator) UnresolvedImports() []rules.UnresolvedImport {
+	return g.unresolved
+}
+
+func (g *Generator) addUnresolved(u rules.UnresolvedImport) {
+	for _, other := range g.unresolved {
+		if other.ImportPath == u.ImportPath && other.Dir == u.Dir {
+			return
+		}
+	}
+	g.unresolved = append(g.unresolved, u)
+}
+
+// Diagnostics returns the problems collected with CollectDiagnostics by the
+// calls of Generate so far. Each problem is listed once.
+func (g *Generator) Diagnostics() []*diag.Error {
+	if !g.CollectDiagnostics {
+		return nil
+	}
+	return g.diagnostics
+}
+
+// diagnose collects "e" with CollectDiagnostics, or else logs it, unless the
+// same problem has been found before, e.g. by another walk of the
+// repository.
+func (g *Generator) diagnose(e *diag.Error) {
+	for _, other := range g.diagnostics {
+		if *other == *e {
+			return
+		}
+	}
+	g.diagnostics = append(g.diagnostics, e)
+	if !g.CollectDiagnostics {
+		log.Print(e)
+	}
+}
+
+// diagnoseRel is diagnose for an Error whose path is relative to the
+// repository root.
+func (g *Generator) diagnoseRel(e *diag.Error) {
+	e.Path = filepath.Join(g.repoRoot, e.Path)
+	g.diagnose(e)
+}
+
+// walkOptions returns the options to walk the repository with.
+func (g *Generator) walkOptions(keepGoing bool) packages.Options {
+	return packages.Options{
+		KeepGoing:      keepGoing,
+		FollowSymlinks: g.FollowSymlinks,
+		RepoRoot:       g.repoRoot,
+		OnDiagnostic:   g.diagnose,
+	}
+}
+
 func emptyToplevel(goPrefix string) *bzl.File {
 	return &bzl.File{
 		Path: "BUILD",
@@ -126,22 +378,76 @@ func emptyToplevel(goPrefix string) *bzl.File {
 	}
 }
 
+// generateOne generates the BUILD file of the package "pkg" in the directory
+// "rel". A directory without Go files is passed with an empty package, so
+// that plugins generate rules for it; it gets no BUILD file if there are
+// none.
 func (g *Generator) generateOne(rel string, pkg *build.Package) (*bzl.File, error) {
 	rs, err := g.g.Generate(filepath.ToSlash(rel), pkg)
 	if err != nil {
 		return nil, err
 	}
+	if len(rs) == 0 {
+		return nil, nil
+	}
 
 	file := &bzl.File{Path: filepath.Join(rel, "BUILD")}
 	for _, r := range rs {
+		// The kind is the one gazelle generates until mapKind changes it.
+		if r.Kind() == "go_library" && g.isTestOnly(filepath.ToSlash(rel)) {
+			r.SetAttr("testonly", &bzl.LiteralExpr{Token: "1"})
+		}
+		g.mapKind(r)
 		file.Stmt = append(file.Stmt, r.Call)
 	}
-	if load := g.generateLoad(file); load != nil {
-		file.Stmt = append([]bzl.Expr{load}, file.Stmt...)
-	}
+	file.Stmt = append(g.generateLoads(file), file.Stmt...)
 	return file, nil
 }
 
+// mapKind changes the kind of "r" as g.MappedKinds tell.
+func (g *Generator) mapKind(r *bzl.Rule) {
+	for _, m := range g.MappedKinds {
+		if r.Kind() == m.From {
+			r.Call.X = &bzl.LiteralExpr{Token: m.To}
+			return
+		}
+	}
+}
+
+// generateLoads returns the load statements for the rules in "f": one for
+// the Go rules, followed by one for each Skylark file which defines kinds of
+// plugin rules or mapped kinds in "f".
+func (g *Generator) generateLoads(f *bzl.File) []bzl.Expr {
+	var loads []bzl.Expr
+	if load := g.generateLoad(f); load != nil {
+		loads = append(loads, load)
+	}
+
+	loadOf := make(map[string]string)
+	for kind, info := range rules.PluginKinds(g.Plugins) {
+		loadOf[kind] = info.Load
+	}
+	for _, m := range g.MappedKinds {
+		loadOf[m.To] = m.Load
+	}
+	symbols := make(map[string][]string)
+	for kind, file := range loadOf {
+		if file != "" && len(f.Rules(kind)) > 0 {
+			symbols[file] = append(symbols[file], kind)
+		}
+	}
+	var files []string
+	for file := range symbols {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		sort.Strings(symbols[file])
+		loads = append(loads, loadFileExpr(file, symbols[file]...))
+	}
+	return loads
+}
+
 func (g *Generator) generateLoad(f *bzl.File) bzl.Expr {
 	var list []string
 	for _, kind := range []string{
@@ -162,8 +468,12 @@ func (g *Generator) generateLoad(f *bzl.File) bzl.Expr {
 }
 
 func loadExpr(rules ...string) bzl.Expr {
+	return loadFileExpr(GoRulesBzl, rules...)
+}
+
+func loadFileExpr(file string, rules ...string) bzl.Expr {
 	list := []bzl.Expr{
-		&bzl.StringExpr{Value: GoRulesBzl},
+		&bzl.StringExpr{Value: file},
 	}
 	for _, r := range rules {
 		list = append(list, &bzl.StringExpr{Value: r})
@@ -18,6 +18,8 @@ package generator
 import (
 	"fmt"
 	"go/build"
+	"io/ioutil"
+	"os"
 	"path/filepath"
 	"reflect"
 	"sort"
@@ -25,6 +27,7 @@ import (
 	"testing"
 
 	bzl "github.com/bazelbuild/buildifier/core"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/rules"
 	"github.com/bazelbuild/rules_go/go/tools/gazelle/testdata"
 )
 
@@ -161,3 +164,171 @@ type stubRuleGen struct {
 func (s stubRuleGen) Generate(rel string, pkg *build.Package) ([]*bzl.Rule, error) {
 	return s.fixtures[rel], nil
 }
+
+// protoPlugin generates a "fake_proto_library" rule in each directory with
+// .proto files.
+type protoPlugin struct{}
+
+func (protoPlugin) Kinds() map[string]rules.KindInfo {
+	return map[string]rules.KindInfo{"fake_proto_library": {MergeableAttrs: []string{"srcs"}}}
+}
+
+func (protoPlugin) Generate(rel string, pkg *build.Package, r rules.Resolver) ([]*bzl.Rule, error) {
+	protos, err := filepath.Glob(filepath.Join(pkg.Dir, "*.proto"))
+	if err != nil || len(protos) == 0 {
+		return nil, err
+	}
+	return []*bzl.Rule{{Call: &bzl.CallExpr{X: &bzl.LiteralExpr{Token: "fake_proto_library"}}}}, nil
+}
+
+func (protoPlugin) Resolve(importpath string) (string, bool) {
+	return "", false
+}
+
+func TestGeneratePluginsInEmptyDirs(t *testing.T) {
+	repo, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "generator_test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(%q, %q) failed with %v; want success", os.Getenv("TEST_TMPDIR"), "generator_test", err)
+	}
+	defer os.RemoveAll(repo)
+	for _, p := range []string{"lib/lib.go", "protos/api.proto", "docs/README"} {
+		path := filepath.Join(repo, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("package lib\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	g, err := New(repo, "example.com/repo")
+	if err != nil {
+		t.Fatalf(`New(%q, "example.com/repo") failed with %v; want success`, repo, err)
+	}
+	g.Plugins = []rules.Plugin{protoPlugin{}}
+	files, err := g.Generate(repo)
+	if err != nil {
+		t.Fatalf("g.Generate(%q) failed with %v; want success", repo, err)
+	}
+
+	got := make(map[string][]string)
+	for _, f := range files {
+		for _, r := range f.Rules("") {
+			if r.Kind() != "load" {
+				got[filepath.ToSlash(f.Path)] = append(got[filepath.ToSlash(f.Path)], r.Kind())
+			}
+		}
+	}
+	want := map[string][]string{
+		"BUILD":        {"go_prefix"},
+		"lib/BUILD":    {"go_library"},
+		"protos/BUILD": {"fake_proto_library"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("kinds of the rules generated by g.Generate(%q) = %v; want %v", repo, got, want)
+	}
+}
+
+func TestGenerateIncremental(t *testing.T) {
+	repo, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "generator_test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(%q, %q) failed with %v; want success", os.Getenv("TEST_TMPDIR"), "generator_test", err)
+	}
+	defer os.RemoveAll(repo)
+	for p, content := range map[string]string{
+		"testutil/testutil.go": "package testutil\n",
+		"testutil/BUILD":       "go_library(\n    name = \"go_default_library\",\n    testonly = 1,\n)\n",
+		"vendored/v.go":        "package v // import \"example.org/v\"\n",
+		"vendored/BUILD":       "go_prefix(\"example.org/v\")\n",
+		"lib/lib.go":           "package lib\n\nimport (\n\t_ \"example.com/repo/testutil\"\n\t_ \"example.org/v\"\n)\n",
+	} {
+		path := filepath.Join(repo, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	g, err := New(repo, "example.com/repo")
+	if err != nil {
+		t.Fatalf(`New(%q, "example.com/repo") failed with %v; want success`, repo, err)
+	}
+	g.UseImportComments = true
+	g.Incremental = true
+	libs := make(map[string]*bzl.Rule)
+	for _, rel := range []string{"lib", "testutil"} {
+		dir := filepath.Join(repo, rel)
+		files, err := g.GeneratePackage(dir)
+		if err != nil {
+			t.Fatalf("g.GeneratePackage(%q) failed with %v; want success", dir, err)
+		}
+		for _, f := range files {
+			for _, r := range f.Rules("go_library") {
+				libs[rel] = r
+			}
+		}
+	}
+
+	// Without the import graph, the existing BUILD file tells that testutil
+	// is testonly, and the go_prefix rule of vendored its import path.
+	if got := libs["testutil"].AttrLiteral("testonly"); got != "1" {
+		t.Errorf("testonly of //testutil = %q; want \"1\"", got)
+	}
+	want := []string{"//testutil:go_default_library", "//vendored:go_default_library"}
+	if got := libs["lib"].AttrStrings("deps"); !reflect.DeepEqual(got, want) {
+		t.Errorf("deps of //lib = %q; want %q", got, want)
+	}
+}
+
+func TestGenerateFollowSymlinks(t *testing.T) {
+	repo, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "generator_test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(%q, %q) failed with %v; want success", os.Getenv("TEST_TMPDIR"), "generator_test", err)
+	}
+	defer os.RemoveAll(repo)
+	for p, content := range map[string]string{
+		"real/lib/lib.go": "package lib\n",
+		"tree/main.go":    "package main\n\nimport _ \"example.com/repo/tree/shared\"\n",
+	} {
+		path := filepath.Join(repo, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := filepath.Join(repo, "tree", "shared")
+	if err := os.Symlink(filepath.Join("..", "real", "lib"), link); err != nil {
+		t.Fatalf("os.Symlink(%q) failed with %v; want success", link, err)
+	}
+
+	g, err := New(repo, "example.com/repo")
+	if err != nil {
+		t.Fatalf(`New(%q, "example.com/repo") failed with %v; want success`, repo, err)
+	}
+	g.FollowSymlinks = true
+	files, err := g.Generate(repo)
+	if err != nil {
+		t.Fatalf("g.Generate(%q) failed with %v; want success", repo, err)
+	}
+
+	// The linked directory gets one BUILD file at its real path, and the
+	// import through the link resolves into its library there.
+	var paths []string
+	var deps []string
+	for _, f := range files {
+		paths = append(paths, filepath.ToSlash(f.Path))
+		for _, r := range f.Rules("go_binary") {
+			deps = r.AttrStrings("deps")
+		}
+	}
+	if want := []string{"BUILD", "real/lib/BUILD", "tree/BUILD"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("paths of the generated files = %q; want %q", paths, want)
+	}
+	if want := []string{"//real/lib:go_default_library"}; !reflect.DeepEqual(deps, want) {
+		t.Errorf("deps of //tree = %q; want %q", deps, want)
+	}
+}
@@ -0,0 +1,73 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	bzl "github.com/bazelbuild/buildifier/core"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/diag"
+)
+
+// ErrNoGoPrefix is returned by LoadGoPrefix if the BUILD file does not declare
+// go_prefix.
+var ErrNoGoPrefix = errors.New("no go_prefix in BUILD file")
+
+const goPrefixFix = `go_prefix("<import path of the repository root>"), or pass -go_prefix in fix mode to have gazelle rewrite it`
+
+// LoadGoPrefix returns the go_prefix declared in the BUILD file in the
+// directory "repo". It returns ErrNoGoPrefix if the file does not declare
+// one, and a *diag.Error if the declaration is malformed.
+func LoadGoPrefix(repo string) (string, error) {
+	p := filepath.Join(repo, "BUILD")
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return "", err
+	}
+	f, err := bzl.Parse(p, b)
+	if err != nil {
+		return "", diag.ParseError(p, err)
+	}
+	for _, s := range f.Stmt {
+		c, ok := s.(*bzl.CallExpr)
+		if !ok {
+			continue
+		}
+		l, ok := c.X.(*bzl.LiteralExpr)
+		if !ok {
+			continue
+		}
+		if l.Token != "go_prefix" {
+			continue
+		}
+		if len(c.List) != 1 {
+			e := diag.New(p, c, fmt.Sprintf("go_prefix takes exactly one argument but got %d", len(c.List)))
+			e.Fix = goPrefixFix
+			return "", e
+		}
+		v, ok := c.List[0].(*bzl.StringExpr)
+		if !ok {
+			e := diag.New(p, c, "the argument of go_prefix is not a string literal")
+			e.Fix = goPrefixFix
+			return "", e
+		}
+		return v.Value, nil
+	}
+	return "", ErrNoGoPrefix
+}
@@ -0,0 +1,157 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"fmt"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/diag"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/packages"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/rules"
+)
+
+// importPath returns the import path of the package in the directory "rel",
+// implied by the go_prefix of the repository or of the subtree which
+// contains the directory.
+func (g *Generator) importPath(rel string) string {
+	prefix, dir := g.goPrefix, ""
+	for _, r := range g.roots {
+		if r.Repo != "" {
+			continue
+		}
+		if r.Dir != "" && rel != r.Dir && !strings.HasPrefix(rel, r.Dir+"/") {
+			continue
+		}
+		if len(r.Dir) >= len(dir) {
+			prefix, dir = r.GoPrefix, r.Dir
+		}
+	}
+	return path.Join(prefix, strings.TrimPrefix(strings.TrimPrefix(rel, dir), "/"))
+}
+
+// localDir returns the directory relative to the repository root of the
+// package which "importpath" resolves into by the go_prefix of the
+// repository, its prefix subtrees and the import comments in use. It returns
+// false if the import path is outside of them.
+func (g *Generator) localDir(importpath string) (string, bool) {
+	roots := append([]rules.ImportRoot{{GoPrefix: g.goPrefix}}, g.roots...)
+	roots = append(roots, g.commentRoots...)
+	var best *rules.ImportRoot
+	for i, r := range roots {
+		if r.Repo != "" || r.GoPrefix == "" {
+			continue
+		}
+		if importpath != r.GoPrefix && !strings.HasPrefix(importpath, r.GoPrefix+"/") {
+			continue
+		}
+		if best == nil || len(r.GoPrefix) > len(best.GoPrefix) {
+			best = &roots[i]
+		}
+	}
+	if best == nil {
+		return "", false
+	}
+	return path.Join(best.Dir, strings.TrimPrefix(strings.TrimPrefix(importpath, best.GoPrefix), "/")), true
+}
+
+// checkImportComment reports if the import comment of "pkg" does not match
+// the import path implied by its location "rel". Packages whose import
+// comments are used with UseImportComments are not reported.
+func (g *Generator) checkImportComment(rel string, pkg *build.Package) {
+	if pkg.ImportComment == "" {
+		return
+	}
+	for _, r := range g.commentRoots {
+		if r.Dir == rel && r.GoPrefix == pkg.ImportComment {
+			return
+		}
+	}
+	want := g.importPath(rel)
+	if pkg.ImportComment == want {
+		return
+	}
+	e := importCommentPos(pkg)
+	e.Msg = fmt.Sprintf("import comment %q does not match import path %q implied by go_prefix", pkg.ImportComment, want)
+	e.Fix = fmt.Sprintf("move the package to the directory of %q, declare its prefix with %q, or resolve imports by the comment with -use_import_comments", pkg.ImportComment, prefixDirective)
+	g.diagnose(e)
+}
+
+// importCommentPos returns an Error which locates the import comment of
+// "pkg" in its Go files, or just names its first Go file if the comment
+// cannot be found.
+func importCommentPos(pkg *build.Package) *diag.Error {
+	files := append(append([]string(nil), pkg.GoFiles...), pkg.CgoFiles...)
+	if len(files) == 0 {
+		return &diag.Error{Path: pkg.Dir}
+	}
+	quoted := strconv.Quote(pkg.ImportComment)
+	fset := token.NewFileSet()
+	for _, name := range files {
+		path := filepath.Join(pkg.Dir, name)
+		f, err := parser.ParseFile(fset, path, nil, parser.PackageClauseOnly|parser.ParseComments)
+		if err != nil {
+			continue
+		}
+		line := fset.Position(f.Name.End()).Line
+		for _, cg := range f.Comments {
+			for _, c := range cg.List {
+				if pos := fset.Position(c.Pos()); pos.Line == line && strings.Contains(c.Text, quoted) {
+					return &diag.Error{Path: path, Line: pos.Line, Column: pos.Column, Rule: c.Text}
+				}
+			}
+		}
+	}
+	return &diag.Error{Path: filepath.Join(pkg.Dir, files[0])}
+}
+
+// importCommentRoots returns an import root for each package in the
+// repository with an import comment other than the import path implied by
+// its location, which maps the import path in the comment to the directory
+// of the package.
+func (g *Generator) importCommentRoots() ([]rules.ImportRoot, error) {
+	var roots []rules.ImportRoot
+	err := packages.WalkWithOptions(g.bctx, g.repoRoot, g.walkOptions(true), func(pkg *build.Package) error {
+		if pkg.ImportComment == "" {
+			return nil
+		}
+		rel, err := filepath.Rel(g.repoRoot, pkg.Dir)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			rel = ""
+		}
+		if pkg.ImportComment == g.importPath(rel) {
+			return nil
+		}
+		roots = append(roots, rules.ImportRoot{Dir: rel, GoPrefix: pkg.ImportComment})
+		return nil
+	})
+	// Packages which cannot be imported are reported when their BUILD files
+	// are generated.
+	if _, ok := err.(packages.ErrorList); ok {
+		err = nil
+	}
+	return roots, err
+}
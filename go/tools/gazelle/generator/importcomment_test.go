@@ -0,0 +1,177 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	bzl "github.com/bazelbuild/buildifier/core"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/diag"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/rules"
+)
+
+func TestImportPath(t *testing.T) {
+	g := &Generator{
+		goPrefix: "example.com/mono",
+		roots: []rules.ImportRoot{
+			{Repo: "nested", GoPrefix: "example.com/nested"},
+			{Dir: "projects/a", GoPrefix: "example.org/a"},
+		},
+	}
+	for _, spec := range []struct {
+		rel, want string
+	}{
+		{rel: "", want: "example.com/mono"},
+		{rel: "lib", want: "example.com/mono/lib"},
+		{rel: "projects/a", want: "example.org/a"},
+		{rel: "projects/a/lib", want: "example.org/a/lib"},
+		{rel: "projects/ab", want: "example.com/mono/projects/ab"},
+	} {
+		if got := g.importPath(spec.rel); got != spec.want {
+			t.Errorf("g.importPath(%q) = %q; want %q", spec.rel, got, spec.want)
+		}
+	}
+}
+
+func TestCheckImportComment(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "importcomment_test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(%q, %q) failed with %v; want success", os.Getenv("TEST_TMPDIR"), "importcomment_test", err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{
+		"a.go": "// Package a does nothing.\npackage a\n",
+		"b.go": "package a // import \"example.org/a\"\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pkg, err := build.ImportDir(dir, build.ImportComment)
+	if err != nil {
+		t.Fatalf("build.ImportDir(%q, build.ImportComment) failed with %v; want success", dir, err)
+	}
+
+	for _, spec := range []struct {
+		desc         string
+		goPrefix     string
+		commentRoots []rules.ImportRoot
+		want         []*diag.Error
+	}{
+		{
+			desc:     "mismatch",
+			goPrefix: "example.com/repo",
+			want: []*diag.Error{{
+				Path:   filepath.Join(dir, "b.go"),
+				Line:   1,
+				Column: 11,
+				Rule:   `// import "example.org/a"`,
+				Msg:    `import comment "example.org/a" does not match import path "example.com/repo/a" implied by go_prefix`,
+			}},
+		},
+		{
+			desc:     "match",
+			goPrefix: "example.org",
+		},
+		{
+			desc:         "used",
+			goPrefix:     "example.com/repo",
+			commentRoots: []rules.ImportRoot{{Dir: "a", GoPrefix: "example.org/a"}},
+		},
+	} {
+		g := &Generator{goPrefix: spec.goPrefix, commentRoots: spec.commentRoots, CollectDiagnostics: true}
+		g.checkImportComment("a", pkg)
+		g.checkImportComment("a", pkg)
+		got := g.Diagnostics()
+		for _, e := range got {
+			e.Fix = ""
+		}
+		if !reflect.DeepEqual(got, spec.want) {
+			t.Errorf("%s: diagnostics = %v; want %v", spec.desc, got, spec.want)
+		}
+	}
+}
+
+func TestGenerateUseImportComments(t *testing.T) {
+	repo, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "importcomment_test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(%q, %q) failed with %v; want success", os.Getenv("TEST_TMPDIR"), "importcomment_test", err)
+	}
+	defer os.RemoveAll(repo)
+	for p, content := range map[string]string{
+		"vendored/v.go": "package v // import \"example.org/v\"\n",
+		"lib/lib.go":    "package lib\n\nimport _ \"example.org/v\"\n",
+	} {
+		path := filepath.Join(repo, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, use := range []bool{false, true} {
+		g, err := New(repo, "example.com/repo")
+		if err != nil {
+			t.Fatalf(`New(%q, "example.com/repo") failed with %v; want success`, repo, err)
+		}
+		g.UseImportComments = use
+		g.CollectDiagnostics = true
+		g.CollectUnresolved = true
+		// Without UseImportComments, the import of example.org/v would be
+		// resolved as an external one, so only //vendored is generated.
+		dir := filepath.Join(repo, "vendored")
+		if use {
+			dir = repo
+		}
+		files, err := g.Generate(dir)
+		if err != nil {
+			t.Fatalf("g.Generate(%q) failed with %v; want success", dir, err)
+		}
+		byPath := make(map[string]*bzl.File)
+		for _, f := range files {
+			byPath[filepath.ToSlash(f.Path)] = f
+		}
+
+		diags := g.Diagnostics()
+		if !use {
+			if len(diags) != 1 || !strings.Contains(diags[0].Msg, `import comment "example.org/v"`) {
+				t.Errorf("without UseImportComments: diagnostics = %v; want one about the import comment of //vendored", diags)
+			}
+			continue
+		}
+		if len(diags) != 0 {
+			t.Errorf("with UseImportComments: diagnostics = %v; want none", diags)
+		}
+		var deps []string
+		for _, r := range byPath["lib/BUILD"].Rules("go_library") {
+			deps = r.AttrStrings("deps")
+		}
+		if want := []string{"//vendored:go_default_library"}; !reflect.DeepEqual(deps, want) {
+			t.Errorf("with UseImportComments: deps of //lib = %q; want %q", deps, want)
+		}
+		if rs := byPath["vendored/BUILD"].Rules("go_prefix"); len(rs) != 1 {
+			t.Errorf("with UseImportComments: //vendored has %d go_prefix rules; want 1", len(rs))
+		}
+	}
+}
@@ -0,0 +1,189 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// InferGoPrefix guesses the go_prefix of the repository at "repoRoot", which
+// does not declare one. It tries, in order:
+//
+//   - the import comments of the packages in the root directory and its
+//     immediate subdirectories,
+//   - the location of the repository under $GOPATH/src,
+//   - the URL of the "origin" remote in the git configuration,
+//   - the module line of a go.mod file in the root directory.
+//
+// It returns the prefix together with a description of where it came from.
+func InferGoPrefix(repoRoot string) (prefix, source string, err error) {
+	repoRoot, err = filepath.Abs(repoRoot)
+	if err != nil {
+		return "", "", err
+	}
+	for _, infer := range []func(string) (string, string, error){
+		prefixFromImportComments,
+		prefixFromGopath,
+		prefixFromGitRemote,
+		prefixFromGoMod,
+	} {
+		prefix, source, err := infer(repoRoot)
+		if err != nil {
+			return "", "", err
+		}
+		if prefix != "" {
+			return prefix, source, nil
+		}
+	}
+	return "", "", errors.New("cannot infer go_prefix from import comments, GOPATH, git remote or go.mod")
+}
+
+func prefixFromImportComments(repoRoot string) (string, string, error) {
+	dirs := []string{repoRoot}
+	fis, err := ioutil.ReadDir(repoRoot)
+	if err != nil {
+		return "", "", err
+	}
+	for _, fi := range fis {
+		if base := fi.Name(); fi.IsDir() && base[0] != '.' && base[0] != '_' && base != "testdata" {
+			dirs = append(dirs, filepath.Join(repoRoot, base))
+		}
+	}
+	for _, dir := range dirs {
+		pkg, err := build.ImportDir(dir, build.ImportComment)
+		if err != nil || pkg.ImportComment == "" {
+			continue
+		}
+		prefix := pkg.ImportComment
+		if dir != repoRoot {
+			suffix := "/" + filepath.Base(dir)
+			if !strings.HasSuffix(prefix, suffix) {
+				continue
+			}
+			prefix = strings.TrimSuffix(prefix, suffix)
+		}
+		return prefix, fmt.Sprintf("the import comment in %s", dir), nil
+	}
+	return "", "", nil
+}
+
+func prefixFromGopath(repoRoot string) (string, string, error) {
+	for _, gopath := range filepath.SplitList(build.Default.GOPATH) {
+		src := filepath.Join(gopath, "src")
+		if !isDescendingDir(repoRoot, src) || repoRoot == src {
+			continue
+		}
+		rel, err := filepath.Rel(src, repoRoot)
+		if err != nil {
+			return "", "", err
+		}
+		return filepath.ToSlash(rel), fmt.Sprintf("the location under %s", src), nil
+	}
+	return "", "", nil
+}
+
+func prefixFromGitRemote(repoRoot string) (string, string, error) {
+	p := filepath.Join(repoRoot, ".git", "config")
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
+	var inOrigin bool
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(line, "[") {
+			inOrigin = line == `[remote "origin"]`
+			continue
+		}
+		if !inOrigin {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) != "url" {
+			continue
+		}
+		url := strings.TrimSpace(kv[1])
+		if prefix := importPathFromURL(url); prefix != "" {
+			return prefix, fmt.Sprintf("the git remote %s", url), nil
+		}
+	}
+	return "", "", s.Err()
+}
+
+// importPathFromURL converts the URL of a git remote, e.g.
+// https://github.com/foo/bar.git or git@github.com:foo/bar.git, into an
+// import path like github.com/foo/bar.
+func importPathFromURL(url string) string {
+	if i := strings.Index(url, "://"); i >= 0 {
+		url = url[i+len("://"):]
+	} else if i := strings.Index(url, ":"); i >= 0 {
+		// scp-like syntax: [user@]host:path
+		url = url[:i] + "/" + url[i+1:]
+	}
+	if i := strings.Index(url, "@"); i >= 0 && i < strings.Index(url, "/") {
+		url = url[i+1:]
+	}
+	if i := strings.Index(url, "/"); i >= 0 {
+		// Drop a port number from the host.
+		if j := strings.Index(url[:i], ":"); j >= 0 {
+			url = url[:j] + url[i:]
+		}
+	}
+	url = strings.TrimSuffix(strings.TrimSuffix(url, "/"), ".git")
+	if strings.HasPrefix(url, "/") || !strings.Contains(url, "/") {
+		// A local path rather than a remote URL.
+		return ""
+	}
+	return path.Clean(url)
+}
+
+func prefixFromGoMod(repoRoot string) (string, string, error) {
+	p := filepath.Join(repoRoot, "go.mod")
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "module" {
+			continue
+		}
+		module := fields[1]
+		if unquoted, err := strconv.Unquote(module); err == nil {
+			module = unquoted
+		}
+		return module, fmt.Sprintf("the module line in %s", p), nil
+	}
+	return "", "", nil
+}
@@ -0,0 +1,79 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImportPathFromURL(t *testing.T) {
+	for _, spec := range []struct {
+		url, want string
+	}{
+		{url: "https://github.com/foo/bar.git", want: "github.com/foo/bar"},
+		{url: "https://github.com/foo/bar", want: "github.com/foo/bar"},
+		{url: "git@github.com:foo/bar.git", want: "github.com/foo/bar"},
+		{url: "ssh://git@example.com:2222/foo/bar.git", want: "example.com/foo/bar"},
+		{url: "/home/user/src/bar", want: ""},
+	} {
+		if got := importPathFromURL(spec.url); got != spec.want {
+			t.Errorf("importPathFromURL(%q) = %q; want %q", spec.url, got, spec.want)
+		}
+	}
+}
+
+func TestInferGoPrefix(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "infer_test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(%q, %q) failed with %v; want success", os.Getenv("TEST_TMPDIR"), "infer_test", err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(rel, content string) {
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatalf("os.MkdirAll(%q, 0700) failed with %v; want success", filepath.Dir(path), err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("ioutil.WriteFile(%q, %q, 0600) failed with %v; want success", path, content, err)
+		}
+	}
+
+	if _, _, err := InferGoPrefix(dir); err == nil {
+		t.Errorf("InferGoPrefix(%q) succeeded; want error", dir)
+	}
+
+	for _, spec := range []struct {
+		rel, content, want string
+	}{
+		{rel: "go.mod", content: "module example.com/mod\n", want: "example.com/mod"},
+		{rel: ".git/config", content: "[core]\n\tbare = false\n[remote \"origin\"]\n\turl = git@example.com:foo/git.git\n", want: "example.com/foo/git"},
+		{rel: "lib/lib.go", content: "package lib // import \"example.com/comment/lib\"\n", want: "example.com/comment"},
+	} {
+		write(spec.rel, spec.content)
+		prefix, _, err := InferGoPrefix(dir)
+		if err != nil {
+			t.Errorf("InferGoPrefix(%q) failed with %v; want success", dir, err)
+			continue
+		}
+		if prefix != spec.want {
+			t.Errorf("InferGoPrefix(%q) = %q after writing %s; want %q", dir, prefix, spec.rel, spec.want)
+		}
+	}
+}
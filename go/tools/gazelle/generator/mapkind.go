@@ -0,0 +1,95 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/diag"
+)
+
+// mapKindDirective is a comment in the root BUILD file which tells gazelle to
+// emit rules of another kind in place of a kind it generates, e.g.
+//
+//	# gazelle:map_kind go_library company_go_library //tools:go.bzl
+const mapKindDirective = "# gazelle:map_kind"
+
+// A MappedKind is a rule kind which gazelle emits in place of one it
+// generates.
+type MappedKind struct {
+	// From is the kind gazelle generates, e.g. "go_library".
+	From string
+	// To is the kind gazelle emits instead, e.g. "company_go_library".
+	To string
+	// Load is the label of the Skylark file which defines To.
+	Load string
+}
+
+// LoadMappedKinds returns the kinds mapped with mapKindDirective in the BUILD
+// file in the directory "repo". It returns a *diag.Error for a malformed
+// directive.
+func LoadMappedKinds(repo string) ([]MappedKind, error) {
+	var kinds []MappedKind
+	err := scanDirectives(repo, mapKindDirective, func(p string, line int, text string, args []string) error {
+		if len(args) != 3 {
+			return &diag.Error{
+				Path:   p,
+				Line:   line,
+				Column: 1,
+				Rule:   text,
+				Msg:    fmt.Sprintf("map_kind takes exactly 3 arguments but got %d", len(args)),
+				Fix:    mapKindDirective + " <generated kind> <kind to emit> <label of the .bzl file defining it>",
+			}
+		}
+		kinds = append(kinds, MappedKind{From: args[0], To: args[1], Load: args[2]})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return kinds, nil
+}
+
+// scanDirectives calls "fn" with each comment line in the BUILD file in the
+// directory "repo" which starts with "directive", and the fields after it.
+// It stops at the first error "fn" returns.
+func scanDirectives(repo, directive string, fn func(p string, line int, text string, args []string) error) error {
+	p := filepath.Join(repo, "BUILD")
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if !strings.HasPrefix(text, directive+" ") {
+			continue
+		}
+		if err := fn(p, line, text, strings.Fields(strings.TrimPrefix(text, directive))); err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
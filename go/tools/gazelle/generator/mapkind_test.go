@@ -0,0 +1,103 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	bzl "github.com/bazelbuild/buildifier/core"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/diag"
+)
+
+func TestLoadMappedKinds(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "mapkind_test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(%q, %q) failed with %v; want success", os.Getenv("TEST_TMPDIR"), "mapkind_test", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "BUILD")
+
+	content := `# gazelle:map_kind go_library company_go_library //tools:go.bzl
+  # gazelle:map_kind go_test company_go_test //tools:go.bzl
+# gazelle:map_kinds are not directives
+go_prefix("example.com/repo")
+`
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("ioutil.WriteFile(%q, %q, 0600) failed with %v; want success", path, content, err)
+	}
+	got, err := LoadMappedKinds(dir)
+	if err != nil {
+		t.Fatalf("LoadMappedKinds(%q) failed with %v; want success", dir, err)
+	}
+	want := []MappedKind{
+		{From: "go_library", To: "company_go_library", Load: "//tools:go.bzl"},
+		{From: "go_test", To: "company_go_test", Load: "//tools:go.bzl"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadMappedKinds(%q) = %v; want %v", dir, got, want)
+	}
+
+	content = "go_prefix(\"example.com/repo\")\n# gazelle:map_kind go_library company_go_library\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("ioutil.WriteFile(%q, %q, 0600) failed with %v; want success", path, content, err)
+	}
+	_, err = LoadMappedKinds(dir)
+	if e, ok := err.(*diag.Error); !ok || e.Line != 2 {
+		t.Errorf("LoadMappedKinds(%q) failed with %#v; want *diag.Error at line 2", dir, err)
+	}
+}
+
+func TestGenerateMappedKinds(t *testing.T) {
+	call := func(kind string) *bzl.Rule {
+		return &bzl.Rule{Call: &bzl.CallExpr{X: &bzl.LiteralExpr{Token: kind}}}
+	}
+	g := &Generator{
+		MappedKinds: []MappedKind{
+			{From: "go_library", To: "company_go_library", Load: "//tools:go.bzl"},
+		},
+		g: stubRuleGen{fixtures: map[string][]*bzl.Rule{
+			"lib": {call("go_library"), call("go_test")},
+		}},
+	}
+	f, err := g.generateOne("lib", &build.Package{})
+	if err != nil {
+		t.Fatalf("g.generateOne(%q, pkg) failed with %v; want success", "lib", err)
+	}
+
+	var got [][]string
+	for _, s := range f.Stmt {
+		c := s.(*bzl.CallExpr)
+		stmt := []string{c.X.(*bzl.LiteralExpr).Token}
+		for _, arg := range c.List {
+			stmt = append(stmt, arg.(*bzl.StringExpr).Value)
+		}
+		got = append(got, stmt)
+	}
+	want := [][]string{
+		{"load", GoRulesBzl, "go_test"},
+		{"load", "//tools:go.bzl", "company_go_library"},
+		{"company_go_library"},
+		{"go_test"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("statements of g.generateOne(%q, pkg) = %q; want %q", "lib", got, want)
+	}
+}
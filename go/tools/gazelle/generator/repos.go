@@ -0,0 +1,46 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"os"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/rules"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/wspace"
+)
+
+// declaredRepos returns the names of the repositories declared in the
+// WORKSPACE file of the repository, and an import root for each of them
+// with an importpath attribute, e.g. a go_repository. The names are nil if
+// there is no WORKSPACE file.
+func declaredRepos(repoRoot string) (map[string]bool, []rules.ImportRoot, error) {
+	w, err := wspace.Load(repoRoot)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	names := make(map[string]bool)
+	var roots []rules.ImportRoot
+	for _, r := range w.Repositories("") {
+		names[r.Name] = true
+		if p := r.ImportPath(); p != "" {
+			roots = append(roots, rules.ImportRoot{Repo: r.Name, GoPrefix: p})
+		}
+	}
+	return names, roots, nil
+}
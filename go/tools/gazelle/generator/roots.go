@@ -0,0 +1,163 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/rules"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/wspace"
+)
+
+// prefixDirective is a comment in a BUILD file which declares the import path
+// prefix of the Go packages in the directory of the file and its
+// subdirectories.
+const prefixDirective = "# gazelle:prefix"
+
+// importRoots returns the directory trees under "repoRoot" whose packages
+// are imported with prefixes other than "goPrefix": nested workspaces and
+// subtrees declared with prefixDirective. It also returns the go_prefix
+// rules in the BUILD files of subdirectories, as found by prefixSubtrees.
+// It reads only WORKSPACE and BUILD files, no Go files.
+func importRoots(repoRoot, goPrefix string) (roots, prefixRules []rules.ImportRoot, err error) {
+	if roots, err = nestedRepos(repoRoot, goPrefix); err != nil {
+		return nil, nil, err
+	}
+	subtrees, prefixRules, err := prefixSubtrees(repoRoot)
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(roots, subtrees...), prefixRules, nil
+}
+
+// nestedRepos returns the workspaces nested under "repoRoot". The go_prefix
+// of a nested workspace is the one in its root BUILD file, or else the one
+// implied by its location under "goPrefix". Its name is the one of the
+// local_repository which the WORKSPACE file of "repoRoot" declares for it,
+// or else the one it declares itself with workspace(name = ...), or else
+// the one rules.RepositoryName derives from its go_prefix.
+func nestedRepos(repoRoot, goPrefix string) ([]rules.ImportRoot, error) {
+	dirs, err := wspace.FindNested(repoRoot)
+	if err != nil || len(dirs) == 0 {
+		return nil, err
+	}
+	locals, err := wspace.LocalRepositories(repoRoot)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var repos []rules.ImportRoot
+	for _, dir := range dirs {
+		prefix, err := LoadGoPrefix(dir)
+		if err == ErrNoGoPrefix || os.IsNotExist(err) {
+			rel, err := filepath.Rel(repoRoot, dir)
+			if err != nil {
+				return nil, err
+			}
+			prefix = path.Join(goPrefix, filepath.ToSlash(rel))
+		} else if err != nil {
+			return nil, err
+		}
+		name := locals[filepath.Clean(dir)]
+		if name == "" {
+			if name, err = wspace.Name(dir); err != nil {
+				return nil, err
+			}
+		}
+		if name == "" {
+			name = rules.RepositoryName(prefix)
+		}
+		repos = append(repos, rules.ImportRoot{Repo: name, GoPrefix: prefix})
+	}
+	return repos, nil
+}
+
+// prefixSubtrees returns the subtrees of the repository declared with
+// prefixDirective. It also returns a root for each go_prefix rule in the
+// BUILD file of a subdirectory, such as the ones generated for packages with
+// import comments, which stand in for the import comments when the Go files
+// of the repository are not read.
+func prefixSubtrees(repoRoot string) (subtrees, prefixRules []rules.ImportRoot, err error) {
+	err = filepath.Walk(repoRoot, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if p != repoRoot {
+			if base := info.Name(); base[0] == '.' || base[0] == '_' || base == "testdata" || wspace.IsRoot(p) {
+				return filepath.SkipDir
+			}
+		}
+		directive, rule, err := readPrefixes(filepath.Join(p, "BUILD"))
+		if err != nil || directive == "" && rule == "" {
+			return err
+		}
+		rel, err := filepath.Rel(repoRoot, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if directive != "" {
+			subtrees = append(subtrees, rules.ImportRoot{Dir: rel, GoPrefix: directive})
+		}
+		if rule != "" {
+			prefixRules = append(prefixRules, rules.ImportRoot{Dir: rel, GoPrefix: rule})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return subtrees, prefixRules, nil
+}
+
+// readPrefixes returns the import path declared with prefixDirective in the
+// BUILD file at "p", and the one of a go_prefix rule written on a single
+// line, or "" for each if there is no such file or declaration.
+func readPrefixes(p string) (directive, rule string, err error) {
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		switch {
+		case directive == "" && strings.HasPrefix(line, prefixDirective+" "):
+			directive = strings.TrimSpace(strings.TrimPrefix(line, prefixDirective))
+		case rule == "" && strings.HasPrefix(line, "go_prefix(") && strings.HasSuffix(line, ")"):
+			arg := strings.TrimSuffix(strings.TrimPrefix(line, "go_prefix("), ")")
+			if prefix, err := strconv.Unquote(strings.TrimSpace(arg)); err == nil {
+				rule = prefix
+			}
+		}
+	}
+	return directive, rule, s.Err()
+}
@@ -0,0 +1,112 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/rules"
+)
+
+func TestPrefixSubtrees(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "roots_test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(%q, %q) failed with %v; want success", os.Getenv("TEST_TMPDIR"), "roots_test", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, p := range []struct {
+		path, content string
+	}{
+		{path: "BUILD", content: "go_prefix(\"example.com/mono\")\n"},
+		{path: "projects/a/BUILD", content: "# gazelle:prefix example.org/a\n"},
+		{path: "projects/b/BUILD", content: "# Some comment.\n\n  # gazelle:prefix example.org/b  \n"},
+		{path: "projects/c/BUILD", content: "# gazelle:prefixes are not directives\n"},
+		{path: "testdata/BUILD", content: "# gazelle:prefix example.org/testdata\n"},
+		{path: "vendored/BUILD", content: "go_prefix(\"example.org/vendored\")\n\ngo_library(name = \"go_default_library\")\n"},
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(p.path))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatalf("os.MkdirAll(%q, 0700) failed with %v; want success", filepath.Dir(path), err)
+		}
+		if err := ioutil.WriteFile(path, []byte(p.content), 0600); err != nil {
+			t.Fatalf("ioutil.WriteFile(%q, %q, 0600) failed with %v; want success", path, p.content, err)
+		}
+	}
+
+	got, gotRules, err := prefixSubtrees(dir)
+	if err != nil {
+		t.Fatalf("prefixSubtrees(%q) failed with %v; want success", dir, err)
+	}
+	want := []rules.ImportRoot{
+		{Dir: "projects/a", GoPrefix: "example.org/a"},
+		{Dir: "projects/b", GoPrefix: "example.org/b"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("prefixSubtrees(%q) = %#v; want %#v", dir, got, want)
+	}
+	wantRules := []rules.ImportRoot{
+		{Dir: "vendored", GoPrefix: "example.org/vendored"},
+	}
+	if !reflect.DeepEqual(gotRules, wantRules) {
+		t.Errorf("prefixSubtrees(%q) = _, %#v; want %#v", dir, gotRules, wantRules)
+	}
+}
+
+func TestNestedRepos(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "roots_test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(%q, %q) failed with %v; want success", os.Getenv("TEST_TMPDIR"), "roots_test", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, p := range []struct {
+		path, content string
+	}{
+		{path: "WORKSPACE", content: "local_repository(\n    name = \"local_one\",\n    path = \"third_party/one\",\n)\n"},
+		{path: "third_party/one/WORKSPACE", content: "workspace(name = \"one\")\n"},
+		{path: "named/WORKSPACE", content: "workspace(name = \"named\")\n"},
+		{path: "bare/WORKSPACE", content: ""},
+		{path: "bare/BUILD", content: "go_prefix(\"example.org/bare\")\n"},
+		{path: "plain/WORKSPACE", content: "# no name\n"},
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(p.path))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatalf("os.MkdirAll(%q, 0700) failed with %v; want success", filepath.Dir(path), err)
+		}
+		if err := ioutil.WriteFile(path, []byte(p.content), 0600); err != nil {
+			t.Fatalf("ioutil.WriteFile(%q, %q, 0600) failed with %v; want success", path, p.content, err)
+		}
+	}
+
+	got, err := nestedRepos(dir, "example.com/mono")
+	if err != nil {
+		t.Fatalf("nestedRepos(%q, %q) failed with %v; want success", dir, "example.com/mono", err)
+	}
+	want := []rules.ImportRoot{
+		{Repo: "org_example_bare", GoPrefix: "example.org/bare"},
+		{Repo: "named", GoPrefix: "example.com/mono/named"},
+		{Repo: "com_example_mono_plain", GoPrefix: "example.com/mono/plain"},
+		{Repo: "local_one", GoPrefix: "example.com/mono/third_party/one"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("nestedRepos(%q, %q) = %#v; want %#v", dir, "example.com/mono", got, want)
+	}
+}
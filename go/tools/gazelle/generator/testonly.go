@@ -0,0 +1,222 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+
+	bzl "github.com/bazelbuild/buildifier/core"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/diag"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/packages"
+)
+
+// importGraph describes the imports between the Go packages in the
+// repository, keyed by import path.
+type importGraph struct {
+	// dirs maps the import path of each package to its directory relative
+	// to the repository root.
+	dirs map[string]string
+	// commands is the set of packages which build into binaries.
+	commands map[string]bool
+	// importers maps an import path to the packages whose non-test files
+	// import it.
+	importers map[string][]string
+	// testImported is the set of packages imported by the tests of other
+	// packages.
+	testImported map[string]bool
+}
+
+// loadImportGraph walks the whole repository and returns its import graph.
+// Packages which cannot be imported are left out of the graph. They are
+// reported when their BUILD files are generated.
+func (g *Generator) loadImportGraph() (*importGraph, error) {
+	ig := &importGraph{
+		dirs:         make(map[string]string),
+		commands:     make(map[string]bool),
+		importers:    make(map[string][]string),
+		testImported: make(map[string]bool),
+	}
+	err := packages.WalkWithOptions(g.bctx, g.repoRoot, g.walkOptions(true), func(pkg *build.Package) error {
+		rel, err := filepath.Rel(g.repoRoot, pkg.Dir)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			rel = ""
+		}
+		rel = filepath.ToSlash(rel)
+		importpath := g.importPath(rel)
+		if g.UseImportComments && pkg.ImportComment != "" {
+			importpath = pkg.ImportComment
+		}
+		ig.dirs[importpath] = rel
+		if pkg.IsCommand() {
+			ig.commands[importpath] = true
+		}
+		for _, imp := range pkg.Imports {
+			ig.importers[imp] = append(ig.importers[imp], importpath)
+		}
+		for _, imp := range append(pkg.TestImports, pkg.XTestImports...) {
+			// The external test of a package imports the package itself.
+			if imp != importpath {
+				ig.testImported[imp] = true
+			}
+		}
+		return nil
+	})
+	if _, ok := err.(packages.ErrorList); ok {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ig, nil
+}
+
+// testOnly returns the directories of the libraries which are imported only
+// by the tests of other packages or by other test-only libraries, keyed by their directories
+// relative to the repository root. Libraries imported by nothing in the
+// repository are not test-only.
+func (ig *importGraph) testOnly() map[string]bool {
+	only := make(map[string]bool)
+	for importpath := range ig.dirs {
+		if !ig.commands[importpath] && (ig.testImported[importpath] || len(ig.importers[importpath]) > 0) {
+			only[importpath] = true
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for importpath := range only {
+			for _, importer := range ig.importers[importpath] {
+				if !only[importer] {
+					delete(only, importpath)
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	dirs := make(map[string]bool)
+	for importpath := range only {
+		dirs[ig.dirs[importpath]] = true
+	}
+	return dirs
+}
+
+// checkTestOnly reports each import of a library declared testonly in an
+// existing BUILD file by the non-test files of a package which is not
+// test-only.
+func (g *Generator) checkTestOnly(ig *importGraph, testOnly map[string]bool) {
+	declared := make(map[string]bool)
+	for importpath, rel := range ig.dirs {
+		if g.isTestOnlyInBuildFile(rel) {
+			declared[importpath] = true
+		}
+	}
+
+	var importpaths []string
+	for importpath := range declared {
+		importpaths = append(importpaths, importpath)
+	}
+	sort.Strings(importpaths)
+	for _, importpath := range importpaths {
+		for _, importer := range ig.importers[importpath] {
+			if declared[importer] || testOnly[ig.dirs[importer]] {
+				continue
+			}
+			g.diagnose(g.testOnlyImportError(ig.dirs[importer], importpath))
+		}
+	}
+}
+
+// testOnlyImportError returns the Error which reports that the non-test
+// code of the package in the directory "rel" imports the testonly library
+// "importpath".
+func (g *Generator) testOnlyImportError(rel, importpath string) *diag.Error {
+	return &diag.Error{
+		Path: filepath.Join(g.repoRoot, filepath.FromSlash(rel), "BUILD"),
+		Msg:  fmt.Sprintf("non-test code imports %q, which is declared testonly", importpath),
+		Fix:  "move the import into a test, or remove testonly from the imported library if non-test code may use it",
+	}
+}
+
+// infersTestOnly determines if Generate finds the test-only libraries in the
+// import graph of the repository.
+func (g *Generator) infersTestOnly() bool {
+	return g.InferTestOnly && !g.Incremental
+}
+
+// isTestOnly determines if the library in the directory "rel" is test-only.
+// Unless it is inferred from the import graph, it is if its existing BUILD
+// file declares it so.
+func (g *Generator) isTestOnly(rel string) bool {
+	if !g.infersTestOnly() {
+		return g.isTestOnlyInBuildFile(rel)
+	}
+	return g.testOnly[rel]
+}
+
+// checkTestOnlyImports is checkTestOnly for the package "pkg" in the
+// directory "rel" alone, with the libraries declared testonly in existing
+// BUILD files. It is used without an import graph.
+func (g *Generator) checkTestOnlyImports(rel string, pkg *build.Package) {
+	if g.isTestOnly(rel) {
+		return
+	}
+	for _, imp := range pkg.Imports {
+		dir, ok := g.localDir(imp)
+		if ok && g.isTestOnly(dir) {
+			g.diagnose(g.testOnlyImportError(rel, imp))
+		}
+	}
+}
+
+// isTestOnlyInBuildFile determines if the BUILD file in the directory "rel"
+// declares a go_library, or a kind it is mapped to, with a true testonly
+// attribute. It returns false if the file does not exist or cannot be
+// parsed.
+func (g *Generator) isTestOnlyInBuildFile(rel string) bool {
+	path := filepath.Join(g.repoRoot, filepath.FromSlash(rel), "BUILD")
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	f, err := bzl.Parse(path, b)
+	if err != nil {
+		return false
+	}
+	kinds := map[string]bool{"go_library": true}
+	for _, m := range g.MappedKinds {
+		if m.From == "go_library" {
+			kinds[m.To] = true
+		}
+	}
+	for _, r := range f.Rules("") {
+		if !kinds[r.Kind()] {
+			continue
+		}
+		switch r.AttrLiteral("testonly") {
+		case "1", "True":
+			return true
+		}
+	}
+	return false
+}
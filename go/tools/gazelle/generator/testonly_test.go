@@ -0,0 +1,135 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTestOnly(t *testing.T) {
+	ig := &importGraph{
+		dirs: map[string]string{
+			"example.com/repo/cmd":      "cmd",
+			"example.com/repo/lib":      "lib",
+			"example.com/repo/testutil": "testutil",
+			"example.com/repo/fakes":    "fakes",
+			"example.com/repo/cycle/a":  "cycle/a",
+			"example.com/repo/cycle/b":  "cycle/b",
+			"example.com/repo/unused":   "unused",
+			"example.com/repo/mixed":    "mixed",
+		},
+		commands: map[string]bool{
+			"example.com/repo/cmd": true,
+		},
+		importers: map[string][]string{
+			"example.com/repo/lib":      {"example.com/repo/cmd"},
+			"example.com/repo/fakes":    {"example.com/repo/testutil"},
+			"example.com/repo/cycle/a":  {"example.com/repo/cycle/b"},
+			"example.com/repo/cycle/b":  {"example.com/repo/cycle/a"},
+			"example.com/repo/mixed":    {"example.com/repo/testutil", "example.com/repo/lib"},
+			"example.com/repo/testutil": nil,
+		},
+		testImported: map[string]bool{
+			"example.com/repo/lib":      true,
+			"example.com/repo/testutil": true,
+			"example.com/repo/cycle/a":  true,
+		},
+	}
+	want := map[string]bool{
+		"testutil": true,
+		"fakes":    true,
+		"cycle/a":  true,
+		"cycle/b":  true,
+	}
+	if got := ig.testOnly(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ig.testOnly() = %v; want %v", got, want)
+	}
+}
+
+// writeTestOnlyRepo writes a repository with test helper packages into a
+// temporary directory and returns it.
+func writeTestOnlyRepo(t *testing.T) string {
+	repo, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "testonly_test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(%q, %q) failed with %v; want success", os.Getenv("TEST_TMPDIR"), "testonly_test", err)
+	}
+	for p, content := range map[string]string{
+		"fakes/fakes.go":       "package fakes\n",
+		"testutil/testutil.go": "package testutil\n",
+		"testutil/BUILD":       "company_go_library(\n    name = \"go_default_library\",\n    testonly = 1,\n)\n",
+		"lib/lib.go":           "package lib\n\nimport _ \"example.com/repo/testutil\"\n",
+		"lib/lib_test.go":      "package lib\n\nimport _ \"example.com/repo/fakes\"\n",
+		"leaf/leaf.go":         "package leaf\n",
+		"leaf/example_test.go": "package leaf_test\n\nimport _ \"example.com/repo/leaf\"\n",
+	} {
+		path := filepath.Join(repo, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repo
+}
+
+func TestGenerateTestOnly(t *testing.T) {
+	for _, spec := range []struct {
+		infer bool
+		want  map[string]string
+	}{
+		{
+			infer: true,
+			want:  map[string]string{"fakes": "1", "leaf": "", "lib": "", "testutil": ""},
+		},
+		{
+			infer: false,
+			want:  map[string]string{"fakes": "", "leaf": "", "lib": "", "testutil": "1"},
+		},
+	} {
+		repo := writeTestOnlyRepo(t)
+		defer os.RemoveAll(repo)
+		g, err := New(repo, "example.com/repo")
+		if err != nil {
+			t.Fatalf(`New(%q, "example.com/repo") failed with %v; want success`, repo, err)
+		}
+		g.MappedKinds = []MappedKind{{From: "go_library", To: "company_go_library", Load: "//tools:go.bzl"}}
+		g.CollectDiagnostics = true
+		g.InferTestOnly = spec.infer
+		files, err := g.Generate(repo)
+		if err != nil {
+			t.Fatalf("g.Generate(%q) failed with %v; want success", repo, err)
+		}
+
+		testOnly := make(map[string]string)
+		for _, f := range files {
+			for _, r := range f.Rules("company_go_library") {
+				testOnly[filepath.ToSlash(filepath.Dir(f.Path))] = r.AttrLiteral("testonly")
+			}
+		}
+		if !reflect.DeepEqual(testOnly, spec.want) {
+			t.Errorf("with InferTestOnly = %v, testonly attributes = %q; want %q", spec.infer, testOnly, spec.want)
+		}
+		diags := g.Diagnostics()
+		if len(diags) != 1 || diags[0].Path != filepath.Join(repo, "lib", "BUILD") {
+			t.Errorf("with InferTestOnly = %v, diagnostics = %v; want one about %s", spec.infer, diags, filepath.Join(repo, "lib", "BUILD"))
+		}
+	}
+}
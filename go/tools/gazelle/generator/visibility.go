@@ -0,0 +1,84 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/diag"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/rules"
+)
+
+// visibilityDirective is a comment in the root BUILD file which sets the
+// visibility of the rules generated in the directories matching a pattern,
+// e.g.
+//
+//	# gazelle:visibility ... //visibility:private
+//	# gazelle:visibility api/... //visibility:public
+//
+// The last directive which matches a directory applies.
+const visibilityDirective = "# gazelle:visibility"
+
+// LoadVisibilityRules returns the rules set with visibilityDirective in the
+// BUILD file in the directory "repo", in the order they appear. It returns a
+// *diag.Error for a malformed directive.
+func LoadVisibilityRules(repo string) ([]rules.VisibilityRule, error) {
+	var vis []rules.VisibilityRule
+	err := scanDirectives(repo, visibilityDirective, func(p string, line int, text string, args []string) error {
+		if len(args) < 2 {
+			return &diag.Error{
+				Path:   p,
+				Line:   line,
+				Column: 1,
+				Rule:   text,
+				Msg:    fmt.Sprintf("visibility takes a pattern and at least 1 label but got %d arguments", len(args)),
+				Fix:    visibilityDirective + " <pattern, e.g. foo/...> <label>...",
+			}
+		}
+		pattern := args[0]
+		if strings.HasPrefix(pattern, "/") || strings.Contains(strings.TrimSuffix(pattern, "..."), "...") {
+			return &diag.Error{
+				Path:   p,
+				Line:   line,
+				Column: 1,
+				Rule:   text,
+				Msg:    fmt.Sprintf("bad pattern %q", pattern),
+				Fix:    `use a directory relative to the repository root, "." for the root, optionally followed by "/...", or "..." for every directory`,
+			}
+		}
+		vis = append(vis, rules.VisibilityRule{Pattern: pattern, Visibility: args[1:]})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return vis, nil
+}
+
+// OwnsVisibility determines if a visibility rule in g.Visibility matches the
+// directory "rel", so that gazelle owns the visibility of the rules it
+// generates there, replacing the visibility of existing rules.
+func (g *Generator) OwnsVisibility(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	for _, v := range g.Visibility {
+		if v.Match(rel) {
+			return true
+		}
+	}
+	return false
+}
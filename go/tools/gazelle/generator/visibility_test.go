@@ -0,0 +1,70 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generator
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/diag"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/rules"
+)
+
+func TestLoadVisibilityRules(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "visibility_test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(%q, %q) failed with %v; want success", os.Getenv("TEST_TMPDIR"), "visibility_test", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "BUILD")
+
+	content := `# gazelle:visibility ... //visibility:private
+# gazelle:visibility api/... //visibility:public
+# gazelle:visibility tools //build:__pkg__ //release:__pkg__
+go_prefix("example.com/repo")
+`
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("ioutil.WriteFile(%q, %q, 0600) failed with %v; want success", path, content, err)
+	}
+	got, err := LoadVisibilityRules(dir)
+	if err != nil {
+		t.Fatalf("LoadVisibilityRules(%q) failed with %v; want success", dir, err)
+	}
+	want := []rules.VisibilityRule{
+		{Pattern: "...", Visibility: []string{"//visibility:private"}},
+		{Pattern: "api/...", Visibility: []string{"//visibility:public"}},
+		{Pattern: "tools", Visibility: []string{"//build:__pkg__", "//release:__pkg__"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadVisibilityRules(%q) = %v; want %v", dir, got, want)
+	}
+
+	for _, content := range []string{
+		"# gazelle:visibility api/...\n",
+		"\n# gazelle:visibility a/.../b //visibility:public\n",
+	} {
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("ioutil.WriteFile(%q, %q, 0600) failed with %v; want success", path, content, err)
+		}
+		_, err = LoadVisibilityRules(dir)
+		if _, ok := err.(*diag.Error); !ok {
+			t.Errorf("LoadVisibilityRules(%q) failed with %#v for %q; want *diag.Error", dir, err, content)
+		}
+	}
+}
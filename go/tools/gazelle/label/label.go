@@ -0,0 +1,164 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package label provides a representation of labels of Bazel targets.
+package label
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// A Label represents a label of a build target in Bazel.
+type Label struct {
+	// Repo is the name of the external repository, without "@". It is empty
+	// for the current repository.
+	Repo string
+	// Pkg is the slash-separated path of the package from the repository
+	// root. It is empty for the root package.
+	Pkg string
+	// Name is the name of the target.
+	Name string
+	// Relative is true for labels of the form ":name", which refer to a
+	// target in the current package. Repo and Pkg are ignored.
+	Relative bool
+}
+
+// New returns the label of the target "name" in the package "pkg" in the
+// repository "repo".
+func New(repo, pkg, name string) Label {
+	return Label{Repo: repo, Pkg: pkg, Name: name}
+}
+
+// Parse parses a label string like "@repo//pkg:name", "//pkg", ":name" or
+// "name". The name of a label without one is the last component of its
+// package, so "//a" and "//a:a" parse into the same Label.
+func Parse(s string) (Label, error) {
+	origStr := s
+
+	var repo string
+	if strings.HasPrefix(s, "@") {
+		i := strings.Index(s, "//")
+		if i < 0 {
+			// "@repo" is a shorthand of "@repo//:repo".
+			repo = s[1:]
+			if err := checkRepo(repo, origStr); err != nil {
+				return Label{}, err
+			}
+			return Label{Repo: repo, Name: repo}, nil
+		}
+		repo, s = s[1:i], s[i:]
+		if err := checkRepo(repo, origStr); err != nil {
+			return Label{}, err
+		}
+	}
+
+	if !strings.HasPrefix(s, "//") {
+		if repo != "" {
+			return Label{}, fmt.Errorf("label parse error: %q: want // after the repository name", origStr)
+		}
+		name := strings.TrimPrefix(s, ":")
+		if err := checkName(name, origStr); err != nil {
+			return Label{}, err
+		}
+		return Label{Name: name, Relative: true}, nil
+	}
+
+	s = s[len("//"):]
+	pkg, name := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		pkg, name = s[:i], s[i+1:]
+		if err := checkName(name, origStr); err != nil {
+			return Label{}, err
+		}
+	}
+	if err := checkPkg(pkg, origStr); err != nil {
+		return Label{}, err
+	}
+	if name == "" {
+		if pkg == "" {
+			return Label{}, fmt.Errorf("label parse error: %q: empty target name", origStr)
+		}
+		name = path.Base(pkg)
+	}
+	return Label{Repo: repo, Pkg: pkg, Name: name}, nil
+}
+
+func checkRepo(repo, origStr string) error {
+	if repo == "" || strings.ContainsAny(repo, "/:") {
+		return fmt.Errorf("label parse error: %q: invalid repository name %q", origStr, repo)
+	}
+	return nil
+}
+
+func checkPkg(pkg, origStr string) error {
+	if strings.HasPrefix(pkg, "/") || strings.HasSuffix(pkg, "/") || strings.Contains(pkg, "//") {
+		return fmt.Errorf("label parse error: %q: invalid package name %q", origStr, pkg)
+	}
+	return nil
+}
+
+func checkName(name, origStr string) error {
+	if name == "" || strings.ContainsAny(name, ":") || strings.HasPrefix(name, "/") {
+		return fmt.Errorf("label parse error: %q: invalid target name %q", origStr, name)
+	}
+	return nil
+}
+
+// String returns the label in its shortest form. The name is omitted if it
+// is the same as the last component of the package.
+func (l Label) String() string {
+	if l.Relative {
+		return fmt.Sprintf(":%s", l.Name)
+	}
+
+	var repo string
+	if l.Repo != "" {
+		repo = fmt.Sprintf("@%s", l.Repo)
+	}
+
+	if path.Base(l.Pkg) == l.Name {
+		return fmt.Sprintf("%s//%s", repo, l.Pkg)
+	}
+	return fmt.Sprintf("%s//%s:%s", repo, l.Pkg, l.Name)
+}
+
+// Abs returns the absolute form of l, interpreting a relative label as a
+// target in the package "pkg" in the repository "repo".
+func (l Label) Abs(repo, pkg string) Label {
+	if !l.Relative {
+		return l
+	}
+	return Label{Repo: repo, Pkg: pkg, Name: l.Name}
+}
+
+// Rel returns the relative form of l if it refers to a target in the
+// package "pkg" in the repository "repo". Otherwise, it returns l as it is.
+func (l Label) Rel(repo, pkg string) Label {
+	if l.Relative || l.Repo != repo || l.Pkg != pkg {
+		return l
+	}
+	return Label{Name: l.Name, Relative: true}
+}
+
+// Equal determines if l and other refer to the same target. Relative labels
+// are only equal to relative labels.
+func (l Label) Equal(other Label) bool {
+	if l.Relative || other.Relative {
+		return l.Relative && other.Relative && l.Name == other.Name
+	}
+	return l == other
+}
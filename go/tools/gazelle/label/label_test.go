@@ -0,0 +1,144 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package label
+
+import (
+	"testing"
+)
+
+func TestLabelString(t *testing.T) {
+	for _, spec := range []struct {
+		l    Label
+		want string
+	}{
+		{
+			l:    Label{Name: "foo"},
+			want: "//:foo",
+		},
+		{
+			l:    Label{Pkg: "foo/bar", Name: "baz"},
+			want: "//foo/bar:baz",
+		},
+		{
+			l:    Label{Pkg: "foo/bar", Name: "bar"},
+			want: "//foo/bar",
+		},
+		{
+			l:    Label{Repo: "com_example_repo", Pkg: "foo/bar", Name: "baz"},
+			want: "@com_example_repo//foo/bar:baz",
+		},
+		{
+			l:    Label{Repo: "com_example_repo", Pkg: "foo/bar", Name: "bar"},
+			want: "@com_example_repo//foo/bar",
+		},
+		{
+			l:    Label{Relative: true, Name: "foo"},
+			want: ":foo",
+		},
+	} {
+		if got, want := spec.l.String(), spec.want; got != want {
+			t.Errorf("%#v.String() = %q; want %q", spec.l, got, want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, spec := range []struct {
+		str  string
+		want Label
+	}{
+		{str: "//:foo", want: Label{Name: "foo"}},
+		{str: "//foo/bar:baz", want: Label{Pkg: "foo/bar", Name: "baz"}},
+		{str: "//foo/bar", want: Label{Pkg: "foo/bar", Name: "bar"}},
+		{str: "@repo//foo/bar:baz", want: Label{Repo: "repo", Pkg: "foo/bar", Name: "baz"}},
+		{str: "@repo//foo/bar", want: Label{Repo: "repo", Pkg: "foo/bar", Name: "bar"}},
+		{str: "@repo//:baz", want: Label{Repo: "repo", Name: "baz"}},
+		{str: "@repo", want: Label{Repo: "repo", Name: "repo"}},
+		{str: ":foo", want: Label{Name: "foo", Relative: true}},
+		{str: "foo.go", want: Label{Name: "foo.go", Relative: true}},
+		{str: "//foo:data/file.txt", want: Label{Pkg: "foo", Name: "data/file.txt"}},
+	} {
+		got, err := Parse(spec.str)
+		if err != nil {
+			t.Errorf("Parse(%q) failed with %v; want success", spec.str, err)
+			continue
+		}
+		if got != spec.want {
+			t.Errorf("Parse(%q) = %#v; want %#v", spec.str, got, spec.want)
+		}
+	}
+
+	for _, str := range []string{
+		"",
+		":",
+		"//",
+		"//foo:",
+		"//foo/:bar",
+		"///foo",
+		"@",
+		"@//foo",
+		"@repo:foo",
+		"//foo:bar:baz",
+	} {
+		if l, err := Parse(str); err == nil {
+			t.Errorf("Parse(%q) = %#v; want failure", str, l)
+		}
+	}
+}
+
+func TestAbsRel(t *testing.T) {
+	rel := Label{Name: "foo", Relative: true}
+	abs := Label{Repo: "repo", Pkg: "a/b", Name: "foo"}
+	if got := rel.Abs("repo", "a/b"); got != abs {
+		t.Errorf("%#v.Abs(%q, %q) = %#v; want %#v", rel, "repo", "a/b", got, abs)
+	}
+	if got := abs.Abs("other", "c"); got != abs {
+		t.Errorf("%#v.Abs(%q, %q) = %#v; want %#v", abs, "other", "c", got, abs)
+	}
+	if got := abs.Rel("repo", "a/b"); got != rel {
+		t.Errorf("%#v.Rel(%q, %q) = %#v; want %#v", abs, "repo", "a/b", got, rel)
+	}
+	if got := abs.Rel("", "a/b"); got != abs {
+		t.Errorf("%#v.Rel(%q, %q) = %#v; want %#v", abs, "", "a/b", got, abs)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	for _, spec := range []struct {
+		a, b string
+		want bool
+	}{
+		{a: "//a", b: "//a:a", want: true},
+		{a: "@r//a/b", b: "@r//a/b:b", want: true},
+		{a: "@r", b: "@r//:r", want: true},
+		{a: ":foo", b: "foo", want: true},
+		{a: "//a", b: "@r//a", want: false},
+		{a: "//a:b", b: "//a:c", want: false},
+		{a: ":a", b: "//:a", want: false},
+	} {
+		a, err := Parse(spec.a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := Parse(spec.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := a.Equal(b); got != spec.want {
+			t.Errorf("Parse(%q).Equal(Parse(%q)) = %v; want %v", spec.a, spec.b, got, spec.want)
+		}
+	}
+}
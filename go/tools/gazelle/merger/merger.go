@@ -17,80 +17,258 @@ limitations under the License.
 package merger
 
 import (
-	"fmt"
 	"io/ioutil"
 	"os"
+	"reflect"
 	"sort"
 	"strings"
 
 	bzl "github.com/bazelbuild/buildifier/core"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/diag"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/label"
 )
 
 const keep = "# keep" // marker in srcs or deps to tell gazelle to preserve.
 
-var (
-	mergeableFields = map[string]bool{
-		"srcs": true,
-		"deps": true,
+// defaultMergeableAttrs are the attributes which gazelle owns in rules of
+// kinds without an entry in Options.MergeableAttrs.
+var defaultMergeableAttrs = []string{"srcs", "deps", "testonly", "asmhdr", "dir_importpath", "go_prefix"}
+
+// Options configure MergeWithExisting.
+type Options struct {
+	// Macros maps the name of a macro to the kind of the rule it wraps.
+	Macros map[string]string
+	// MergeableAttrs maps a rule kind to the attributes which gazelle owns
+	// in rules of the kind. Their values in existing rules are replaced with
+	// the generated ones, except for list items marked with a "# keep"
+	// comment. Other attributes are left as they are. Kinds which are not in
+	// the map own "srcs", "deps", "testonly", "asmhdr", "dir_importpath" and
+	// "go_prefix".
+	MergeableAttrs map[string][]string
+	// MappedKinds maps a rule kind gazelle generates to the kind it emits
+	// instead, as declared with "# gazelle:map_kind". Generated rules of the
+	// mapped kind match existing rules of either kind, and matching rules of
+	// the original kind are changed to the mapped kind.
+	MappedKinds map[string]string
+	// OwnVisibility makes gazelle own "visibility" in rules of kinds without
+	// an entry in MergeableAttrs, as it does in a directory where a
+	// "# gazelle:visibility" directive sets the visibility.
+	OwnVisibility bool
+	// RepairGoPrefix makes MergeWithExisting replace the arguments of a
+	// malformed go_prefix rule with the generated ones. It is meant for
+	// gazelle's fix mode: otherwise the rule is left as it is.
+	RepairGoPrefix bool
+	// Pkg is the slash-separated path of the package of the BUILD file from
+	// the repository root. Relative labels like ":name" in the file are
+	// compared with absolute ones as targets of Pkg.
+	Pkg string
+}
+
+// kindResolver returns a function which resolves a rule kind through
+// o.Macros and then back through o.MappedKinds, so that a kind and the
+// kinds which stand for it resolve into the same one.
+func (o Options) kindResolver() func(string) string {
+	unmapped := make(map[string]string)
+	for from, to := range o.MappedKinds {
+		unmapped[to] = from
 	}
-)
+	return func(kind string) string {
+		if k, ok := o.Macros[kind]; ok {
+			kind = k
+		}
+		if k, ok := unmapped[kind]; ok {
+			kind = k
+		}
+		return kind
+	}
+}
+
+// mergeable determines if "attr" of rules of "kind" is owned by gazelle.
+func (o Options) mergeable(kind, attr string) bool {
+	attrs, ok := o.MergeableAttrs[kind]
+	if !ok {
+		if o.OwnVisibility && attr == "visibility" {
+			return true
+		}
+		attrs = defaultMergeableAttrs
+	}
+	for _, a := range attrs {
+		if a == attr {
+			return true
+		}
+	}
+	return false
+}
 
 // MergeWithExisting looks for an existing BUILD file at file.Path
 // loads it, and attempts to merge elements of newfile into it.
 // returns newfile, nil if FileNotExists
-func MergeWithExisting(newfile *bzl.File) (*bzl.File, error) {
-	b, err := ioutil.ReadFile(newfile.Path)
+// If the existing file cannot be parsed, it returns a *diag.Error.
+//
+// Rules in the existing file are matched against rules in newfile by their
+// kind and name. The kind of an existing rule is resolved through the load
+// statements of the file, so a rule loaded under an alias still matches, and
+// then through opts.Macros and opts.MappedKinds. Statements other than calls
+// are kept as they are.
+//
+// Attributes which gazelle owns are removed from existing rules when the
+// generated rules do not have them, except for list items marked with a
+// "# keep" comment. The visibility of a generated rule is left out when it is
+// the default_visibility declared with package() in the existing file, and
+// so is the one of an existing rule if gazelle owns its visibility.
+func MergeWithExisting(newfile *bzl.File, opts Options) (*bzl.File, error) {
+	return MergeWithFile(newfile, newfile.Path, opts)
+}
+
+// MergeWithFile is like MergeWithExisting, but it merges newfile into the
+// existing BUILD file at "path" instead of file.Path. The merged file keeps
+// "path" as its Path.
+func MergeWithFile(newfile *bzl.File, path string, opts Options) (*bzl.File, error) {
+	b, err := ioutil.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return newfile, nil
 		}
 		return nil, err
 	}
-	f, err := bzl.Parse(newfile.Path, b)
+	f, err := bzl.Parse(path, b)
 	if err != nil {
-		return nil, err
+		return nil, diag.ParseError(path, err)
 	}
 
-	var newStmt []bzl.Expr
+	oldSyms := loadedSymbols(f)
+	newSyms := loadedSymbols(newfile)
+	resolve := opts.kindResolver()
+	def := defaultVisibility(f)
+	var (
+		loads   []*bzl.CallExpr
+		newStmt []bzl.Expr
+	)
 	for _, s := range newfile.Stmt {
 		c, ok := s.(*bzl.CallExpr)
 		if !ok {
-			return nil, fmt.Errorf("got %v expected only CallExpr in %q", s, newfile.Path)
+			if !containsStmt(f, s) {
+				newStmt = append(newStmt, s)
+			}
+			continue
 		}
-		other, err := match(f, c)
-		if err != nil {
-			return nil, err
+		if name(c) == "load" {
+			loads = append(loads, c)
+			continue
 		}
+		other := match(f, c, oldSyms, resolve)
 		if other == nil {
+			useLoadedName(c, oldSyms, newSyms)
+			omitDefaultVisibility(c, def)
 			newStmt = append(newStmt, c)
 			continue
 		}
-		if name(c) == "load" {
-			mergeLoad(c, other, f)
-		} else {
-			merge(c, other)
+		if kind := oldSyms.kind(name(other)); kind != name(c) && opts.MappedKinds[kind] == name(c) {
+			useLoadedName(c, oldSyms, newSyms)
+			other.X = c.X
+		}
+		omitDefaultVisibility(c, def)
+		merge(c, other, opts)
+		if opts.mergeable(name(c), "visibility") {
+			omitDefaultVisibility(other, def)
 		}
 	}
 	f.Stmt = append(f.Stmt, newStmt...)
+	for _, l := range loads {
+		mergeLoad(l, f)
+	}
 	return f, nil
 }
 
+// useLoadedName renames the kind of "c" to the name under which the existing
+// file loads it, if the file loads it under another name.
+func useLoadedName(c *bzl.CallExpr, oldSyms, newSyms symbolTable) {
+	if sym, ok := newSyms[name(c)]; ok {
+		if local := oldSyms.localName(sym); local != "" {
+			c.X = &bzl.LiteralExpr{Token: local}
+		}
+	}
+}
+
 // merge takes new info from src and merges into dest.
 // pre: these calls are the same X and 'name'
-func merge(src, dest *bzl.CallExpr) {
+func merge(src, dest *bzl.CallExpr, opts Options) {
+	if name(dest) == "go_prefix" {
+		if opts.RepairGoPrefix {
+			repairGoPrefix(src, dest)
+		}
+		return
+	}
 	destRule := &bzl.Rule{dest}
 	srcRule := &bzl.Rule{src}
 	for _, k := range srcRule.AttrKeys() {
-		if !mergeableFields[k] {
+		if !opts.mergeable(name(src), k) {
 			continue
 		}
-		keepIfRequested(srcRule.Attr(k), destRule.Attr(k))
+		keepIfRequested(srcRule.Attr(k), destRule.Attr(k), opts.Pkg)
 		destRule.SetAttr(k, srcRule.Attr(k))
 	}
+	removeMissing(srcRule, destRule, opts)
+}
+
+// removeMissing removes the attributes of dest which gazelle owns but src
+// does not have, e.g. deps once a package imports nothing, or testonly once
+// non-test code imports the library. List items marked with a "# keep"
+// comment are kept.
+func removeMissing(src, dest *bzl.Rule, opts Options) {
+	for _, k := range dest.AttrKeys() {
+		if k == "name" || src.Attr(k) != nil {
+			continue
+		}
+		if !opts.mergeable(src.Kind(), k) {
+			continue
+		}
+		kept := &bzl.ListExpr{}
+		keepIfRequested(kept, dest.Attr(k), opts.Pkg)
+		if len(kept.List) > 0 {
+			dest.SetAttr(k, kept)
+		} else {
+			dest.DelAttr(k)
+		}
+	}
+}
+
+// defaultVisibility returns the default_visibility declared with package()
+// in "f", or nil.
+func defaultVisibility(f *bzl.File) []string {
+	for _, r := range f.Rules("package") {
+		if vis := r.AttrStrings("default_visibility"); vis != nil {
+			return vis
+		}
+	}
+	return nil
 }
 
-// keepIfRequested takes two ListExpr and looks for any '# keep' suffixes in discard to preserve
-func keepIfRequested(replace, discard bzl.Expr) {
+// omitDefaultVisibility removes the visibility attribute of "c" if it is
+// "def", the default_visibility of the file.
+func omitDefaultVisibility(c *bzl.CallExpr, def []string) {
+	r := &bzl.Rule{c}
+	if vis := r.AttrStrings("visibility"); def != nil && vis != nil && reflect.DeepEqual(vis, def) {
+		r.DelAttr("visibility")
+	}
+}
+
+// repairGoPrefix replaces the arguments of a malformed go_prefix rule dest
+// with the ones in src. A well-formed go_prefix is left as it is.
+func repairGoPrefix(src, dest *bzl.CallExpr) {
+	if len(dest.List) == 1 {
+		if _, ok := dest.List[0].(*bzl.StringExpr); ok {
+			return
+		}
+	}
+	dest.List = src.List
+}
+
+// keepIfRequested takes two ListExpr and looks for any '# keep' suffixes in discard to preserve.
+// A kept value which is also in replace takes the place of the value in
+// replace, so that it is not listed twice. Labels are compared as targets of
+// the package "pkg".
+func keepIfRequested(replace, discard bzl.Expr, pkg string) {
 	r, ok := replace.(*bzl.ListExpr)
 	if !ok {
 		return
@@ -104,21 +282,89 @@ func keepIfRequested(replace, discard bzl.Expr) {
 		if len(c.Suffix) == 0 {
 			continue
 		}
-		if strings.HasPrefix(c.Suffix[0].Token, keep) {
+		if !strings.HasPrefix(c.Suffix[0].Token, keep) {
+			continue
+		}
+		if i := indexValue(r.List, v, pkg); i >= 0 {
+			r.List[i] = v
+		} else {
 			r.List = append(r.List, v)
 		}
 	}
 }
 
-func mergeLoad(src, dest *bzl.CallExpr, oldfile *bzl.File) {
+// indexValue returns the index of the first expression in "list" which has
+// the same value as "v", or -1. Strings are compared as labels of targets
+// of the package "pkg", so "//a" and "//a:a" are the same, and so are ":x"
+// and "//pkg:x".
+func indexValue(list []bzl.Expr, v bzl.Expr, pkg string) int {
+	s, ok := v.(*bzl.StringExpr)
+	if !ok {
+		return -1
+	}
+	l, lerr := label.Parse(s.Value)
+	l = l.Abs("", pkg)
+	for i, e := range list {
+		other, ok := e.(*bzl.StringExpr)
+		if !ok {
+			continue
+		}
+		if other.Value == s.Value {
+			return i
+		}
+		if lerr != nil {
+			continue
+		}
+		if ol, err := label.Parse(other.Value); err == nil && ol.Abs("", pkg).Equal(l) {
+			return i
+		}
+	}
+	return -1
+}
+
+// mergeLoad merges the symbols loaded by src into the load statements in f
+// which load the same file. If f loads the file more than once, the
+// statements are combined into the first one. Symbols are kept only if a rule
+// in f still uses them, and a file none of whose symbols are used is not
+// loaded.
+func mergeLoad(src *bzl.CallExpr, f *bzl.File) {
+	if len(src.List) == 0 {
+		return
+	}
+	file := stringValue(src.List[0])
+	var dests []*bzl.CallExpr
+	for _, s := range f.Stmt {
+		if c, ok := s.(*bzl.CallExpr); ok && isLoadOf(c, file) {
+			dests = append(dests, c)
+		}
+	}
+
 	vals := make(map[string]bzl.Expr)
 	for _, v := range src.List[1:] {
-		vals[stringValue(v)] = v
+		if local, _ := loadArg(v); ruleUsed(local, f) {
+			vals[local] = v
+		}
+	}
+	if len(dests) == 0 {
+		if len(vals) == 0 {
+			return
+		}
+		load := *src
+		load.List = src.List[:1:1]
+		for _, v := range src.List[1:] {
+			if local, _ := loadArg(v); vals[local] != nil {
+				load.List = append(load.List, v)
+			}
+		}
+		insertLoad(f, &load)
+		return
 	}
-	for _, v := range dest.List[1:] {
-		rule := stringValue(v)
-		if _, ok := vals[rule]; !ok && ruleUsed(rule, oldfile) {
-			vals[rule] = v
+	for _, d := range dests {
+		for _, v := range d.List[1:] {
+			local, _ := loadArg(v)
+			if _, ok := vals[local]; !ok && ruleUsed(local, f) {
+				vals[local] = v
+			}
 		}
 	}
 	keys := make([]string, 0, len(vals))
@@ -126,60 +372,148 @@ func mergeLoad(src, dest *bzl.CallExpr, oldfile *bzl.File) {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
+
+	dest := dests[0]
 	dest.List = dest.List[:1]
 	for _, k := range keys {
 		dest.List = append(dest.List, vals[k])
 	}
+	remove := make(map[bzl.Expr]bool)
+	for _, d := range dests[1:] {
+		remove[d] = true
+	}
+	if len(keys) == 0 {
+		remove[dest] = true
+	}
+	if len(remove) == 0 {
+		return
+	}
+	var stmt []bzl.Expr
+	for _, s := range f.Stmt {
+		if !remove[s] {
+			stmt = append(stmt, s)
+		}
+	}
+	f.Stmt = stmt
+}
+
+// insertLoad inserts a load statement into f after any leading comments and
+// existing load statements.
+func insertLoad(f *bzl.File, load *bzl.CallExpr) {
+	i := 0
+	for ; i < len(f.Stmt); i++ {
+		if _, ok := f.Stmt[i].(*bzl.CommentBlock); ok {
+			continue
+		}
+		if c, ok := f.Stmt[i].(*bzl.CallExpr); ok && name(c) == "load" {
+			continue
+		}
+		break
+	}
+	stmt := append([]bzl.Expr{}, f.Stmt[:i]...)
+	stmt = append(stmt, load)
+	f.Stmt = append(stmt, f.Stmt[i:]...)
 }
 
 func ruleUsed(rule string, oldfile *bzl.File) bool {
 	return len(oldfile.Rules(rule)) != 0
 }
 
-// match looks for the matching CallExpr in f using X and name
-// i.e. two 'go_library(name = "foo", ...)' are considered matches
-// despite the values of the other fields.
-// exception: if c is a 'load' statement, the match is done on the first value.
-func match(f *bzl.File, c *bzl.CallExpr) (*bzl.CallExpr, error) {
-	var m matcher
-	if x := name(c); x == "load" {
-		if len(c.List) == 0 {
-			return nil, nil
+// containsStmt reports whether f has a top-level statement which is
+// formatted identically to s.
+func containsStmt(f *bzl.File, s bzl.Expr) bool {
+	want := bzl.FormatString(s)
+	for _, other := range f.Stmt {
+		if bzl.FormatString(other) == want {
+			return true
 		}
-		m = &loadMatcher{stringValue(c.List[0])}
-	} else {
-		m = &nameMatcher{x, (&bzl.Rule{c}).AttrString("name")}
 	}
+	return false
+}
+
+// A symbol is a symbol defined in a Skylark file.
+type symbol struct {
+	file, name string
+}
+
+// A symbolTable maps names bound by load statements in a BUILD file to the
+// symbols they refer to.
+type symbolTable map[string]symbol
+
+func loadedSymbols(f *bzl.File) symbolTable {
+	t := make(symbolTable)
 	for _, s := range f.Stmt {
-		other, ok := s.(*bzl.CallExpr)
-		if !ok {
+		c, ok := s.(*bzl.CallExpr)
+		if !ok || name(c) != "load" || len(c.List) == 0 {
 			continue
 		}
-		if m.match(other) {
-			return other, nil
+		file := stringValue(c.List[0])
+		for _, v := range c.List[1:] {
+			if local, orig := loadArg(v); local != "" {
+				t[local] = symbol{file: file, name: orig}
+			}
 		}
 	}
-	return nil, nil
+	return t
 }
 
-type matcher interface {
-	match(c *bzl.CallExpr) bool
+// kind returns the name of the symbol which the local name "local" refers
+// to, or "local" itself if it was not bound by an aliased load.
+func (t symbolTable) kind(local string) string {
+	if sym, ok := t[local]; ok {
+		return sym.name
+	}
+	return local
 }
 
-type nameMatcher struct {
-	x, name string
+// localName returns the name under which sym is loaded, or "" if it is not
+// loaded.
+func (t symbolTable) localName(sym symbol) string {
+	for local, s := range t {
+		if s == sym {
+			return local
+		}
+	}
+	return ""
 }
 
-func (m *nameMatcher) match(c *bzl.CallExpr) bool {
-	return m.x == name(c) && m.name == (&bzl.Rule{c}).AttrString("name")
+// loadArg returns the local and the original names of a symbol argument of a
+// load statement, i.e. "go_library" or my_lib = "go_library".
+func loadArg(e bzl.Expr) (local, orig string) {
+	switch e := e.(type) {
+	case *bzl.StringExpr:
+		return e.Value, e.Value
+	case *bzl.BinaryExpr:
+		l, ok := e.X.(*bzl.LiteralExpr)
+		if !ok || e.Op != "=" {
+			return "", ""
+		}
+		return l.Token, stringValue(e.Y)
+	}
+	return "", ""
 }
 
-type loadMatcher struct {
-	load string
+func isLoadOf(c *bzl.CallExpr, file string) bool {
+	return name(c) == "load" && len(c.List) > 0 && stringValue(c.List[0]) == file
 }
 
-func (m *loadMatcher) match(c *bzl.CallExpr) bool {
-	return name(c) == "load" && len(c.List) > 0 && m.load == stringValue(c.List[0])
+// match looks for the matching CallExpr in f using X and name
+// i.e. two 'go_library(name = "foo", ...)' are considered matches
+// despite the values of the other fields.
+// The kinds of the calls in f are resolved through "syms" and then "resolve",
+// and the kind of c through "resolve".
+func match(f *bzl.File, c *bzl.CallExpr, syms symbolTable, resolve func(string) string) *bzl.CallExpr {
+	x, n := resolve(name(c)), (&bzl.Rule{c}).AttrString("name")
+	for _, s := range f.Stmt {
+		other, ok := s.(*bzl.CallExpr)
+		if !ok {
+			continue
+		}
+		if resolve(syms.kind(name(other))) == x && (&bzl.Rule{other}).AttrString("name") == n {
+			return other
+		}
+	}
+	return nil
 }
 
 func name(c *bzl.CallExpr) string {
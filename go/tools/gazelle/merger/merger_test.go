@@ -4,9 +4,12 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
 
 	bzl "github.com/bazelbuild/buildifier/core"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/diag"
 )
 
 const oldData = `
@@ -77,27 +80,596 @@ go_test(
 )
 `
 
-func TestMergeWithExisting(t *testing.T) {
+const aliasOldData = `
+load("@io_bazel_rules_go//go:def.bzl", my_lib = "go_library")
+
+my_lib(
+    name = "go_default_library",
+    srcs = ["old.go"],
+)
+`
+
+const aliasNewData = `
+load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["new.go"],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["new_test.go"],
+    library = ":go_default_library",
+)
+`
+
+// should fix
+// * the aliased rule matched and updated
+// * the alias kept in the load stmt, unused go_library dropped
+const aliasExpected = `load("@io_bazel_rules_go//go:def.bzl", "go_test", my_lib = "go_library")
+
+my_lib(
+    name = "go_default_library",
+    srcs = ["new.go"],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["new_test.go"],
+    library = ":go_default_library",
+)
+`
+
+const macroOldData = `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+load("@io_bazel_rules_go//go:def.bzl", "go_test")
+load("//tools:go.bzl", "company_go_library")
+
+PROTOS = glob(["*.proto"])
+
+company_go_library(
+    name = "go_default_library",
+    srcs = ["old.go"],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["old_test.go"],
+    library = ":go_default_library",
+)
+`
+
+// should fix
+// * the macro matched as a go_library and updated
+// * duplicate load stmts combined
+// * the assignment preserved
+const macroExpected = `load("@io_bazel_rules_go//go:def.bzl", "go_test")
+load("//tools:go.bzl", "company_go_library")
+
+PROTOS = glob(["*.proto"])
+
+company_go_library(
+    name = "go_default_library",
+    srcs = ["new.go"],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["new_test.go"],
+    library = ":go_default_library",
+)
+`
+
+const badPrefixOldData = `load("@io_bazel_rules_go//go:def.bzl", "go_prefix")
+
+go_prefix("example.com/repo", "extra")
+`
+
+const prefixNewData = `
+load("@io_bazel_rules_go//go:def.bzl", "go_prefix")
+
+go_prefix("example.com/repo")
+`
+
+// should fix
+// * the malformed go_prefix repaired
+const badPrefixExpected = `load("@io_bazel_rules_go//go:def.bzl", "go_prefix")
+
+go_prefix("example.com/repo")
+`
+
+const policyOldData = `load("//tools:proto.bzl", "company_proto_library")
+
+company_proto_library(
+    name = "api_proto",
+    srcs = ["old.proto"],
+    visibility = ["//visibility:private"],
+    deps = ["//manual:dep"],
+)
+`
+
+const policyNewData = `
+load("//tools:proto.bzl", "company_proto_library")
+
+company_proto_library(
+    name = "api_proto",
+    srcs = ["new.proto"],
+    visibility = ["//visibility:public"],
+    deps = ["//generated:dep"],
+)
+`
+
+// should fix
+// * only srcs, the attribute owned by the policy of the kind, updated
+const policyExpected = `load("//tools:proto.bzl", "company_proto_library")
+
+company_proto_library(
+    name = "api_proto",
+    srcs = ["new.proto"],
+    visibility = ["//visibility:private"],
+    deps = ["//manual:dep"],
+)
+`
+
+const mappedOldData = `load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+load("//tools:go.bzl", "company_go_test")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["old.go"],
+)
+
+company_go_test(
+    name = "go_default_test",
+    srcs = ["old_test.go"],
+    library = ":go_default_library",
+)
+`
+
+const mappedNewData = `
+load("//tools:go.bzl", "company_go_library", "company_go_test")
+
+company_go_library(
+    name = "go_default_library",
+    srcs = ["new.go"],
+)
+
+company_go_test(
+    name = "go_default_test",
+    srcs = ["new_test.go"],
+    library = ":go_default_library",
+)
+`
+
+// should fix
+// * the go_library changed to the mapped kind and updated
+// * the rule of the mapped kind updated, not duplicated
+const mappedExpected = `load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+load("//tools:go.bzl", "company_go_library", "company_go_test")
+
+company_go_library(
+    name = "go_default_library",
+    srcs = ["new.go"],
+)
+
+company_go_test(
+    name = "go_default_test",
+    srcs = ["new_test.go"],
+    library = ":go_default_library",
+)
+`
+
+const visibilityOldData = `load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["old.go"],
+    visibility = ["//visibility:public"],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["old_test.go"],
+    library = ":go_default_library",
+    visibility = ["//visibility:private"],
+)
+`
+
+const visibilityNewData = `
+load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["new.go"],
+    visibility = ["//api:__subpackages__"],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["new_test.go"],
+    library = ":go_default_library",
+)
+`
+
+// should fix
+// * the visibility of the library replaced with the generated one
+// * the visibility of the test removed, as the generated test has none
+const visibilityOwnedExpected = `load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["new.go"],
+    visibility = ["//api:__subpackages__"],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["new_test.go"],
+    library = ":go_default_library",
+)
+`
+
+// should fix
+// * only srcs updated, the visibility of the library kept
+const visibilityKeptExpected = `load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["new.go"],
+    visibility = ["//visibility:public"],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["new_test.go"],
+    library = ":go_default_library",
+    visibility = ["//visibility:private"],
+)
+`
+
+const defaultVisibilityOldData = `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+package(default_visibility = ["//visibility:public"])
+
+go_library(
+    name = "go_default_library",
+    srcs = ["old.go"],
+    visibility = ["//visibility:public"],
+)
+`
+
+const defaultVisibilityNewData = `
+load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["new.go"],
+    visibility = ["//visibility:public"],
+)
+
+go_library(
+    name = "other",
+    srcs = ["other.go"],
+    visibility = ["//visibility:public"],
+)
+`
+
+// should fix
+// * the visibility equal to default_visibility left out of the new library
+// * the visibility of the existing library kept, as gazelle does not own it
+const defaultVisibilityKeptExpected = `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+package(default_visibility = ["//visibility:public"])
+
+go_library(
+    name = "go_default_library",
+    srcs = ["new.go"],
+    visibility = ["//visibility:public"],
+)
+
+go_library(
+    name = "other",
+    srcs = ["other.go"],
+)
+`
+
+// should fix
+// * the visibility equal to default_visibility removed from both libraries,
+//   as gazelle owns it
+const defaultVisibilityOwnedExpected = `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+package(default_visibility = ["//visibility:public"])
+
+go_library(
+    name = "go_default_library",
+    srcs = ["new.go"],
+)
+
+go_library(
+    name = "other",
+    srcs = ["other.go"],
+)
+`
+
+const removeOldData = `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = [
+        "old.go",
+        "asm.s",
+    ],
+    asmhdr = 1,
+    copts = ["-DFOO"],
+    deps = [
+        "//gone:go_default_library",
+        "//manual:go_default_library",  # keep
+    ],
+)
+`
+
+const removeNewData = `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["new.go"],
+)
+`
+
+// should fix
+// * asmhdr removed, as the generated library has none
+// * deps removed, except for the kept one
+// * copts kept, as gazelle does not own it
+const removeExpected = `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["new.go"],
+    copts = ["-DFOO"],
+    deps = ["//manual:go_default_library"],  # keep
+)
+`
+
+const testOnlyOldData = `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    testonly = 1,
+    srcs = ["fakes.go"],
+)
+`
+
+const testOnlyMappedOldData = `
+load("//tools:go.bzl", "company_go_library")
+
+company_go_library(
+    name = "go_default_library",
+    testonly = 1,
+    srcs = ["fakes.go"],
+)
+`
+
+const testOnlyNewData = `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["fakes.go"],
+)
+`
+
+const testOnlyMappedNewData = `
+load("//tools:go.bzl", "company_go_library")
+
+company_go_library(
+    name = "go_default_library",
+    srcs = ["fakes.go"],
+)
+`
+
+// should fix
+// * testonly removed once the library is imported by non-test code
+const testOnlyExpected = `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["fakes.go"],
+)
+`
+
+const testOnlyMappedExpected = `load("//tools:go.bzl", "company_go_library")
+
+company_go_library(
+    name = "go_default_library",
+    srcs = ["fakes.go"],
+)
+`
+
+func writeTemp(t *testing.T, data string) string {
 	tmp, err := ioutil.TempFile(os.Getenv("TEST_TMPDIR"), "")
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer os.Remove(tmp.Name())
-	if _, err := io.WriteString(tmp, oldData); err != nil {
+	if _, err := io.WriteString(tmp, data); err != nil {
 		t.Fatal(err)
 	}
 	if err := tmp.Close(); err != nil {
 		t.Fatal(err)
 	}
-	newF, err := bzl.Parse(tmp.Name(), []byte(newData))
+	return tmp.Name()
+}
+
+func mergeData(t *testing.T, oldData, newData string, opts Options) string {
+	path := writeTemp(t, oldData)
+	defer os.Remove(path)
+	newF, err := bzl.Parse(path, []byte(newData))
 	if err != nil {
 		t.Fatal(err)
 	}
-	afterF, err := MergeWithExisting(newF)
+	afterF, err := MergeWithExisting(newF, opts)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if s := string(bzl.Format(afterF)); s != expected {
+	return string(bzl.Format(afterF))
+}
+
+func TestMergeWithExisting(t *testing.T) {
+	if s := mergeData(t, oldData, newData, Options{}); s != expected {
 		t.Errorf("bzl.Format, want %s; got %s", expected, s)
 	}
 }
+
+func TestMergeWithExistingAlias(t *testing.T) {
+	if s := mergeData(t, aliasOldData, aliasNewData, Options{}); s != aliasExpected {
+		t.Errorf("bzl.Format, want %s; got %s", aliasExpected, s)
+	}
+}
+
+func TestMergeWithExistingMacro(t *testing.T) {
+	opts := Options{Macros: map[string]string{"company_go_library": "go_library"}}
+	if s := mergeData(t, macroOldData, aliasNewData, opts); s != macroExpected {
+		t.Errorf("bzl.Format, want %s; got %s", macroExpected, s)
+	}
+}
+
+func TestMergeWithExistingRepairsGoPrefix(t *testing.T) {
+	if s := mergeData(t, badPrefixOldData, prefixNewData, Options{RepairGoPrefix: true}); s != badPrefixExpected {
+		t.Errorf("bzl.Format, want %s; got %s", badPrefixExpected, s)
+	}
+	if s := mergeData(t, badPrefixOldData, prefixNewData, Options{}); s != badPrefixOldData {
+		t.Errorf("bzl.Format, want %s; got %s", badPrefixOldData, s)
+	}
+}
+
+func TestMergeWithExistingPolicy(t *testing.T) {
+	opts := Options{MergeableAttrs: map[string][]string{"company_proto_library": {"srcs"}}}
+	if s := mergeData(t, policyOldData, policyNewData, opts); s != policyExpected {
+		t.Errorf("bzl.Format, want %s; got %s", policyExpected, s)
+	}
+}
+
+func TestMergeWithExistingVisibility(t *testing.T) {
+	opts := Options{OwnVisibility: true}
+	if s := mergeData(t, visibilityOldData, visibilityNewData, opts); s != visibilityOwnedExpected {
+		t.Errorf("bzl.Format, want %s; got %s", visibilityOwnedExpected, s)
+	}
+	if s := mergeData(t, visibilityOldData, visibilityNewData, Options{}); s != visibilityKeptExpected {
+		t.Errorf("bzl.Format, want %s; got %s", visibilityKeptExpected, s)
+	}
+}
+
+func TestMergeWithExistingDefaultVisibility(t *testing.T) {
+	if s := mergeData(t, defaultVisibilityOldData, defaultVisibilityNewData, Options{}); s != defaultVisibilityKeptExpected {
+		t.Errorf("bzl.Format, want %s; got %s", defaultVisibilityKeptExpected, s)
+	}
+	opts := Options{OwnVisibility: true}
+	if s := mergeData(t, defaultVisibilityOldData, defaultVisibilityNewData, opts); s != defaultVisibilityOwnedExpected {
+		t.Errorf("bzl.Format, want %s; got %s", defaultVisibilityOwnedExpected, s)
+	}
+}
+
+func TestMergeWithExistingRemovesMissingAttrs(t *testing.T) {
+	if s := mergeData(t, removeOldData, removeNewData, Options{}); s != removeExpected {
+		t.Errorf("bzl.Format, want %s; got %s", removeExpected, s)
+	}
+	// Attributes of kinds with their own mergeable attributes are removed
+	// only if they are listed.
+	opts := Options{MergeableAttrs: map[string][]string{"go_library": {"srcs"}}}
+	want := strings.Replace(removeOldData, `srcs = [
+        "old.go",
+        "asm.s",
+    ]`, `srcs = ["new.go"]`, 1)
+	if s := mergeData(t, removeOldData, removeNewData, opts); s != want {
+		t.Errorf("bzl.Format, want %s; got %s", want, s)
+	}
+}
+
+func TestKeepIfRequested(t *testing.T) {
+	str := func(value string, keep bool) bzl.Expr {
+		e := &bzl.StringExpr{Value: value}
+		if keep {
+			e.Comment().Suffix = []bzl.Comment{{Token: "# keep"}}
+		}
+		return e
+	}
+	replace := &bzl.ListExpr{List: []bzl.Expr{
+		str("//a:a", false),
+		str("//b", false),
+		str(":e", false),
+	}}
+	discard := &bzl.ListExpr{List: []bzl.Expr{
+		str("//a", true),
+		str("//c", true),
+		str("//d", false),
+		str("//pkg:e", true),
+	}}
+	keepIfRequested(replace, discard, "pkg")
+
+	var got []string
+	for _, e := range replace.List {
+		got = append(got, e.(*bzl.StringExpr).Value)
+	}
+	if want := []string{"//a", "//b", "//pkg:e", "//c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("keepIfRequested: got %q; want %q", got, want)
+	}
+}
+
+func TestMergeWithExistingMappedKinds(t *testing.T) {
+	opts := Options{MappedKinds: map[string]string{
+		"go_library": "company_go_library",
+		"go_test":    "company_go_test",
+	}}
+	if s := mergeData(t, mappedOldData, mappedNewData, opts); s != mappedExpected {
+		t.Errorf("bzl.Format, want %s; got %s", mappedExpected, s)
+	}
+}
+
+func TestMergeWithExistingRemovesTestOnly(t *testing.T) {
+	if s := mergeData(t, testOnlyOldData, testOnlyNewData, Options{}); s != testOnlyExpected {
+		t.Errorf("bzl.Format, want %s; got %s", testOnlyExpected, s)
+	}
+	opts := Options{MappedKinds: map[string]string{"go_library": "company_go_library"}}
+	if s := mergeData(t, testOnlyMappedOldData, testOnlyMappedNewData, opts); s != testOnlyMappedExpected {
+		t.Errorf("bzl.Format, want %s; got %s", testOnlyMappedExpected, s)
+	}
+	// The generated load of go_library is not added, since the merged rule
+	// keeps its mapped kind.
+	if s := mergeData(t, testOnlyMappedOldData, testOnlyNewData, opts); s != testOnlyMappedExpected {
+		t.Errorf("bzl.Format, want %s; got %s", testOnlyMappedExpected, s)
+	}
+}
+
+func TestMergeWithExistingParseError(t *testing.T) {
+	path := writeTemp(t, "go_library(\n    name = ,\n)\n")
+	defer os.Remove(path)
+	newF, err := bzl.Parse(path, []byte(prefixNewData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = MergeWithExisting(newF, Options{})
+	e, ok := err.(*diag.Error)
+	if !ok {
+		t.Fatalf("MergeWithExisting(%q) failed with %v; want *diag.Error", path, err)
+	}
+	if got, want := e.Line, 2; got != want {
+		t.Errorf("e.Line = %d; want %d", got, want)
+	}
+}
+
+func TestMergeWithFile(t *testing.T) {
+	path := writeTemp(t, oldData)
+	defer os.Remove(path)
+	newF, err := bzl.Parse("out/BUILD", []byte(newData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	afterF, err := MergeWithFile(newF, path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(bzl.Format(afterF)); s != expected {
+		t.Errorf("MergeWithFile(%q) = %s; want %s", path, s, expected)
+	}
+}
@@ -0,0 +1,232 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package migrate rewrites the existing BUILD files in a repository to move
+// it from one convention gazelle supports to another.
+package migrate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	bzl "github.com/bazelbuild/buildifier/core"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/diag"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/label"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/rules"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/wspace"
+)
+
+// NamingOptions configure Naming.
+type NamingOptions struct {
+	// GoPrefix is the go_prefix of the repository. The library in the root
+	// package is named after its last component.
+	GoPrefix string
+	// Aliases makes Naming leave an alias named "go_default_library" next to
+	// each renamed library, so that references from other repositories keep
+	// working.
+	Aliases bool
+}
+
+// Naming renames the libraries and tests in the BUILD files under
+// "repoRoot" from the names of rules.DefaultNaming to the ones of
+// rules.DirNaming, and rewrites the labels in the repository which refer to
+// them. It returns the modified files without writing them. Their paths are
+// under "repoRoot".
+//
+// A target is not renamed if its new name is taken by another target in the
+// same package.
+func Naming(repoRoot string, opts NamingOptions) ([]*bzl.File, error) {
+	files, err := loadFiles(repoRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	renames := make(map[label.Label]string)
+	modified := make(map[*bzl.File]bool)
+	for _, f := range files {
+		if renameTargets(f, opts, renames) {
+			modified[f.File] = true
+		}
+	}
+	for _, f := range files {
+		if rewriteLabels(f, renames) {
+			modified[f.File] = true
+		}
+	}
+
+	var result []*bzl.File
+	for _, f := range files {
+		if modified[f.File] {
+			result = append(result, f.File)
+		}
+	}
+	return result, nil
+}
+
+// A buildFile is a BUILD file and the package it defines.
+type buildFile struct {
+	*bzl.File
+	// pkg is the slash-separated path of the package from the repository
+	// root.
+	pkg string
+}
+
+// loadFiles parses the BUILD files in the repository at "repoRoot". Nested
+// workspaces, testdata directories and directories whose names start with
+// "." or "_" are skipped, as gazelle does.
+func loadFiles(repoRoot string) ([]buildFile, error) {
+	var files []buildFile
+	err := filepath.Walk(repoRoot, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if p != repoRoot {
+			if base := info.Name(); base[0] == '.' || base[0] == '_' || base == "testdata" || wspace.IsRoot(p) {
+				return filepath.SkipDir
+			}
+		}
+		path := filepath.Join(p, "BUILD")
+		b, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		f, err := bzl.Parse(path, b)
+		if err != nil {
+			return diag.ParseError(path, err)
+		}
+		rel, err := filepath.Rel(repoRoot, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			rel = ""
+		}
+		files = append(files, buildFile{File: f, pkg: filepath.ToSlash(rel)})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// renameTargets renames the targets in "f" and records their old labels and
+// new names in "renames". It reports whether it modified "f".
+func renameTargets(f buildFile, opts NamingOptions, renames map[label.Label]string) bool {
+	lib := rules.DirNaming.LibName(f.pkg, opts.GoPrefix)
+	newNames := map[string]string{
+		rules.DefaultNaming.LibName(f.pkg, opts.GoPrefix): lib,
+		rules.DefaultNaming.TestName(""):                  rules.DirNaming.TestName(lib),
+		rules.DefaultNaming.XTestName(""):                 rules.DirNaming.XTestName(lib),
+		rules.DefaultNaming.ProtosName(""):                rules.DirNaming.ProtosName(lib),
+	}
+	taken := make(map[string]bool)
+	for _, r := range f.Rules("") {
+		taken[r.Name()] = true
+	}
+
+	var modified, libRenamed bool
+	for _, r := range f.Rules("") {
+		if r.Kind() == "alias" {
+			continue
+		}
+		old := r.Name()
+		name, ok := newNames[old]
+		if !ok {
+			continue
+		}
+		if taken[name] {
+			log.Printf("%s: not renaming %s to %s; the name is taken", f.Path, old, name)
+			continue
+		}
+		r.SetAttr("name", &bzl.StringExpr{Value: name})
+		taken[name] = true
+		renames[label.New("", f.pkg, old)] = name
+		modified = true
+		if old == rules.DefaultNaming.LibName(f.pkg, opts.GoPrefix) {
+			libRenamed = true
+			if r.Kind() == "go_library" {
+				// The renamed library keeps its import path.
+				r.SetAttr("dir_importpath", &bzl.LiteralExpr{Token: "1"})
+			}
+		}
+	}
+
+	if libRenamed && opts.Aliases {
+		f.Stmt = append(f.Stmt, aliasShim(rules.DefaultNaming.LibName(f.pkg, opts.GoPrefix), lib))
+	}
+	return modified
+}
+
+// aliasShim returns an alias rule named "name" which refers to "actual" in
+// the same package.
+func aliasShim(name, actual string) *bzl.CallExpr {
+	kwarg := func(key string, value bzl.Expr) bzl.Expr {
+		return &bzl.BinaryExpr{X: &bzl.LiteralExpr{Token: key}, Op: "=", Y: value}
+	}
+	return &bzl.CallExpr{
+		X: &bzl.LiteralExpr{Token: "alias"},
+		List: []bzl.Expr{
+			kwarg("name", &bzl.StringExpr{Value: name}),
+			kwarg("actual", &bzl.StringExpr{Value: fmt.Sprintf(":%s", actual)}),
+			kwarg("visibility", &bzl.ListExpr{List: []bzl.Expr{
+				&bzl.StringExpr{Value: "//visibility:public"},
+			}}),
+		},
+	}
+}
+
+// rewriteLabels rewrites the labels in the attributes of the rules in "f"
+// which refer to renamed targets. It reports whether it modified "f".
+func rewriteLabels(f buildFile, renames map[label.Label]string) bool {
+	var modified bool
+	for _, r := range f.Rules("") {
+		if r.Kind() == "load" {
+			continue
+		}
+		for _, key := range r.AttrKeys() {
+			if key == "name" {
+				continue
+			}
+			rules.WalkStrings(r.Attr(key), func(s *bzl.StringExpr) {
+				l, err := label.Parse(s.Value)
+				if err != nil || l.Repo != "" {
+					return
+				}
+				abs := l.Abs("", f.pkg)
+				name, ok := renames[abs]
+				if !ok {
+					return
+				}
+				if l.Relative {
+					s.Value = fmt.Sprintf(":%s", name)
+				} else {
+					s.Value = label.New("", abs.Pkg, name).String()
+				}
+				modified = true
+			})
+		}
+	}
+	return modified
+}
@@ -0,0 +1,122 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migrate
+
+import (
+	"reflect"
+	"testing"
+
+	bzl "github.com/bazelbuild/buildifier/core"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/label"
+)
+
+// call returns a call of "kind" with string or string list keyword
+// arguments "kwargs".
+func call(kind string, kwargs map[string]interface{}) *bzl.CallExpr {
+	c := &bzl.CallExpr{X: &bzl.LiteralExpr{Token: kind}}
+	r := &bzl.Rule{Call: c}
+	for _, key := range []string{"name", "srcs", "library", "deps", "actual"} {
+		switch v := kwargs[key].(type) {
+		case string:
+			r.SetAttr(key, &bzl.StringExpr{Value: v})
+		case []string:
+			list := &bzl.ListExpr{}
+			for _, s := range v {
+				list.List = append(list.List, &bzl.StringExpr{Value: s})
+			}
+			r.SetAttr(key, list)
+		}
+	}
+	return c
+}
+
+func describeRules(f buildFile) [][]string {
+	var rs [][]string
+	for _, r := range f.Rules("") {
+		desc := []string{r.Kind(), r.Name()}
+		for _, key := range []string{"library", "actual"} {
+			if v := r.AttrString(key); v != "" {
+				desc = append(desc, key+"="+v)
+			}
+		}
+		if v := r.AttrLiteral("dir_importpath"); v != "" {
+			desc = append(desc, "dir_importpath="+v)
+		}
+		for _, dep := range r.AttrStrings("deps") {
+			desc = append(desc, "dep="+dep)
+		}
+		rs = append(rs, desc)
+	}
+	return rs
+}
+
+func TestNaming(t *testing.T) {
+	root := buildFile{pkg: "", File: &bzl.File{Path: "BUILD", Stmt: []bzl.Expr{
+		call("go_library", map[string]interface{}{"name": "go_default_library"}),
+	}}}
+	lib := buildFile{pkg: "foo/lib", File: &bzl.File{Path: "foo/lib/BUILD", Stmt: []bzl.Expr{
+		call("go_library", map[string]interface{}{"name": "go_default_library", "deps": []string{"//:go_default_library"}}),
+		call("go_test", map[string]interface{}{"name": "go_default_test", "library": ":go_default_library"}),
+		call("go_test", map[string]interface{}{"name": "go_default_xtest", "deps": []string{":go_default_library", "@other//foo:go_default_library"}}),
+	}}}
+	taken := buildFile{pkg: "bin", File: &bzl.File{Path: "bin/BUILD", Stmt: []bzl.Expr{
+		call("go_library", map[string]interface{}{"name": "go_default_library"}),
+		call("go_binary", map[string]interface{}{"name": "bin", "library": ":go_default_library", "deps": []string{"//foo/lib:go_default_library"}}),
+	}}}
+	files := []buildFile{root, lib, taken}
+
+	opts := NamingOptions{GoPrefix: "example.com/repo", Aliases: true}
+	renames := make(map[label.Label]string)
+	for _, f := range files {
+		renameTargets(f, opts, renames)
+	}
+	for _, f := range files {
+		rewriteLabels(f, renames)
+	}
+
+	for _, spec := range []struct {
+		f    buildFile
+		want [][]string
+	}{
+		{
+			f: root,
+			want: [][]string{
+				{"go_library", "repo", "dir_importpath=1"},
+				{"alias", "go_default_library", "actual=:repo"},
+			},
+		},
+		{
+			f: lib,
+			want: [][]string{
+				{"go_library", "lib", "dir_importpath=1", "dep=//:repo"},
+				{"go_test", "lib_test", "library=:lib"},
+				{"go_test", "lib_xtest", "dep=:lib", "dep=@other//foo:go_default_library"},
+				{"alias", "go_default_library", "actual=:lib"},
+			},
+		},
+		{
+			f: taken,
+			want: [][]string{
+				{"go_library", "go_default_library"},
+				{"go_binary", "bin", "library=:go_default_library", "dep=//foo/lib"},
+			},
+		},
+	} {
+		if got := describeRules(spec.f); !reflect.DeepEqual(got, spec.want) {
+			t.Errorf("rules in %s = %q; want %q", spec.f.Path, got, spec.want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package packages
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/diag"
+)
+
+// importMultiple imports the package in "dir", for which ImportDir failed
+// with "merr" because the directory contains files of more than one package.
+// It chooses one of the packages and ignores the files of the others:
+// the package whose files have an import comment, or else the one named after
+// the directory. If neither identifies a single package, it returns "merr".
+// The ignored files are reported to "diagnose", or logged if it is nil.
+func importMultiple(bctx build.Context, dir string, merr *build.MultiplePackageError, diagnose func(*diag.Error)) (*build.Package, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string][]string)
+	commented := make(map[string]bool)
+	fset := token.NewFileSet()
+	for _, fi := range files {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".go") {
+			continue
+		}
+		if ok, err := bctx.MatchFile(dir, fi.Name()); err != nil || !ok {
+			continue
+		}
+		f, err := parser.ParseFile(fset, filepath.Join(dir, fi.Name()), nil, parser.PackageClauseOnly|parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimSuffix(f.Name.Name, "_test")
+		byName[name] = append(byName[name], fi.Name())
+		if importComment(fset, f) != "" {
+			commented[name] = true
+		}
+	}
+
+	var chosen string
+	if len(commented) == 1 {
+		for name := range commented {
+			chosen = name
+		}
+	} else if _, ok := byName[filepath.Base(dir)]; ok && len(commented) == 0 {
+		chosen = filepath.Base(dir)
+	} else {
+		return nil, merr
+	}
+
+	ignored := make(map[string]bool)
+	var ignoredNames []string
+	for name, fs := range byName {
+		if name == chosen {
+			continue
+		}
+		for _, f := range fs {
+			ignored[f] = true
+			ignoredNames = append(ignoredNames, f)
+		}
+	}
+	sort.Strings(ignoredNames)
+
+	bctx.ReadDir = func(d string) ([]os.FileInfo, error) {
+		fis, err := ioutil.ReadDir(d)
+		if err != nil || d != dir {
+			return fis, err
+		}
+		var kept []os.FileInfo
+		for _, fi := range fis {
+			if !ignored[fi.Name()] {
+				kept = append(kept, fi)
+			}
+		}
+		return kept, nil
+	}
+	pkg, err := bctx.ImportDir(dir, build.ImportComment)
+	if err != nil {
+		return nil, err
+	}
+	pkg.IgnoredGoFiles = append(pkg.IgnoredGoFiles, ignoredNames...)
+	e := &diag.Error{
+		Path: filepath.Join(dir, ignoredNames[0]),
+		Msg:  fmt.Sprintf("found files of multiple packages; using package %s and ignoring %s", chosen, strings.Join(ignoredNames, ", ")),
+		Fix:  fmt.Sprintf("move the files of the other packages out of %s, or exclude them with build constraints", dir),
+	}
+	if diagnose == nil {
+		log.Print(e)
+	} else {
+		diagnose(e)
+	}
+	return pkg, nil
+}
+
+// importComment returns the path in the import comment of the package clause
+// of "f", or "" if there is none.
+func importComment(fset *token.FileSet, f *ast.File) string {
+	line := fset.Position(f.Name.End()).Line
+	for _, g := range f.Comments {
+		for _, c := range g.List {
+			if fset.Position(c.Slash).Line != line {
+				continue
+			}
+			text := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(c.Text, "//"), "/*"), "*/"))
+			if !strings.HasPrefix(text, "import ") {
+				continue
+			}
+			if p, err := strconv.Unquote(strings.TrimSpace(strings.TrimPrefix(text, "import "))); err == nil {
+				return p
+			}
+		}
+	}
+	return ""
+}
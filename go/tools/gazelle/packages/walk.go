@@ -16,9 +16,15 @@ limitations under the License.
 package packages
 
 import (
+	"fmt"
 	"go/build"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/diag"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/wspace"
 )
 
 // A WalkFunc is a callback called by Walk for each package.
@@ -30,25 +36,334 @@ type WalkFunc func(pkg *build.Package) error
 // It is similar to "golang.org/x/tools/go/buildutil".ForEachPackage, but
 // it does not assume the standard Go tree because Bazel rules_go uses
 // go_prefix instead of the standard tree.
+//
+// Walk does not descend into subdirectories which contain a WORKSPACE file,
+// since they belong to other Bazel repositories.
 func Walk(bctx build.Context, root string, f WalkFunc) error {
+	return WalkWithOptions(bctx, root, Options{}, f)
+}
+
+// WalkKeepGoing is like Walk, but it does not stop at a directory which
+// cannot be imported or for which "f" fails. It records the error, goes on
+// with the other packages, and finally returns the recorded errors as an
+// ErrorList.
+func WalkKeepGoing(bctx build.Context, root string, f WalkFunc) error {
+	return WalkWithOptions(bctx, root, Options{KeepGoing: true}, f)
+}
+
+// Options configure WalkWithOptions.
+type Options struct {
+	// KeepGoing makes the walk go on as WalkKeepGoing does.
+	KeepGoing bool
+	// FollowSymlinks makes the walk descend into symbolic links to
+	// directories in the tree under RepoRoot. Links to directories outside
+	// of the repository are not followed, since their BUILD files would be
+	// written outside of it. Each real directory is visited once, at its
+	// canonical path in the repository, however many links lead to it, so
+	// links which form a loop are not followed again.
+	FollowSymlinks bool
+	// RepoRoot is the root directory of the repository which contains the
+	// walked directory. If empty, the walked directory is used.
+	RepoRoot string
+	// SkipSubdirs makes the walk visit only the walked directory itself, not
+	// its subdirectories.
+	SkipSubdirs bool
+	// EmptyDirs makes the walk call back for directories without buildable
+	// Go files too, with a package which has no Go files, e.g. so that
+	// plugins can generate rules for other files in them.
+	EmptyDirs bool
+	// OnDiagnostic is called with the problems the walk works around, such
+	// as files of other packages ignored in a directory. If nil, they are
+	// logged.
+	OnDiagnostic func(*diag.Error)
+}
+
+// WalkWithOptions is like Walk, but configured with "opts".
+func WalkWithOptions(bctx build.Context, root string, opts Options, f WalkFunc) error {
+	var (
+		errs    ErrorList
+		onError func(dir string, err error)
+	)
+	if opts.KeepGoing {
+		onError = func(dir string, err error) {
+			errs = append(errs, &Error{Dir: dir, Err: err})
+		}
+	}
+	var err error
+	switch {
+	case opts.SkipSubdirs:
+		err = visitOnly(bctx, root, opts, f, onError)
+	case opts.FollowSymlinks:
+		err = walkFollowingSymlinks(bctx, root, opts, f, onError)
+	default:
+		err = walk(bctx, root, opts, f, onError)
+	}
+	if err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// walk implements WalkWithOptions without following symbolic links. If
+// "onError" is nil, walk stops at the first error. Otherwise it passes errors
+// to "onError" and continues.
+func walk(bctx build.Context, root string, opts Options, f WalkFunc, onError func(dir string, err error)) error {
 	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			return err
+			if onError == nil {
+				return err
+			}
+			onError(path, err)
+			return nil
 		}
 		if !info.IsDir() {
 			return nil
 		}
-		if base := info.Name(); base == "" || base[0] == '.' || base[0] == '_' || base == "testdata" {
-			return filepath.SkipDir
-		}
+		return visit(bctx, root, path, info, opts, f, onError)
+	})
+}
 
-		pkg, err := bctx.ImportDir(path, build.ImportComment)
-		if _, ok := err.(*build.NoGoError); ok {
+// walkFollowingSymlinks implements WalkWithOptions with opts.FollowSymlinks.
+func walkFollowingSymlinks(bctx build.Context, root string, opts Options, f WalkFunc, onError func(dir string, err error)) error {
+	repoRoot := opts.RepoRoot
+	if repoRoot == "" {
+		repoRoot = root
+	}
+	realRepoRoot, err := filepath.EvalSymlinks(repoRoot)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	visited := make(map[string]bool)
+
+	var walkDir func(path string, info os.FileInfo) error
+	walkDir = func(path string, info os.FileInfo) error {
+		real, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			if onError == nil {
+				return err
+			}
+			onError(path, err)
+			return nil
+		}
+		if visited[real] {
 			return nil
 		}
+		visited[real] = true
+
+		if path != root {
+			// Visit the directory at its canonical path, where its BUILD
+			// file goes, whichever link led to it.
+			rel, err := filepath.Rel(realRepoRoot, real)
+			if err != nil {
+				return err
+			}
+			// A link back to the repository root is not followed either.
+			if rel == "." || Skipped(repoRoot, filepath.ToSlash(rel)) {
+				return nil
+			}
+			path = filepath.Join(repoRoot, rel)
+			if info, err = os.Stat(path); err != nil {
+				return err
+			}
+		}
+		if err := visit(bctx, root, path, info, opts, f, onError); err != nil {
+			if err == filepath.SkipDir {
+				return nil
+			}
+			return err
+		}
+		infos, err := ioutil.ReadDir(path)
 		if err != nil {
+			if onError == nil {
+				return err
+			}
+			onError(path, err)
+			return nil
+		}
+		for _, fi := range infos {
+			p := filepath.Join(path, fi.Name())
+			if fi.Mode()&os.ModeSymlink != 0 {
+				target, err := filepath.EvalSymlinks(p)
+				if err != nil || !isDescendingDir(target, realRepoRoot) {
+					continue
+				}
+				if fi, err = os.Stat(p); err != nil {
+					continue
+				}
+			}
+			if !fi.IsDir() {
+				continue
+			}
+			if err := walkDir(p, fi); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walkDir(root, info)
+}
+
+// visitOnly implements WalkWithOptions with opts.SkipSubdirs.
+func visitOnly(bctx build.Context, root string, opts Options, f WalkFunc, onError func(dir string, err error)) error {
+	info, err := os.Stat(root)
+	if err != nil {
+		if onError == nil {
 			return err
 		}
-		return f(pkg)
-	})
+		onError(root, err)
+		return nil
+	}
+	if err := visit(bctx, root, root, info, opts, f, onError); err != filepath.SkipDir {
+		return err
+	}
+	return nil
+}
+
+// visit calls "f" with the package in the directory "path" under "root".
+// It returns filepath.SkipDir if the walk should not descend into the
+// directory.
+func visit(bctx build.Context, root, path string, info os.FileInfo, opts Options, f WalkFunc, onError func(dir string, err error)) error {
+	if isSkippedName(info.Name()) {
+		return filepath.SkipDir
+	}
+	if path != root && wspace.IsRoot(path) {
+		return filepath.SkipDir
+	}
+
+	pkg, err := bctx.ImportDir(path, build.ImportComment)
+	if _, ok := err.(*build.NoGoError); ok {
+		if !opts.EmptyDirs {
+			return nil
+		}
+		pkg, err = &build.Package{Dir: path, ImportPath: pkg.ImportPath}, nil
+	}
+	if merr, ok := err.(*build.MultiplePackageError); ok {
+		pkg, err = importMultiple(bctx, path, merr, opts.OnDiagnostic)
+	}
+	if err == nil {
+		err = f(pkg)
+	}
+	if err != nil && onError != nil {
+		onError(path, err)
+		return nil
+	}
+	return err
+}
+
+// isSkippedName determines if Walk skips a directory named "base", because
+// the go tool ignores it.
+func isSkippedName(base string) bool {
+	return base == "" || base[0] == '.' || base[0] == '_' || base == "testdata"
+}
+
+// Skipped determines if Walk skips the subdirectory "rel" of "root", because
+// it or a directory on the way to it is ignored by the go tool or is the
+// root of a nested workspace. "rel" is slash-separated.
+func Skipped(root, rel string) bool {
+	if rel == "" {
+		return false
+	}
+	path := root
+	for _, base := range strings.Split(rel, "/") {
+		if isSkippedName(base) {
+			return true
+		}
+		path = filepath.Join(path, base)
+		if wspace.IsRoot(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// Symlink returns the symbolic link to a directory on the way from "root"
+// to its subdirectory "rel", if the real directory of the link is in the
+// tree under "root" too. "link" is the slash-separated path of the link
+// relative to "root" and "real" the path of its real directory, or both are
+// empty if there is no such link. Like Walk, it does not look into nested
+// workspaces, and it stats only the directories on the way, so it is cheap
+// enough to call for every label which needs it.
+func Symlink(root, rel string) (link, real string, err error) {
+	if rel == "" {
+		return "", "", nil
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", "", err
+	}
+	path := root
+	for _, base := range strings.Split(rel, "/") {
+		if isSkippedName(base) {
+			return "", "", nil
+		}
+		path = filepath.Join(path, base)
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			return "", "", nil
+		}
+		if err != nil {
+			return "", "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			if !info.IsDir() || wspace.IsRoot(path) {
+				return "", "", nil
+			}
+			continue
+		}
+		target, err := filepath.EvalSymlinks(path)
+		if err != nil || !isDescendingDir(target, realRoot) {
+			return "", "", nil
+		}
+		if fi, err := os.Stat(target); err != nil || !fi.IsDir() {
+			return "", "", nil
+		}
+		linkRel, err := filepath.Rel(root, path)
+		if err != nil {
+			return "", "", err
+		}
+		realRel, err := filepath.Rel(realRoot, target)
+		if err != nil {
+			return "", "", err
+		}
+		if realRel == "." {
+			realRel = ""
+		}
+		return filepath.ToSlash(linkRel), filepath.ToSlash(realRel), nil
+	}
+	return "", "", nil
+}
+
+func isDescendingDir(dir, root string) bool {
+	if dir == root {
+		return true
+	}
+	return strings.HasPrefix(dir, root+string(filepath.Separator))
+}
+
+// An Error is an error which occurred while processing the package in Dir.
+type Error struct {
+	Dir string
+	Err error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %v", e.Dir, e.Err)
+}
+
+// An ErrorList is a list of errors in packages.
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	var msgs []string
+	for _, e := range l {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "\n")
 }
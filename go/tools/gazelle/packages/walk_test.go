@@ -24,6 +24,7 @@ import (
 	"sort"
 	"testing"
 
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/diag"
 	"github.com/bazelbuild/rules_go/go/tools/gazelle/packages"
 )
 
@@ -106,3 +107,290 @@ func TestWalkNested(t *testing.T) {
 		t.Errorf("pkgs = %q; want %q", got, want)
 	}
 }
+
+func TestWalkKeepGoing(t *testing.T) {
+	dir, err := tempDir()
+	if err != nil {
+		t.Fatalf("tempDir() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, p := range []struct {
+		path, content string
+	}{
+		{path: "a/foo.go", content: "package x"},
+		{path: "a/bar.go", content: "package y"},
+		{path: "c/baz.go", content: "package c"},
+	} {
+		path := filepath.Join(dir, p.path)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatalf("os.MkdirAll(%q, 0700) failed with %v; want success", filepath.Dir(path), err)
+		}
+		if err := ioutil.WriteFile(path, []byte(p.content), 0600); err != nil {
+			t.Fatalf("ioutil.WriteFile(%q, %q, 0600) failed with %v; want success", path, p.content, err)
+		}
+	}
+
+	var pkgs []string
+	err = packages.WalkKeepGoing(build.Default, dir, func(pkg *build.Package) error {
+		pkgs = append(pkgs, pkg.Name)
+		return nil
+	})
+	if got, want := pkgs, []string{"c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pkgs = %q; want %q", got, want)
+	}
+	errs, ok := err.(packages.ErrorList)
+	if !ok {
+		t.Fatalf("packages.WalkKeepGoing(build.Default, %q, func) failed with %v; want packages.ErrorList", dir, err)
+	}
+	if got, want := len(errs), 1; got != want {
+		t.Fatalf("len(errs) = %d; want %d", got, want)
+	}
+	if got, want := errs[0].Dir, filepath.Join(dir, "a"); got != want {
+		t.Errorf("errs[0].Dir = %q; want %q", got, want)
+	}
+	if _, ok := errs[0].Err.(*build.MultiplePackageError); !ok {
+		t.Errorf("errs[0].Err = %v; want *build.MultiplePackageError", errs[0].Err)
+	}
+}
+
+func TestWalkMultiplePackages(t *testing.T) {
+	dir, err := tempDir()
+	if err != nil {
+		t.Fatalf("tempDir() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, p := range []struct {
+		path, content string
+	}{
+		{path: "a/a.go", content: "package a"},
+		{path: "a/a_test.go", content: "package a_test"},
+		{path: "a/gen.go", content: "// +build ignore\n\npackage main"},
+		{path: "a/other.go", content: "package other"},
+		{path: "b/b.go", content: `package foo // import "example.com/foo"`},
+		{path: "b/c.go", content: "package b"},
+	} {
+		path := filepath.Join(dir, p.path)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatalf("os.MkdirAll(%q, 0700) failed with %v; want success", filepath.Dir(path), err)
+		}
+		if err := ioutil.WriteFile(path, []byte(p.content), 0600); err != nil {
+			t.Fatalf("ioutil.WriteFile(%q, %q, 0600) failed with %v; want success", path, p.content, err)
+		}
+	}
+
+	got := make(map[string]*build.Package)
+	var diags []string
+	opts := packages.Options{
+		OnDiagnostic: func(e *diag.Error) {
+			rel, _ := filepath.Rel(dir, e.Path)
+			diags = append(diags, filepath.ToSlash(rel))
+		},
+	}
+	err = packages.WalkWithOptions(build.Default, dir, opts, func(pkg *build.Package) error {
+		got[filepath.Base(pkg.Dir)] = pkg
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("packages.WalkWithOptions(build.Default, %q, opts, func) failed with %v; want success", dir, err)
+	}
+	sort.Strings(diags)
+	if want := []string{"a/other.go", "b/c.go"}; !reflect.DeepEqual(diags, want) {
+		t.Errorf("diagnostics about %q; want %q", diags, want)
+	}
+
+	for _, spec := range []struct {
+		dir, name  string
+		goFiles    []string
+		xtestFiles []string
+	}{
+		{dir: "a", name: "a", goFiles: []string{"a.go"}, xtestFiles: []string{"a_test.go"}},
+		{dir: "b", name: "foo", goFiles: []string{"b.go"}},
+	} {
+		pkg := got[spec.dir]
+		if pkg == nil {
+			t.Errorf("package in %q not found", spec.dir)
+			continue
+		}
+		if pkg.Name != spec.name {
+			t.Errorf("pkg.Name = %q; want %q", pkg.Name, spec.name)
+		}
+		if !reflect.DeepEqual(pkg.GoFiles, spec.goFiles) {
+			t.Errorf("pkg.GoFiles = %q; want %q", pkg.GoFiles, spec.goFiles)
+		}
+		if len(pkg.XTestGoFiles) > 0 || len(spec.xtestFiles) > 0 {
+			if !reflect.DeepEqual(pkg.XTestGoFiles, spec.xtestFiles) {
+				t.Errorf("pkg.XTestGoFiles = %q; want %q", pkg.XTestGoFiles, spec.xtestFiles)
+			}
+		}
+	}
+}
+
+func TestWalkSkipsNestedWorkspace(t *testing.T) {
+	dir, err := tempDir()
+	if err != nil {
+		t.Fatalf("tempDir() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, p := range []struct {
+		path, content string
+	}{
+		{path: "WORKSPACE", content: ""},
+		{path: "a/a.go", content: "package a"},
+		{path: "nested/WORKSPACE", content: ""},
+		{path: "nested/b/b.go", content: "package b"},
+	} {
+		path := filepath.Join(dir, p.path)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatalf("os.MkdirAll(%q, 0700) failed with %v; want success", filepath.Dir(path), err)
+		}
+		if err := ioutil.WriteFile(path, []byte(p.content), 0600); err != nil {
+			t.Fatalf("ioutil.WriteFile(%q, %q, 0600) failed with %v; want success", path, p.content, err)
+		}
+	}
+
+	var pkgs []string
+	err = packages.Walk(build.Default, dir, func(pkg *build.Package) error {
+		pkgs = append(pkgs, pkg.Name)
+		return nil
+	})
+	if err != nil {
+		t.Errorf("packages.Walk(build.Default, %q, func) failed with %v; want success", dir, err)
+	}
+	if got, want := pkgs, []string{"a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pkgs = %q; want %q", got, want)
+	}
+}
+
+func TestWalkFollowSymlinks(t *testing.T) {
+	dir, err := tempDir()
+	if err != nil {
+		t.Fatalf("tempDir() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+	outside, err := tempDir()
+	if err != nil {
+		t.Fatalf("tempDir() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(outside)
+
+	for _, p := range []struct {
+		path, content string
+	}{
+		{path: "real/lib/lib.go", content: "package lib"},
+		{path: "tree/main.go", content: "package main"},
+		{path: "z/pkg/pkg.go", content: "package pkg"},
+		{path: "testdata/data/data.go", content: "package data"},
+	} {
+		path := filepath.Join(dir, p.path)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatalf("os.MkdirAll(%q, 0700) failed with %v; want success", filepath.Dir(path), err)
+		}
+		if err := ioutil.WriteFile(path, []byte(p.content), 0600); err != nil {
+			t.Fatalf("ioutil.WriteFile(%q, %q, 0600) failed with %v; want success", path, p.content, err)
+		}
+	}
+	path := filepath.Join(outside, "ext.go")
+	if err := ioutil.WriteFile(path, []byte("package ext"), 0600); err != nil {
+		t.Fatalf(`ioutil.WriteFile(%q, "package ext", 0600) failed with %v; want success`, path, err)
+	}
+	for link, target := range map[string]string{
+		filepath.Join(dir, "tree", "shared"):    filepath.Join("..", "real", "lib"),
+		filepath.Join(dir, "tree", "ext"):       outside,
+		filepath.Join(dir, "tree", "ext2"):      outside,
+		filepath.Join(outside, "loop"):          outside,
+		filepath.Join(dir, "real", "lib", "up"): filepath.Join("..", "..", "tree"),
+		filepath.Join(dir, "a"):                 filepath.Join("z", "pkg"),
+		filepath.Join(dir, "tree", "data"):      filepath.Join("..", "testdata", "data"),
+	} {
+		if err := os.Symlink(target, link); err != nil {
+			t.Fatalf("os.Symlink(%q, %q) failed with %v; want success", target, link, err)
+		}
+	}
+
+	walkDirs := func(root string) []string {
+		var dirs []string
+		opts := packages.Options{FollowSymlinks: true, RepoRoot: dir}
+		err := packages.WalkWithOptions(build.Default, root, opts, func(pkg *build.Package) error {
+			rel, err := filepath.Rel(dir, pkg.Dir)
+			if err != nil {
+				t.Errorf("filepath.Rel(%q, %q) failed with %v; want success", dir, pkg.Dir, err)
+				return err
+			}
+			dirs = append(dirs, filepath.ToSlash(rel))
+			return nil
+		})
+		if err != nil {
+			t.Errorf("packages.WalkWithOptions(build.Default, %q, %#v, func) failed with %v; want success", root, opts, err)
+		}
+		sort.Strings(dirs)
+		return dirs
+	}
+	// Links to directories outside of the repository are not followed, and
+	// linked directories in the repository are visited once, at their real
+	// paths, even if a link to them comes first. Directories the walk skips
+	// are skipped through links too.
+	if got, want := walkDirs(dir), []string{"real/lib", "tree", "z/pkg"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dirs = %q; want %q", got, want)
+	}
+	// Linked directories in the repository but outside of the walked tree
+	// are followed too, and the link back is not followed again.
+	if got, want := walkDirs(filepath.Join(dir, "tree")), []string{"real/lib", "tree"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dirs = %q; want %q", got, want)
+	}
+
+	for _, spec := range []struct {
+		rel, link, real string
+	}{
+		{rel: "tree"},
+		{rel: "tree/shared", link: "tree/shared", real: "real/lib"},
+		{rel: "tree/shared/up/shared", link: "tree/shared", real: "real/lib"},
+		{rel: "real/lib/up/shared", link: "real/lib/up", real: "tree"},
+		{rel: "tree/ext"},
+		{rel: "tree/missing/sub"},
+	} {
+		link, real, err := packages.Symlink(dir, spec.rel)
+		if err != nil {
+			t.Errorf("packages.Symlink(%q, %q) failed with %v; want success", dir, spec.rel, err)
+			continue
+		}
+		if link != spec.link || real != spec.real {
+			t.Errorf("packages.Symlink(%q, %q) = %q, %q; want %q, %q", dir, spec.rel, link, real, spec.link, spec.real)
+		}
+	}
+}
+
+func TestWalkEmptyDirs(t *testing.T) {
+	dir, err := tempDir()
+	if err != nil {
+		t.Fatalf("tempDir() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+	for _, p := range []string{"lib/lib.go", "protos/api.proto"} {
+		path := filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("package lib"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := make(map[string]string)
+	err = packages.WalkWithOptions(build.Default, dir, packages.Options{EmptyDirs: true}, func(pkg *build.Package) error {
+		rel, err := filepath.Rel(dir, pkg.Dir)
+		if err != nil {
+			return err
+		}
+		got[filepath.ToSlash(rel)] = pkg.Name
+		return nil
+	})
+	if err != nil {
+		t.Errorf("packages.WalkWithOptions(build.Default, %q, opts, func) failed with %v; want success", dir, err)
+	}
+	if want := map[string]string{".": "", "lib": "lib", "protos": ""}; !reflect.DeepEqual(got, want) {
+		t.Errorf("visited packages = %v; want %v", got, want)
+	}
+}
@@ -0,0 +1,181 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	"bufio"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// platforms are the GOOS/GOARCH pairs the Go rules can build for. It must be
+// consistent to go_environment_vars in go/def.bzl.
+var platforms = []struct{ goos, goarch string }{
+	{"linux", "amd64"},
+	{"linux", "386"},
+	{"linux", "arm"},
+	{"darwin", "amd64"},
+	{"freebsd", "amd64"},
+}
+
+const (
+	// goAsmHeader is the header the compiler writes for assembly sources of
+	// the package. The rules generate it when the asmhdr attribute is set.
+	goAsmHeader = "go_asm.h"
+)
+
+// includeRe matches an #include directive in assembly sources and headers.
+var includeRe = regexp.MustCompile(`^\s*#\s*include\s+["<]([^">]+)[">]`)
+
+// asmSources holds the assembly sources of a package and the files they
+// need.
+type asmSources struct {
+	// srcs are the assembly sources for any of the platforms, including the
+	// ones whose names restrict them to a GOOS or GOARCH, in sorted order.
+	// The rules filter them by their names.
+	srcs []string
+	// hdrs are the headers in the package directory which the sources
+	// include, directly or indirectly.
+	hdrs []string
+	// asmhdr is true if the sources include go_asm.h.
+	asmhdr bool
+}
+
+// collectAsm returns the assembly sources of "pkg". Sources which go/build
+// leaves out because their names are for other platforms are added if they
+// are built for one of platforms. Included headers which are not in the
+// package directory, like "textflag.h", are provided by the toolchain.
+func collectAsm(pkg *build.Package) (asmSources, error) {
+	var a asmSources
+	seen := make(map[string]bool)
+	for _, f := range pkg.SFiles {
+		seen[f] = true
+		a.srcs = append(a.srcs, f)
+	}
+	infos, err := ioutil.ReadDir(pkg.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return a, nil
+		}
+		return asmSources{}, err
+	}
+	for _, fi := range infos {
+		name := fi.Name()
+		if fi.IsDir() || seen[name] || !isAsm(name) || !hasPlatformSuffix(name) {
+			continue
+		}
+		if matchesSomePlatform(pkg.Dir, name) {
+			seen[name] = true
+			a.srcs = append(a.srcs, name)
+		}
+	}
+
+	sort.Strings(a.srcs)
+
+	hdrs := make(map[string]bool)
+	queue := append([]string{}, a.srcs...)
+	for len(queue) > 0 {
+		f := queue[0]
+		queue = queue[1:]
+		includes, err := readIncludes(filepath.Join(pkg.Dir, filepath.FromSlash(f)))
+		if err != nil {
+			return asmSources{}, err
+		}
+		for _, inc := range includes {
+			if path.IsAbs(inc) || strings.HasPrefix(path.Clean(inc), "../") {
+				continue
+			}
+			inc = path.Clean(inc)
+			if hdrs[inc] {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(pkg.Dir, filepath.FromSlash(inc))); err != nil {
+				if inc == goAsmHeader {
+					a.asmhdr = true
+				}
+				continue
+			}
+			hdrs[inc] = true
+			queue = append(queue, inc)
+		}
+	}
+	for h := range hdrs {
+		a.hdrs = append(a.hdrs, h)
+	}
+	sort.Strings(a.hdrs)
+	return a, nil
+}
+
+func isAsm(name string) bool {
+	return strings.HasSuffix(name, ".s") || strings.HasSuffix(name, ".S")
+}
+
+// hasPlatformSuffix determines if the name of a file ends with the GOOS or
+// GOARCH of one of platforms, e.g. "sum_amd64.s".
+func hasPlatformSuffix(name string) bool {
+	if i := strings.Index(name, "."); i >= 0 {
+		name = name[:i]
+	}
+	name = strings.TrimSuffix(name, "_test")
+	i := strings.LastIndex(name, "_")
+	if i < 0 {
+		return false
+	}
+	suffix := name[i+1:]
+	for _, p := range platforms {
+		if suffix == p.goos || suffix == p.goarch {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesSomePlatform determines if the file "name" in "dir" is built for
+// any of platforms, judging from its name and build constraints.
+func matchesSomePlatform(dir, name string) bool {
+	for _, p := range platforms {
+		ctxt := build.Default
+		ctxt.GOOS, ctxt.GOARCH = p.goos, p.goarch
+		if ok, err := ctxt.MatchFile(dir, name); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// readIncludes returns the files included by the file at "path".
+func readIncludes(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var includes []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if m := includeRe.FindStringSubmatch(s.Text()); m != nil {
+			includes = append(includes, m[1])
+		}
+	}
+	return includes, s.Err()
+}
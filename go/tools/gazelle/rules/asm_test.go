@@ -0,0 +1,64 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCollectAsm(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "asm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{
+		"sum.go":      "package sum\n",
+		"sum_amd64.s": "#include \"textflag.h\"\n#include \"sum.h\"\n",
+		"sum_arm.s":   "#include \"go_asm.h\"\n",
+		"sum_arm64.s": "#include \"unused.h\"\n",
+		"ignored.s":   "// +build ignore\n\n#include \"unused.h\"\n",
+		"sum.h":       "#include \"consts.h\"\n",
+		"consts.h":    "#define N 8\n",
+		"unused.h":    "",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pkg, err := build.ImportDir(dir, 0)
+	if err != nil {
+		t.Fatalf("build.ImportDir(%q, 0) failed with %v; want success", dir, err)
+	}
+
+	a, err := collectAsm(pkg)
+	if err != nil {
+		t.Fatalf("collectAsm(%#v) failed with %v; want success", pkg, err)
+	}
+	want := asmSources{
+		srcs:   []string{"sum_amd64.s", "sum_arm.s"},
+		hdrs:   []string{"consts.h", "sum.h"},
+		asmhdr: true,
+	}
+	if !reflect.DeepEqual(a, want) {
+		t.Errorf("collectAsm(%#v) = %#v; want %#v", pkg, a, want)
+	}
+}
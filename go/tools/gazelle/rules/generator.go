@@ -18,11 +18,14 @@ package rules
 import (
 	"fmt"
 	"go/build"
+	"log"
 	"path"
 	"path/filepath"
 	"strings"
 
 	bzl "github.com/bazelbuild/buildifier/core"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/diag"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/label"
 )
 
 const (
@@ -53,51 +56,193 @@ type Generator interface {
 	Generate(rel string, pkg *build.Package) ([]*bzl.Rule, error)
 }
 
+// A Config configures a Generator.
+type Config struct {
+	// GoPrefix is the go_prefix corresponding to the repository root.
+	// See also https://github.com/bazelbuild/rules_go#go_prefix.
+	GoPrefix string
+	// ImportRoots are the directory trees whose packages are imported with
+	// other prefixes than GoPrefix. Imports under their prefixes resolve into
+	// labels in those trees.
+	ImportRoots []ImportRoot
+	// StdPackages is the set of import paths in the Go standard library.
+	// If nil, DefaultStdPackages is used. If that fails, the error is logged
+	// and no import path is standard, so callers which need to handle the
+	// error call DefaultStdPackages themselves.
+	StdPackages map[string]bool
+	// Plugins generate rules of other kinds than the Go rules. Their rules
+	// are appended to the Go rules of each package.
+	Plugins []Plugin
+	// Naming is the convention for naming libraries and tests, both the
+	// generated ones and the ones imports resolve into.
+	Naming Naming
+	// Visibility sets the visibility of the generated libraries and binaries
+	// by directory. The last rule which matches a directory applies.
+	// Directories no rule matches are public, except that packages under
+	// "internal" or "vendor" directories are visible only to the tree of
+	// their parents.
+	Visibility []VisibilityRule
+	// Symlink returns the symbolic link to a directory in the repository on
+	// the way to the package "rel", and the path of its real directory, both
+	// relative to the repository root, or empty paths if there is none. See
+	// packages.Symlink. Imports of packages through the links resolve into
+	// the canonical labels in the real directories, which are the ones that
+	// get BUILD files. It is called only for labels of libraries, so that a
+	// run over a few packages does not scan the whole repository.
+	Symlink func(rel string) (link, real string, err error)
+	// GoGenerate makes the generator translate //go:generate directives which
+	// run stringer, mockgen, go-bindata or protoc into genrules. The files
+	// they generate are replaced with the genrules in the srcs of the Go
+	// rules. Directives it cannot translate are logged.
+	GoGenerate bool
+	// Repositories are the names of the external repositories declared in
+	// WORKSPACE. If not nil, imports which resolve into labels in other
+	// external repositories are unresolved.
+	Repositories map[string]bool
+	// Unresolved tells what to do with imports which cannot be resolved.
+	Unresolved UnresolvedPolicy
+	// OnUnresolved is called for each import which cannot be resolved. If it
+	// is set, the import is left out of the deps, or replaced with a
+	// placeholder label, even with UnresolvedError, and the caller decides
+	// what to do with the package. Otherwise the generator logs the import,
+	// or fails with UnresolvedError.
+	OnUnresolved func(UnresolvedImport)
+	// OnDiagnostic is called with the problems the generator finds in a
+	// package which do not keep it from generating rules, e.g. go:generate
+	// directives it cannot translate. Their paths are relative to the
+	// repository root. If nil, the problems are logged.
+	OnDiagnostic func(*diag.Error)
+	// RepoName is the name of the current repository. If set, labels of
+	// targets in other packages of the repository are qualified with it,
+	// e.g. "@repo//pkg:name", so that they refer to the same targets when
+	// the repository is used by another workspace as an external one.
+	// Labels of targets in the same package, e.g. the library of a test or
+	// ":go_prefix", stay relative, and package specifications in visibility
+	// stay unqualified: both always refer to the repository of the BUILD
+	// file they are in.
+	RepoName string
+}
+
 // NewGenerator returns an implementation of Generator.
-//
-// "goPrefix" is the go_prefix corresponding to the repository root.
-// See also https://github.com/bazelbuild/rules_go#go_prefix.
-func NewGenerator(goPrefix string) Generator {
+func NewGenerator(c Config) Generator {
 	var (
-		// TODO(yugui) Support another resolver to cover the pattern 2 in
-		// https://github.com/bazelbuild/rules_go/issues/16#issuecomment-216010843
-		r = structuredResolver{goPrefix: goPrefix}
-		e externalResolver
+		goPrefix = c.GoPrefix
+		r        = structuredResolver{goPrefix: goPrefix, naming: c.Naming}
+		l        = importRootResolver{roots: c.ImportRoots, naming: c.Naming}
+		e        = externalResolver{naming: c.Naming, repos: c.Repositories}
 	)
+	resolve := resolverFunc(func(importpath, dir string) (label.Label, error) {
+		if isRelative(importpath) {
+			return r.resolve(importpath, dir)
+		}
+		if _, ok := l.find(importpath); ok {
+			return l.resolve(importpath, dir)
+		}
+		if !inPrefix(importpath, goPrefix) {
+			return e.resolve(importpath, dir)
+		}
+		return r.resolve(importpath, dir)
+	})
+	std := c.StdPackages
+	if std == nil {
+		var err error
+		if std, err = DefaultStdPackages(); err != nil {
+			log.Print(err)
+		}
+	}
 
 	return &generator{
-		goPrefix: goPrefix,
-		r: resolverFunc(func(importpath, dir string) (label, error) {
-			if importpath != goPrefix && !strings.HasPrefix(importpath, goPrefix+"/") && !isRelative(importpath) {
-				return e.resolve(importpath, dir)
+		goPrefix:         goPrefix,
+		roots:            l,
+		std:              std,
+		plugins:          c.Plugins,
+		naming:           c.Naming,
+		goGenerate:       c.GoGenerate,
+		visibilityRules:  c.Visibility,
+		unresolvedPolicy: c.Unresolved,
+		onUnresolved:     c.OnUnresolved,
+		onDiagnostic:     c.OnDiagnostic,
+		repoName:         c.RepoName,
+		r: resolverFunc(func(importpath, dir string) (label.Label, error) {
+			lbl, err := resolve.resolve(importpath, dir)
+			if err != nil {
+				return label.Label{}, err
 			}
-			return r.resolve(importpath, dir)
+			if lbl, err = canonicalLabel(lbl, importpath, dir, c.Symlink, c.Naming); err != nil {
+				return label.Label{}, err
+			}
+			if c.RepoName != "" && lbl.Repo == "" && !lbl.Relative {
+				lbl.Repo = c.RepoName
+			}
+			return lbl, nil
 		}),
 	}
 }
 
 type generator struct {
-	goPrefix string
-	r        labelResolver
+	goPrefix         string
+	roots            importRootResolver
+	std              map[string]bool
+	plugins          []Plugin
+	naming           Naming
+	goGenerate       bool
+	visibilityRules  []VisibilityRule
+	unresolvedPolicy UnresolvedPolicy
+	onUnresolved     func(UnresolvedImport)
+	onDiagnostic     func(*diag.Error)
+	repoName         string
+	r                labelResolver
+}
+
+// diagnose passes "e" to Config.OnDiagnostic, or logs it.
+func (g *generator) diagnose(e *diag.Error) {
+	if g.onDiagnostic == nil {
+		log.Print(e)
+		return
+	}
+	g.onDiagnostic(e)
 }
 
 func (g *generator) Generate(rel string, pkg *build.Package) ([]*bzl.Rule, error) {
 	var rules []*bzl.Rule
-	if rel == "" {
-		p, err := newRule("go_prefix", []interface{}{g.goPrefix}, nil)
+	if root, ok := g.roots.subtree(rel); rel == "" || ok && root.Dir == rel {
+		prefix := g.goPrefix
+		if ok {
+			prefix = root.GoPrefix
+		}
+		p, err := newRule("go_prefix", []interface{}{prefix}, nil)
 		if err != nil {
 			return nil, err
 		}
 		rules = append(rules, p)
 	}
 
-	r, err := g.generate(rel, pkg)
-	if err != nil {
-		return nil, err
+	var genrules []*bzl.Rule
+	pbGo := hasPbGo(pkg.GoFiles)
+	if g.goGenerate {
+		var generated []genrule
+		var err error
+		if genrules, generated, err = g.generatedSources(rel, pkg); err != nil {
+			return nil, err
+		}
+		for _, gr := range generated {
+			pbGo = pbGo || hasPbGo(gr.outs)
+		}
+		pkg = withGenerated(pkg, generated)
 	}
-	rules = append(rules, r)
 
-	p, err := g.filegroup(rel, pkg)
+	// A package with only test files has no library.
+	var library string
+	if len(pkg.GoFiles) > 0 || len(pkg.CgoFiles) > 0 {
+		r, err := g.generate(rel, pkg)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+		library = r.AttrString("name")
+	}
+
+	p, err := g.filegroup(rel, pkg, pbGo)
 	if err != nil {
 		return nil, err
 	}
@@ -106,7 +251,7 @@ func (g *generator) Generate(rel string, pkg *build.Package) ([]*bzl.Rule, error
 	}
 
 	if len(pkg.TestGoFiles) > 0 {
-		t, err := g.generateTest(rel, pkg, r.AttrString("name"))
+		t, err := g.generateTest(rel, pkg, library)
 		if err != nil {
 			return nil, err
 		}
@@ -114,35 +259,50 @@ func (g *generator) Generate(rel string, pkg *build.Package) ([]*bzl.Rule, error
 	}
 
 	if len(pkg.XTestGoFiles) > 0 {
-		t, err := g.generateXTest(rel, pkg, r.AttrString("name"))
+		t, err := g.generateXTest(rel, pkg, library)
 		if err != nil {
 			return nil, err
 		}
 		rules = append(rules, t)
 	}
+	rules = append(rules, genrules...)
+
+	for _, p := range g.plugins {
+		rs, err := p.Generate(rel, pkg, g)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rs...)
+	}
 	return rules, nil
 }
 
 func (g *generator) generate(rel string, pkg *build.Package) (*bzl.Rule, error) {
 	kind := "go_library"
-	name := defaultLibName
+	name := g.naming.LibName(rel, g.goPrefix)
 	if pkg.IsCommand() {
 		kind = "go_binary"
 		name = path.Base(pkg.Dir)
 	}
 
-	visibility := "//visibility:public"
-	if i := strings.LastIndex(rel, "/internal/"); i >= 0 {
-		visibility = fmt.Sprintf("//%s:__subpackages__", rel[:i])
-	} else if strings.HasPrefix(rel, "internal/") {
-		visibility = "//:__subpackages__"
+	asm, err := collectAsm(pkg)
+	if err != nil {
+		return nil, err
 	}
-
+	srcs := append(append(append([]string{}, pkg.GoFiles...), asm.srcs...), asm.hdrs...)
 	attrs := []keyvalue{
 		{key: "name", value: name},
-		{key: "srcs", value: append(pkg.GoFiles, pkg.SFiles...)},
-		{key: "visibility", value: []string{visibility}},
+		{key: "srcs", value: srcs},
+		{key: "visibility", value: g.visibility(rel)},
+	}
+	if asm.asmhdr {
+		attrs = append(attrs, keyvalue{key: "asmhdr", value: 1})
 	}
+	if kind == "go_library" && name != defaultLibName {
+		// Otherwise go/def.bzl appends the name to the import path.
+		attrs = append(attrs, keyvalue{key: "dir_importpath", value: 1})
+	}
+	attrs = append(attrs, g.goPrefixAttr(rel)...)
 
 	deps, err := g.dependencies(pkg.Imports, rel)
 	if err != nil {
@@ -157,9 +317,10 @@ func (g *generator) generate(rel string, pkg *build.Package) (*bzl.Rule, error)
 
 // filegroup is a small hack for directories with pre-generated .pb.go files
 // and also source .proto files.  This creates a filegroup for the .proto in
-// addition to the usual go_library for the .pb.go files.
-func (g *generator) filegroup(rel string, pkg *build.Package) (*bzl.Rule, error) {
-	if !hasPbGo(pkg.GoFiles) {
+// addition to the usual go_library for the .pb.go files. "pbGo" tells whether
+// the package has .pb.go files, which may be generated by genrules.
+func (g *generator) filegroup(rel string, pkg *build.Package, pbGo bool) (*bzl.Rule, error) {
+	if !pbGo {
 		return nil, nil
 	}
 	protos, err := filepath.Glob(pkg.Dir + "/*.proto")
@@ -173,9 +334,9 @@ func (g *generator) filegroup(rel string, pkg *build.Package) (*bzl.Rule, error)
 		protos[i] = filepath.Base(p)
 	}
 	return newRule("filegroup", nil, []keyvalue{
-		{key: "name", value: defaultProtosName},
+		{key: "name", value: g.naming.ProtosName(g.naming.LibName(rel, g.goPrefix))},
 		{key: "srcs", value: protos},
-		{key: "visibility", value: []string{"//visibility:public"}},
+		{key: "visibility", value: g.visibility(rel)},
 	})
 }
 
@@ -188,16 +349,18 @@ func hasPbGo(files []string) bool {
 	return false
 }
 
+// generateTest generates the internal test of the package. "library" is the
+// name of the library of the package, or empty if it has none.
 func (g *generator) generateTest(rel string, pkg *build.Package, library string) (*bzl.Rule, error) {
-	name := library + "_test"
-	if library == defaultLibName {
-		name = defaultTestName
-	}
+	name := g.naming.TestName(g.libName(rel, library))
 	attrs := []keyvalue{
 		{key: "name", value: name},
 		{key: "srcs", value: pkg.TestGoFiles},
-		{key: "library", value: ":" + library},
 	}
+	if library != "" {
+		attrs = append(attrs, keyvalue{key: "library", value: ":" + library})
+	}
+	attrs = append(attrs, g.goPrefixAttr(rel)...)
 
 	deps, err := g.dependencies(pkg.TestImports, rel)
 	if err != nil {
@@ -209,15 +372,15 @@ func (g *generator) generateTest(rel string, pkg *build.Package, library string)
 	return newRule("go_test", nil, attrs)
 }
 
+// generateXTest generates the external test of the package. "library" is
+// the name of the library of the package, or empty if it has none.
 func (g *generator) generateXTest(rel string, pkg *build.Package, library string) (*bzl.Rule, error) {
-	name := library + "_xtest"
-	if library == defaultLibName {
-		name = defaultXTestName
-	}
+	name := g.naming.XTestName(g.libName(rel, library))
 	attrs := []keyvalue{
 		{key: "name", value: name},
 		{key: "srcs", value: pkg.XTestGoFiles},
 	}
+	attrs = append(attrs, g.goPrefixAttr(rel)...)
 
 	deps, err := g.dependencies(pkg.XTestImports, rel)
 	if err != nil {
@@ -227,25 +390,117 @@ func (g *generator) generateXTest(rel string, pkg *build.Package, library string
 	return newRule("go_test", nil, attrs)
 }
 
+// goPrefixAttr returns the go_prefix attribute of the Go rules in the
+// directory "rel". Rules in a subtree with its own import path prefix use the
+// go_prefix rule at the root of the subtree, so that go/def.bzl compiles them
+// with the import paths which the imports of their packages resolve by.
+// Other rules use the go_prefix of the repository, and have no attribute.
+func (g *generator) goPrefixAttr(rel string) []keyvalue {
+	root, ok := g.roots.subtree(rel)
+	if !ok {
+		return nil
+	}
+	l := label.New(g.repoName, root.Dir, "go_prefix")
+	if root.Dir == rel {
+		l = label.Label{Name: "go_prefix", Relative: true}
+	}
+	return []keyvalue{{key: "go_prefix", value: l.String()}}
+}
+
+// libName returns "library", or the name the library of the package in the
+// directory "rel" would have if "library" is empty, so that the tests of a
+// package without a library are named as if it had one.
+func (g *generator) libName(rel, library string) string {
+	if library == "" {
+		return g.naming.LibName(rel, g.goPrefix)
+	}
+	return library
+}
+
+// dependencies resolves "imports" into a list of labels. Imports which
+// resolve into the same label, e.g. "//a" and "//a:a", are listed once.
 func (g *generator) dependencies(imports []string, dir string) ([]string, error) {
-	var deps []string
+	var (
+		deps []string
+		seen []label.Label
+	)
 	for _, p := range imports {
-		if isStandard(p, g.goPrefix) {
-			continue
-		}
-		l, err := g.r.resolve(p, dir)
+		l, err := g.Resolve(p, dir)
 		if err != nil {
 			return nil, err
 		}
-		deps = append(deps, l.String())
+		if l == "" {
+			continue
+		}
+		if parsed, err := label.Parse(l); err == nil {
+			if containsLabel(seen, parsed) {
+				continue
+			}
+			seen = append(seen, parsed)
+		}
+		deps = append(deps, l)
 	}
 	return deps, nil
 }
 
+func containsLabel(labels []label.Label, l label.Label) bool {
+	for _, other := range labels {
+		if other.Equal(l) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve implements Resolver.
+func (g *generator) Resolve(importpath, dir string) (string, error) {
+	if g.isStandard(importpath) {
+		return "", nil
+	}
+	g.checkRestrictedImport(importpath, dir)
+	for _, p := range g.plugins {
+		if l, ok := p.Resolve(importpath); ok {
+			return l, nil
+		}
+	}
+	if !isRelative(importpath) && !inPrefix(importpath, g.goPrefix) && !hasDotInFirstSegment(importpath) {
+		if _, ok := g.roots.find(importpath); !ok {
+			// Such an import path cannot be fetched by "go get", so the
+			// repository of the package is not known. It may be a typo of a
+			// standard package, or provided by the environment, e.g. App
+			// Engine, so it is reported whatever the unresolved policy is.
+			g.diagnose(&diag.Error{
+				Path: filepath.Join(filepath.FromSlash(dir), "BUILD"),
+				Msg:  fmt.Sprintf("import %q is neither in the standard library nor under a known prefix; leaving it out of deps", importpath),
+				Fix:  `fix the import path if it is mistyped, or else add the dependency to deps with a "# keep" comment`,
+			})
+			return "", nil
+		}
+	}
+	l, err := g.r.resolve(importpath, dir)
+	if u, ok := err.(unresolvedError); ok {
+		return g.unresolved(importpath, dir, u)
+	}
+	if err != nil {
+		return "", err
+	}
+	return l.String(), nil
+}
+
 // isStandard determines if importpath points a Go standard package.
-func isStandard(importpath, goPrefix string) bool {
-	seg := strings.SplitN(importpath, "/", 2)[0]
-	return !strings.Contains(seg, ".") && !strings.HasPrefix(importpath, goPrefix+"/")
+func (g *generator) isStandard(importpath string) bool {
+	return g.std[importpath]
+}
+
+// inPrefix determines if importpath is goPrefix itself or under it.
+func inPrefix(importpath, goPrefix string) bool {
+	return importpath == goPrefix || strings.HasPrefix(importpath, goPrefix+"/")
+}
+
+// hasDotInFirstSegment determines if the first element of importpath looks
+// like a domain name, as is the case for packages fetched by "go get".
+func hasDotInFirstSegment(importpath string) bool {
+	return strings.Contains(strings.SplitN(importpath, "/", 2)[0], ".")
 }
 
 // isRelative determines if an importpath is relative.
@@ -17,6 +17,8 @@ package rules_test
 
 import (
 	"go/build"
+	"io/ioutil"
+	"os"
 	"path/filepath"
 	"testing"
 
@@ -51,7 +53,7 @@ func packageFromDir(t *testing.T, dir string) *build.Package {
 }
 
 func TestGenerator(t *testing.T) {
-	g := rules.NewGenerator("example.com/repo")
+	g := rules.NewGenerator(rules.Config{GoPrefix: "example.com/repo"})
 	for _, spec := range []struct {
 		dir  string
 		want string
@@ -129,7 +131,7 @@ func TestGenerator(t *testing.T) {
 }
 
 func TestGeneratorGoPrefix(t *testing.T) {
-	g := rules.NewGenerator("example.com/repo/lib")
+	g := rules.NewGenerator(rules.Config{GoPrefix: "example.com/repo/lib"})
 	pkg := packageFromDir(t, filepath.FromSlash("lib"))
 	rules, err := g.Generate("", pkg)
 	if err != nil {
@@ -146,3 +148,297 @@ func TestGeneratorGoPrefix(t *testing.T) {
 		t.Errorf("r = %q; want %q", got, want)
 	}
 }
+
+func TestGeneratorTestOnlyPackage(t *testing.T) {
+	g := rules.NewGenerator(rules.Config{GoPrefix: "example.com/repo"})
+	pkg := &build.Package{
+		Name:        "tests",
+		Dir:         filepath.Join(testdata.Dir(), "repo", "tests"),
+		TestGoFiles: []string{"tests_test.go"},
+	}
+	rules, err := g.Generate("tests", pkg)
+	if err != nil {
+		t.Errorf("g.Generate(%q, %#v) failed with %v; want success", "tests", pkg, err)
+	}
+
+	want := `
+		go_test(
+			name = "go_default_test",
+			srcs = ["tests_test.go"],
+		)
+	`
+	if got, want := format(rules), canonicalize(t, "tests/BUILD", want); got != want {
+		t.Errorf("g.Generate(%q, %#v) = %s; want %s", "tests", pkg, got, want)
+	}
+}
+
+func TestGeneratorSubtreeGoPrefix(t *testing.T) {
+	g := rules.NewGenerator(rules.Config{
+		GoPrefix:    "example.com/repo",
+		ImportRoots: []rules.ImportRoot{{Dir: "lib", GoPrefix: "example.org/lib"}},
+	})
+	for _, spec := range []struct {
+		dir  string
+		want string
+	}{
+		{
+			dir: "lib",
+			want: `
+				go_prefix("example.org/lib")
+
+				go_library(
+					name = "go_default_library",
+					srcs = [
+						"doc.go",
+						"lib.go",
+						"asm.s",
+					],
+					visibility = ["//visibility:public"],
+					go_prefix = ":go_prefix",
+					deps = ["//lib/internal/deep:go_default_library"],
+				)
+
+				go_test(
+					name = "go_default_test",
+					srcs = ["lib_test.go"],
+					library = ":go_default_library",
+					go_prefix = ":go_prefix",
+				)
+
+				go_test(
+					name = "go_default_xtest",
+					srcs = ["lib_external_test.go"],
+					go_prefix = ":go_prefix",
+					deps = [":go_default_library"],
+				)
+			`,
+		},
+		{
+			dir: "lib/internal/deep",
+			want: `
+				go_library(
+					name = "go_default_library",
+					srcs = ["thought.go"],
+					visibility = ["//lib:__subpackages__"],
+					go_prefix = "//lib:go_prefix",
+				)
+			`,
+		},
+	} {
+		pkg := packageFromDir(t, filepath.FromSlash(spec.dir))
+		rules, err := g.Generate(spec.dir, pkg)
+		if err != nil {
+			t.Errorf("g.Generate(%q, %#v) failed with %v; want success", spec.dir, pkg, err)
+		}
+		if got, want := format(rules), canonicalize(t, spec.dir+"/BUILD", spec.want); got != want {
+			t.Errorf("g.Generate(%q, %#v) = %s; want %s", spec.dir, pkg, got, want)
+		}
+	}
+}
+
+func TestGeneratorDirNaming(t *testing.T) {
+	g := rules.NewGenerator(rules.Config{
+		GoPrefix: "example.com/repo",
+		Naming:   rules.DirNaming,
+	})
+	pkg := packageFromDir(t, filepath.FromSlash("lib"))
+	rules, err := g.Generate("lib", pkg)
+	if err != nil {
+		t.Errorf("g.Generate(%q, %#v) failed with %v; want success", "lib", pkg, err)
+	}
+
+	want := `
+		go_library(
+			name = "lib",
+			srcs = [
+				"doc.go",
+				"lib.go",
+				"asm.s",
+			],
+			visibility = ["//visibility:public"],
+			dir_importpath = 1,
+			deps = ["//lib/internal/deep"],
+		)
+
+		go_test(
+			name = "lib_test",
+			srcs = ["lib_test.go"],
+			library = ":lib",
+		)
+
+		go_test(
+			name = "lib_xtest",
+			srcs = ["lib_external_test.go"],
+			deps = [":lib"],
+		)
+	`
+	if got, want := format(rules), canonicalize(t, "lib/BUILD", want); got != want {
+		t.Errorf("g.Generate(%q, %#v) = %s; want %s", "lib", pkg, got, want)
+	}
+}
+
+func TestGeneratorSubtreeGoPrefixRepoName(t *testing.T) {
+	g := rules.NewGenerator(rules.Config{
+		GoPrefix:    "example.com/repo",
+		ImportRoots: []rules.ImportRoot{{Dir: "lib", GoPrefix: "example.org/lib"}},
+		RepoName:    "com_example_repo",
+	})
+	for _, spec := range []struct {
+		dir  string
+		want string
+	}{
+		{
+			dir: "lib",
+			want: `
+				go_prefix("example.org/lib")
+
+				go_library(
+					name = "go_default_library",
+					srcs = [
+						"doc.go",
+						"lib.go",
+						"asm.s",
+					],
+					visibility = ["//visibility:public"],
+					go_prefix = ":go_prefix",
+					deps = ["@com_example_repo//lib/internal/deep:go_default_library"],
+				)
+
+				go_test(
+					name = "go_default_test",
+					srcs = ["lib_test.go"],
+					library = ":go_default_library",
+					go_prefix = ":go_prefix",
+				)
+
+				go_test(
+					name = "go_default_xtest",
+					srcs = ["lib_external_test.go"],
+					go_prefix = ":go_prefix",
+					deps = [":go_default_library"],
+				)
+			`,
+		},
+		{
+			dir: "lib/internal/deep",
+			want: `
+				go_library(
+					name = "go_default_library",
+					srcs = ["thought.go"],
+					visibility = ["//lib:__subpackages__"],
+					go_prefix = "@com_example_repo//lib:go_prefix",
+				)
+			`,
+		},
+	} {
+		pkg := packageFromDir(t, filepath.FromSlash(spec.dir))
+		rules, err := g.Generate(spec.dir, pkg)
+		if err != nil {
+			t.Errorf("g.Generate(%q, %#v) failed with %v; want success", spec.dir, pkg, err)
+		}
+		if got, want := format(rules), canonicalize(t, spec.dir+"/BUILD", spec.want); got != want {
+			t.Errorf("g.Generate(%q, %#v) = %s; want %s", spec.dir, pkg, got, want)
+		}
+	}
+}
+
+func TestGeneratorGoGenerate(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{
+		"color.go": `package color
+
+//go:generate stringer -type=Color
+//go:generate mockgen -source=color.go -destination=mock_color_test.go -package=color
+//go:generate protoc --go_out=. color.proto
+
+type Color int
+
+type Painter interface {
+	Paint(c Color)
+}
+`,
+		"color_string.go": "package color\n\nimport \"strconv\"\n",
+		"color.proto":     "syntax = \"proto3\";\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pkg, err := build.ImportDir(dir, build.ImportComment)
+	if err != nil {
+		t.Fatalf("build.ImportDir(%q, build.ImportComment) failed with %v; want success", dir, err)
+	}
+
+	g := rules.NewGenerator(rules.Config{
+		GoPrefix: "example.com/repo",
+		ImportRoots: []rules.ImportRoot{
+			{Repo: "org_golang_x_tools", GoPrefix: "golang.org/x/tools"},
+			{Repo: "com_github_golang_mock", GoPrefix: "github.com/golang/mock"},
+			{Repo: "com_github_golang_protobuf", GoPrefix: "github.com/golang/protobuf"},
+		},
+		GoGenerate: true,
+	})
+	rules, err := g.Generate("color", pkg)
+	if err != nil {
+		t.Fatalf("g.Generate(%q, %#v) failed with %v; want success", "color", pkg, err)
+	}
+	want := `
+		go_library(
+			name = "go_default_library",
+			srcs = [
+				"color.go",
+				":color_string_gen",
+				":color_pb_gen",
+			],
+			visibility = ["//visibility:public"],
+			deps = ["@com_github_golang_protobuf//proto:go_default_library"],
+		)
+
+		filegroup(
+			name = "go_default_library_protos",
+			srcs = ["color.proto"],
+			visibility = ["//visibility:public"],
+		)
+
+		go_test(
+			name = "go_default_test",
+			srcs = [":mock_color_test_gen"],
+			library = ":go_default_library",
+			deps = ["@com_github_golang_mock//gomock:go_default_library"],
+		)
+
+		genrule(
+			name = "color_string_gen",
+			srcs = ["color.go"],
+			outs = ["color_string.go"],
+			cmd = "$(location @org_golang_x_tools//cmd/stringer) -type=Color -output=$@ $(SRCS)",
+			tools = ["@org_golang_x_tools//cmd/stringer"],
+		)
+
+		genrule(
+			name = "mock_color_test_gen",
+			srcs = ["color.go"],
+			outs = ["mock_color_test.go"],
+			cmd = "$(location @com_github_golang_mock//mockgen) -source=$< -destination=$@ -package=color",
+			tools = ["@com_github_golang_mock//mockgen"],
+		)
+
+		genrule(
+			name = "color_pb_gen",
+			srcs = ["color.proto"],
+			outs = ["color.pb.go"],
+			cmd = "$(location @com_google_protobuf//:protoc) --plugin=protoc-gen-go=$(location @com_github_golang_protobuf//protoc-gen-go) --go_out=$(GENDIR)/color -I color $(SRCS)",
+			tools = [
+				"@com_google_protobuf//:protoc",
+				"@com_github_golang_protobuf//protoc-gen-go",
+			],
+		)
+	`
+	if got, want := format(rules), canonicalize(t, "color/BUILD", want); got != want {
+		t.Errorf("g.Generate(%q, %#v) = %s; want %s", "color", pkg, got, want)
+	}
+}
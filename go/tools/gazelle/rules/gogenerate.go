@@ -0,0 +1,771 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	"bufio"
+	"fmt"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	bzl "github.com/bazelbuild/buildifier/core"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/diag"
+)
+
+const (
+	// stringerImportPath is the import path of the stringer command.
+	stringerImportPath = "golang.org/x/tools/cmd/stringer"
+	// mockgenImportPath is the import path of the mockgen command.
+	mockgenImportPath = "github.com/golang/mock/mockgen"
+	// bindataImportPath is the import path of the go-bindata command.
+	bindataImportPath = "github.com/jteeuwen/go-bindata/go-bindata"
+	// protocGenGoImportPath is the import path of the protoc plugin for Go.
+	protocGenGoImportPath = "github.com/golang/protobuf/protoc-gen-go"
+	// protocLabel is the label of the protocol buffer compiler.
+	protocLabel = "@com_google_protobuf//:protoc"
+	// gomockImportPath is the import path of the package which mocks
+	// generated by mockgen import.
+	gomockImportPath = "github.com/golang/mock/gomock"
+	// protoImportPath is the import path of the package which code
+	// generated by protoc-gen-go imports.
+	protoImportPath = "github.com/golang/protobuf/proto"
+)
+
+// grpcImportPaths are the import paths of the packages which code generated
+// by protoc-gen-go with the grpc plugin imports in addition to proto.
+var grpcImportPaths = []string{"golang.org/x/net/context", "google.golang.org/grpc"}
+
+// directive is a //go:generate line in a Go source file.
+type directive struct {
+	// file is the name of the file in the package directory.
+	file string
+	line int
+	// args are the words of the command, with $GOFILE and $GOPACKAGE
+	// expanded.
+	args []string
+	text string
+}
+
+func (d directive) String() string {
+	return fmt.Sprintf("%s:%d: //go:generate %s", d.file, d.line, d.text)
+}
+
+// genrule is a genrule translated from a directive.
+type genrule struct {
+	name  string
+	srcs  []string
+	globs []string
+	outs  []string
+	cmd   string
+	tools []string
+	// imports are the packages which the generated Go files import, so that
+	// the library or test of a generated file depends on them after the
+	// checked-in copy is removed. Standard packages may be left out.
+	imports []string
+	// xtest is true if the generated Go files which are tests belong to the
+	// external test package.
+	xtest bool
+}
+
+// generatedSources generates a genrule for each //go:generate directive in
+// "pkg" which runs a well-known generator, and reports the directives it
+// cannot translate. It returns the rules and the translated directives, which
+// withGenerated takes.
+func (g *generator) generatedSources(rel string, pkg *build.Package) ([]*bzl.Rule, []genrule, error) {
+	directives, err := readDirectives(rel, pkg, g.diagnose)
+	if err != nil {
+		return nil, nil, err
+	}
+	var genrules []genrule
+	generated := make(map[string]string)
+	for _, d := range directives {
+		gr, err := g.translate(rel, pkg, d)
+		if err != nil {
+			g.diagnose(&diag.Error{
+				Path:   filepath.Join(filepath.FromSlash(rel), d.file),
+				Line:   d.line,
+				Column: 1,
+				Rule:   "//go:generate " + d.text,
+				Msg:    fmt.Sprintf("cannot translate the directive: %v", err),
+				Fix:    "write a genrule for it by hand, or check in the files it generates",
+			})
+			continue
+		}
+		genrules = append(genrules, gr)
+		for _, out := range gr.outs {
+			generated[out] = ":" + gr.name
+		}
+	}
+
+	var rules []*bzl.Rule
+	for _, gr := range genrules {
+		var srcs []string
+		for _, s := range gr.srcs {
+			if _, ok := generated[s]; !ok {
+				srcs = append(srcs, s)
+			}
+		}
+		attrs := []keyvalue{{key: "name", value: gr.name}}
+		if len(srcs) > 0 {
+			attrs = append(attrs, keyvalue{key: "srcs", value: srcs})
+		}
+		attrs = append(attrs,
+			keyvalue{key: "outs", value: gr.outs},
+			keyvalue{key: "cmd", value: gr.cmd},
+			keyvalue{key: "tools", value: gr.tools},
+		)
+		r, err := newRule("genrule", nil, attrs)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(gr.globs) > 0 {
+			r.SetAttr("srcs", globExpr(gr.globs))
+		}
+		rules = append(rules, r)
+
+		for _, out := range gr.outs {
+			if _, err := os.Stat(filepath.Join(pkg.Dir, out)); err == nil {
+				g.diagnose(&diag.Error{
+					Path: filepath.Join(filepath.FromSlash(rel), out),
+					Msg:  fmt.Sprintf("%s is checked in, but generated by //%s%s", out, rel, generated[out]),
+					Fix:  "remove the checked-in copy",
+				})
+			}
+		}
+	}
+	return rules, genrules, nil
+}
+
+// withGenerated returns a copy of "pkg" whose source files generated by
+// "genrules" are replaced with the labels of the genrules. Generated files
+// which are not checked in are added as a library or test source by their
+// names. The imports of the generated files are added to the imports of the
+// library or test they belong to, since they are missing from "pkg" once the
+// checked-in copies are removed.
+func withGenerated(pkg *build.Package, genrules []genrule) *build.Package {
+	if len(genrules) == 0 {
+		return pkg
+	}
+	p := *pkg
+	p.GoFiles = append([]string{}, pkg.GoFiles...)
+	p.TestGoFiles = append([]string{}, pkg.TestGoFiles...)
+	p.XTestGoFiles = append([]string{}, pkg.XTestGoFiles...)
+	p.Imports = append([]string{}, pkg.Imports...)
+	p.TestImports = append([]string{}, pkg.TestImports...)
+	p.XTestImports = append([]string{}, pkg.XTestImports...)
+	for _, gr := range genrules {
+		l := ":" + gr.name
+		for _, out := range gr.outs {
+			if !strings.HasSuffix(out, ".go") {
+				continue
+			}
+			files, imports := &p.GoFiles, &p.Imports
+			switch {
+			case contains(p.XTestGoFiles, out) || gr.xtest && strings.HasSuffix(out, "_test.go"):
+				files, imports = &p.XTestGoFiles, &p.XTestImports
+			case strings.HasSuffix(out, "_test.go"):
+				files, imports = &p.TestGoFiles, &p.TestImports
+			}
+			*files = replaceFile(*files, out, l)
+			*imports = addImports(*imports, gr.imports)
+		}
+	}
+	return &p
+}
+
+// replaceFile replaces "file" in "files" with "l", or appends "l" if "file"
+// is not in "files". "l" is listed once.
+func replaceFile(files []string, file, l string) []string {
+	var srcs []string
+	for _, f := range files {
+		if f == file {
+			f = l
+		}
+		if f != l || !contains(srcs, l) {
+			srcs = append(srcs, f)
+		}
+	}
+	if !contains(srcs, l) {
+		srcs = append(srcs, l)
+	}
+	return srcs
+}
+
+// addImports adds the packages in "add" which are not in "imports", and
+// returns the sorted list.
+func addImports(imports, add []string) []string {
+	for _, p := range add {
+		if !contains(imports, p) {
+			imports = append(imports, p)
+		}
+	}
+	sort.Strings(imports)
+	return imports
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// fileImports returns the imports of the Go "files" in "dir".
+func fileImports(dir string, files []string) ([]string, error) {
+	var imports []string
+	fset := token.NewFileSet()
+	for _, file := range files {
+		f, err := parser.ParseFile(fset, filepath.Join(dir, file), nil, parser.ImportsOnly)
+		if err != nil {
+			return nil, err
+		}
+		for _, spec := range f.Imports {
+			p, err := strconv.Unquote(spec.Path.Value)
+			if err != nil {
+				return nil, err
+			}
+			imports = addImports(imports, []string{p})
+		}
+	}
+	return imports, nil
+}
+
+// readDirectives returns the //go:generate directives in the Go files of
+// "pkg" in the directory "rel", including tests, in the order "go generate"
+// runs them. Directives which cannot be split into words are passed to
+// "diagnose" and left out.
+func readDirectives(rel string, pkg *build.Package, diagnose func(*diag.Error)) ([]directive, error) {
+	var files []string
+	files = append(files, pkg.GoFiles...)
+	files = append(files, pkg.CgoFiles...)
+	files = append(files, pkg.TestGoFiles...)
+	files = append(files, pkg.XTestGoFiles...)
+
+	var directives []directive
+	for _, file := range files {
+		f, err := os.Open(filepath.Join(pkg.Dir, file))
+		if err != nil {
+			return nil, err
+		}
+		s := bufio.NewScanner(f)
+		for line := 1; s.Scan(); line++ {
+			text := s.Text()
+			if !strings.HasPrefix(text, "//go:generate ") && !strings.HasPrefix(text, "//go:generate\t") {
+				continue
+			}
+			text = strings.TrimSpace(text[len("//go:generate"):])
+			d := directive{file: file, line: line, text: text}
+			d.args, err = splitDirective(text, file, pkg.Name)
+			if err != nil {
+				diagnose(&diag.Error{
+					Path:   filepath.Join(filepath.FromSlash(rel), file),
+					Line:   line,
+					Column: 1,
+					Rule:   "//go:generate " + text,
+					Msg:    err.Error(),
+				})
+				continue
+			}
+			directives = append(directives, d)
+		}
+		err = s.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return directives, nil
+}
+
+// splitDirective splits the command of a directive into words as
+// "go generate" does. Words are separated by spaces, and a double-quoted
+// word is unquoted. $GOFILE and $GOPACKAGE are expanded.
+func splitDirective(text, file, pkgName string) ([]string, error) {
+	var words []string
+	for text = strings.TrimSpace(text); text != ""; text = strings.TrimLeft(text, " \t") {
+		var word string
+		if text[0] == '"' {
+			i := 1
+			for ; i < len(text) && text[i] != '"'; i++ {
+				if text[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(text) {
+				return nil, fmt.Errorf("unterminated quoted string in //go:generate line")
+			}
+			var err error
+			if word, err = strconv.Unquote(text[:i+1]); err != nil {
+				return nil, fmt.Errorf("bad quoted string in //go:generate line: %v", err)
+			}
+			text = text[i+1:]
+		} else {
+			i := strings.IndexAny(text, " \t")
+			if i < 0 {
+				i = len(text)
+			}
+			word, text = text[:i], text[i:]
+		}
+		word = strings.Replace(word, "$GOFILE", file, -1)
+		word = strings.Replace(word, "$GOPACKAGE", pkgName, -1)
+		words = append(words, word)
+	}
+	return words, nil
+}
+
+// translate translates the directive "d" in "pkg" into a genrule.
+func (g *generator) translate(rel string, pkg *build.Package, d directive) (genrule, error) {
+	args := d.args
+	if len(args) == 0 {
+		return genrule{}, fmt.Errorf("empty command")
+	}
+	for _, a := range args {
+		if strings.Contains(a, "$") {
+			return genrule{}, fmt.Errorf("unsupported variable in %q", a)
+		}
+	}
+	if args[0] == "-command" {
+		return genrule{}, fmt.Errorf("-command aliases are not supported")
+	}
+
+	// "go run importpath args..." runs the command in importpath.
+	var importpath string
+	tool := args[0]
+	if len(args) >= 3 && args[0] == "go" && args[1] == "run" && !strings.HasSuffix(args[2], ".go") {
+		importpath, tool, args = args[2], path.Base(args[2]), args[2:]
+	}
+	args = args[1:]
+
+	switch tool {
+	case "stringer":
+		return g.translateStringer(rel, pkg, importpathOr(importpath, stringerImportPath), args)
+	case "mockgen":
+		return g.translateMockgen(rel, pkg, importpathOr(importpath, mockgenImportPath), args)
+	case "go-bindata":
+		return g.translateBindata(rel, importpathOr(importpath, bindataImportPath), args)
+	case "protoc":
+		if importpath != "" {
+			break
+		}
+		return g.translateProtoc(rel, args)
+	}
+	return genrule{}, fmt.Errorf("unknown generator %q", tool)
+}
+
+func importpathOr(importpath, defaultImportPath string) string {
+	if importpath != "" {
+		return importpath
+	}
+	return defaultImportPath
+}
+
+// translateStringer translates a stringer command. Without file arguments,
+// stringer reads the non-test Go files of the package. stringer type-checks
+// the files, and a genrule cannot provide the packages they import, so only
+// files which import nothing but standard packages are supported.
+func (g *generator) translateStringer(rel string, pkg *build.Package, importpath string, args []string) (genrule, error) {
+	flags, files, err := parseFlags(args, map[string]bool{"linecomment": true})
+	if err != nil {
+		return genrule{}, err
+	}
+	types := flags["type"]
+	if types == "" {
+		return genrule{}, fmt.Errorf("no -type flag")
+	}
+	out := flags["output"]
+	if out == "" {
+		out = strings.ToLower(strings.Split(types, ",")[0] + "_string.go")
+	}
+	if out, err = checkOut(out); err != nil {
+		return genrule{}, err
+	}
+
+	imports := pkg.Imports
+	switch {
+	case len(files) == 0 || len(files) == 1 && files[0] == ".":
+		files = append(append([]string{}, pkg.GoFiles...), pkg.CgoFiles...)
+	default:
+		for _, f := range files {
+			if err := checkLocal(f); err != nil {
+				return genrule{}, err
+			}
+			if !strings.HasSuffix(f, ".go") {
+				return genrule{}, fmt.Errorf("unsupported argument %q", f)
+			}
+		}
+		if imports, err = fileImports(pkg.Dir, files); err != nil {
+			return genrule{}, err
+		}
+	}
+	for _, imp := range imports {
+		if !g.isStandard(imp) {
+			return genrule{}, fmt.Errorf("stringer cannot type-check files which import %q in a genrule", imp)
+		}
+	}
+
+	tool, err := g.toolLabel(importpath, rel)
+	if err != nil {
+		return genrule{}, err
+	}
+	cmd := []string{fmt.Sprintf("$(location %s)", tool), "-type=" + types}
+	if v, ok := flags["trimprefix"]; ok {
+		cmd = append(cmd, "-trimprefix="+v)
+	}
+	if _, ok := flags["linecomment"]; ok {
+		cmd = append(cmd, "-linecomment")
+	}
+	if v, ok := flags["tags"]; ok {
+		cmd = append(cmd, "-tags="+strconv.Quote(v))
+	}
+	for k := range flags {
+		switch k {
+		case "type", "output", "trimprefix", "linecomment", "tags":
+		default:
+			return genrule{}, fmt.Errorf("unsupported flag -%s", k)
+		}
+	}
+	cmd = append(cmd, "-output=$@", "$(SRCS)")
+	return genrule{
+		name:  genruleName(out),
+		srcs:  files,
+		outs:  []string{out},
+		cmd:   strings.Join(cmd, " "),
+		tools: []string{tool},
+	}, nil
+}
+
+// translateMockgen translates a mockgen command in source mode. Reflect mode
+// needs to build a program against the package, so it is not supported. The
+// mocks are generated into the package directory, so they must belong to the
+// package or, if they are tests, to its external test package.
+func (g *generator) translateMockgen(rel string, pkg *build.Package, importpath string, args []string) (genrule, error) {
+	flags, rest, err := parseFlags(args, nil)
+	if err != nil {
+		return genrule{}, err
+	}
+	src, out := flags["source"], flags["destination"]
+	if src == "" || len(rest) > 0 {
+		return genrule{}, fmt.Errorf("mockgen is supported only in source mode")
+	}
+	if out == "" {
+		return genrule{}, fmt.Errorf("no -destination flag")
+	}
+	if err := checkLocal(src); err != nil {
+		return genrule{}, err
+	}
+	if out, err = checkOut(out); err != nil {
+		return genrule{}, err
+	}
+	// Without -package, mockgen names the package "mock_" followed by the
+	// name of the package of the source.
+	name := flags["package"]
+	if name == "" {
+		name = "mock_" + pkg.Name
+	}
+	isTest := strings.HasSuffix(out, "_test.go")
+	xtest := isTest && name == pkg.Name+"_test"
+	if name != pkg.Name && !xtest {
+		return genrule{}, fmt.Errorf("mocks in package %q cannot be generated into the directory of package %q", name, pkg.Name)
+	}
+	imports, err := fileImports(pkg.Dir, []string{src})
+	if err != nil {
+		return genrule{}, err
+	}
+	imports = addImports(imports, []string{gomockImportPath})
+
+	tool, err := g.toolLabel(importpath, rel)
+	if err != nil {
+		return genrule{}, err
+	}
+	cmd := []string{fmt.Sprintf("$(location %s)", tool), "-source=$<", "-destination=$@"}
+	for _, k := range []string{"package", "imports", "self_package", "mock_names"} {
+		if v, ok := flags[k]; ok {
+			cmd = append(cmd, fmt.Sprintf("-%s=%s", k, v))
+		}
+	}
+	for k := range flags {
+		switch k {
+		case "source", "destination", "package", "imports", "self_package", "mock_names":
+		default:
+			return genrule{}, fmt.Errorf("unsupported flag -%s", k)
+		}
+	}
+	return genrule{
+		name:    genruleName(out),
+		srcs:    []string{src},
+		outs:    []string{out},
+		cmd:     strings.Join(cmd, " "),
+		tools:   []string{tool},
+		imports: imports,
+		xtest:   xtest,
+	}, nil
+}
+
+// translateBindata translates a go-bindata command. The names of the assets
+// are relative to the package directory, as they are when "go generate" runs
+// the command there.
+func (g *generator) translateBindata(rel, importpath string, args []string) (genrule, error) {
+	boolFlags := map[string]bool{
+		"debug":      true,
+		"dev":        true,
+		"nocompress": true,
+		"nomemcopy":  true,
+		"nometadata": true,
+	}
+	flags, inputs, err := parseFlags(args, boolFlags)
+	if err != nil {
+		return genrule{}, err
+	}
+	if len(inputs) == 0 {
+		return genrule{}, fmt.Errorf("no input directories")
+	}
+	out := flags["o"]
+	if out == "" {
+		out = "bindata.go"
+	}
+	if out, err = checkOut(out); err != nil {
+		return genrule{}, err
+	}
+
+	tool, err := g.toolLabel(importpath, rel)
+	if err != nil {
+		return genrule{}, err
+	}
+	cmd := []string{fmt.Sprintf("$(location %s)", tool), "-o", "$@"}
+	if rel != "" {
+		cmd = append(cmd, "-prefix", rel+"/")
+	}
+	var names []string
+	for k := range flags {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		v := flags[k]
+		switch {
+		case k == "o":
+		case boolFlags[k]:
+			cmd = append(cmd, "-"+k)
+		case k == "pkg" || k == "tags" || k == "mode" || k == "modtime":
+			cmd = append(cmd, "-"+k, strconv.Quote(v))
+		default:
+			return genrule{}, fmt.Errorf("unsupported flag -%s", k)
+		}
+	}
+
+	var globs []string
+	for _, in := range inputs {
+		dir, recursive := strings.TrimSuffix(in, "/..."), strings.HasSuffix(in, "/...")
+		if err := checkLocal(dir); err != nil {
+			return genrule{}, err
+		}
+		dir = path.Clean(dir)
+		pattern := "*"
+		if recursive {
+			pattern = "**"
+		}
+		if dir == "." {
+			globs = append(globs, pattern)
+		} else {
+			globs = append(globs, dir+"/"+pattern)
+		}
+		if arg := path.Join(rel, dir); recursive {
+			cmd = append(cmd, arg+"/...")
+		} else {
+			cmd = append(cmd, arg)
+		}
+	}
+	return genrule{
+		name:  genruleName(out),
+		globs: globs,
+		outs:  []string{out},
+		cmd:   strings.Join(cmd, " "),
+		tools: []string{tool},
+	}, nil
+}
+
+// translateProtoc translates a protoc command which generates Go code for
+// .proto files in the package directory. The Go packages of the .proto files
+// they import are not known, so their libraries must be kept in deps with
+// "# keep" comments.
+func (g *generator) translateProtoc(rel string, args []string) (genrule, error) {
+	var (
+		includes []string
+		goOut    string
+		protos   []string
+	)
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		switch {
+		case a == "-I" || a == "--proto_path":
+			if i+1 >= len(args) {
+				return genrule{}, fmt.Errorf("missing value of %s", a)
+			}
+			i++
+			includes = append(includes, args[i])
+		case strings.HasPrefix(a, "-I"):
+			includes = append(includes, a[len("-I"):])
+		case strings.HasPrefix(a, "--proto_path="):
+			includes = append(includes, a[len("--proto_path="):])
+		case strings.HasPrefix(a, "--go_out="):
+			goOut = a[len("--go_out="):]
+		case strings.HasPrefix(a, "-"):
+			return genrule{}, fmt.Errorf("unsupported flag %s", a)
+		default:
+			protos = append(protos, a)
+		}
+	}
+	params, dir := "", goOut
+	if i := strings.LastIndex(goOut, ":"); i >= 0 {
+		params, dir = goOut[:i+1], goOut[i+1:]
+	}
+	if goOut == "" || path.Clean(dir) != "." {
+		return genrule{}, fmt.Errorf("only --go_out into the package directory is supported")
+	}
+	if len(protos) == 0 {
+		return genrule{}, fmt.Errorf("no .proto files")
+	}
+	if len(includes) == 0 {
+		includes = []string{"."}
+	}
+	imports := []string{protoImportPath}
+	if strings.Contains(params, "plugins=grpc") {
+		imports = addImports(imports, grpcImportPaths)
+	}
+
+	var outs []string
+	for _, p := range protos {
+		if !strings.HasSuffix(p, ".proto") || strings.ContainsAny(p, "*?[") || strings.Contains(p, "/") {
+			return genrule{}, fmt.Errorf("unsupported argument %q", p)
+		}
+		outs = append(outs, strings.TrimSuffix(p, ".proto")+".pb.go")
+	}
+	plugin, err := g.toolLabel(protocGenGoImportPath, rel)
+	if err != nil {
+		return genrule{}, err
+	}
+	cmd := []string{
+		fmt.Sprintf("$(location %s)", protocLabel),
+		fmt.Sprintf("--plugin=protoc-gen-go=$(location %s)", plugin),
+		fmt.Sprintf("--go_out=%s$(GENDIR)/%s", params, path.Join(rel, ".")),
+	}
+	for _, inc := range includes {
+		if err := checkLocal(inc); err != nil {
+			return genrule{}, err
+		}
+		cmd = append(cmd, "-I", path.Join(rel, inc))
+	}
+	cmd = append(cmd, "$(SRCS)")
+	return genrule{
+		name:    genruleName(outs[0]),
+		srcs:    protos,
+		outs:    outs,
+		cmd:     strings.Join(cmd, " "),
+		tools:   []string{protocLabel, plugin},
+		imports: imports,
+	}, nil
+}
+
+// toolLabel returns the label of the go_binary built from the package
+// "importpath".
+func (g *generator) toolLabel(importpath, rel string) (string, error) {
+	l, err := g.r.resolve(importpath, rel)
+	if err != nil {
+		return "", err
+	}
+	l.Name = path.Base(importpath)
+	return l.String(), nil
+}
+
+// parseFlags parses the flags of a command in "args", which are either
+// "-name=value", "-name value" or, for names in "boolFlags", "-name". Parsing
+// stops at the first non-flag argument. It returns the flags by their names
+// and the remaining arguments.
+func parseFlags(args []string, boolFlags map[string]bool) (map[string]string, []string, error) {
+	flags := make(map[string]string)
+	for len(args) > 0 {
+		a := args[0]
+		if a == "--" {
+			return flags, args[1:], nil
+		}
+		if len(a) < 2 || a[0] != '-' {
+			break
+		}
+		args = args[1:]
+		name := strings.TrimLeft(a, "-")
+		if i := strings.Index(name, "="); i >= 0 {
+			flags[name[:i]] = name[i+1:]
+			continue
+		}
+		if boolFlags[name] {
+			flags[name] = "true"
+			continue
+		}
+		if len(args) == 0 {
+			return nil, nil, fmt.Errorf("missing value of flag -%s", name)
+		}
+		flags[name], args = args[0], args[1:]
+	}
+	return flags, args, nil
+}
+
+// checkLocal reports an error if the relative path "p" is not in the
+// package directory or its subdirectories.
+func checkLocal(p string) error {
+	if path.IsAbs(p) || p == ".." || strings.HasPrefix(path.Clean(p), "../") {
+		return fmt.Errorf("path %q is outside the package directory", p)
+	}
+	return nil
+}
+
+// checkOut checks that a generated file "out" is in the package directory,
+// and returns its cleaned name. Files generated into other directories
+// belong to other packages.
+func checkOut(out string) (string, error) {
+	out = path.Clean(out)
+	if strings.Contains(out, "/") || out == "." || out == ".." {
+		return "", fmt.Errorf("output %q is not in the package directory", out)
+	}
+	return out, nil
+}
+
+// genruleName returns the name of the genrule which generates "out".
+func genruleName(out string) string {
+	name := strings.TrimSuffix(out, ".go")
+	name = strings.Replace(name, ".", "_", -1)
+	return name + "_gen"
+}
+
+// globExpr returns a call of glob with "patterns".
+func globExpr(patterns []string) bzl.Expr {
+	var list []bzl.Expr
+	for _, p := range patterns {
+		list = append(list, &bzl.StringExpr{Value: p})
+	}
+	return &bzl.CallExpr{
+		X:    &bzl.LiteralExpr{Token: "glob"},
+		List: []bzl.Expr{&bzl.ListExpr{List: list}},
+	}
+}
@@ -0,0 +1,254 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/diag"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/label"
+)
+
+func TestSplitDirective(t *testing.T) {
+	for _, spec := range []struct {
+		text string
+		want []string
+	}{
+		{
+			text: "stringer -type=Color",
+			want: []string{"stringer", "-type=Color"},
+		},
+		{
+			text: `mockgen  -source=$GOFILE	-package "mock $GOPACKAGE"`,
+			want: []string{"mockgen", "-source=color.go", "-package", "mock color"},
+		},
+		{
+			text: `echo "a\"b"`,
+			want: []string{"echo", `a"b`},
+		},
+	} {
+		got, err := splitDirective(spec.text, "color.go", "color")
+		if err != nil {
+			t.Errorf("splitDirective(%q) failed with %v; want success", spec.text, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, spec.want) {
+			t.Errorf("splitDirective(%q) = %q; want %q", spec.text, got, spec.want)
+		}
+	}
+
+	if got, err := splitDirective(`echo "a`, "color.go", "color"); err == nil {
+		t.Errorf("splitDirective(%q) = %q; want error", `echo "a`, got)
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	g := &generator{
+		r: resolverFunc(func(importpath, dir string) (label.Label, error) {
+			return label.New("tools", importpath, defaultLibName), nil
+		}),
+	}
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := "package color\n\nimport (\n\t\"io\"\n\n\t\"example.com/paint\"\n)\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "color.go"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pkg := &build.Package{
+		Dir:     dir,
+		Name:    "color",
+		GoFiles: []string{"color.go", "color_string.go"},
+	}
+	for _, spec := range []struct {
+		args []string
+		want genrule
+	}{
+		{
+			args: []string{"stringer", "-type=Color,Shade", "-linecomment"},
+			want: genrule{
+				name:  "color_string_gen",
+				srcs:  []string{"color.go", "color_string.go"},
+				outs:  []string{"color_string.go"},
+				cmd:   "$(location @tools//golang.org/x/tools/cmd/stringer) -type=Color,Shade -linecomment -output=$@ $(SRCS)",
+				tools: []string{"@tools//golang.org/x/tools/cmd/stringer"},
+			},
+		},
+		{
+			args: []string{"go", "run", "example.com/stringer", "-type", "Color", "-output", "names.go"},
+			want: genrule{
+				name:  "names_gen",
+				srcs:  []string{"color.go", "color_string.go"},
+				outs:  []string{"names.go"},
+				cmd:   "$(location @tools//example.com/stringer) -type=Color -output=$@ $(SRCS)",
+				tools: []string{"@tools//example.com/stringer"},
+			},
+		},
+		{
+			args: []string{"mockgen", "-source=color.go", "-destination=mock_color_test.go", "-package=color"},
+			want: genrule{
+				name:    "mock_color_test_gen",
+				srcs:    []string{"color.go"},
+				outs:    []string{"mock_color_test.go"},
+				cmd:     "$(location @tools//github.com/golang/mock/mockgen) -source=$< -destination=$@ -package=color",
+				tools:   []string{"@tools//github.com/golang/mock/mockgen"},
+				imports: []string{"example.com/paint", "github.com/golang/mock/gomock", "io"},
+			},
+		},
+		{
+			args: []string{"mockgen", "-source=color.go", "-destination=mock_color_test.go", "-package=color_test"},
+			want: genrule{
+				name:    "mock_color_test_gen",
+				srcs:    []string{"color.go"},
+				outs:    []string{"mock_color_test.go"},
+				cmd:     "$(location @tools//github.com/golang/mock/mockgen) -source=$< -destination=$@ -package=color_test",
+				tools:   []string{"@tools//github.com/golang/mock/mockgen"},
+				imports: []string{"example.com/paint", "github.com/golang/mock/gomock", "io"},
+				xtest:   true,
+			},
+		},
+		{
+			args: []string{"go-bindata", "-pkg", "color", "-nocompress", "static/..."},
+			want: genrule{
+				name:  "bindata_gen",
+				globs: []string{"static/**"},
+				outs:  []string{"bindata.go"},
+				cmd:   `$(location @tools//github.com/jteeuwen/go-bindata/go-bindata) -o $@ -prefix lib/color/ -nocompress -pkg "color" lib/color/static/...`,
+				tools: []string{"@tools//github.com/jteeuwen/go-bindata/go-bindata"},
+			},
+		},
+		{
+			args: []string{"protoc", "--go_out=plugins=grpc:.", "color.proto"},
+			want: genrule{
+				name:    "color_pb_gen",
+				srcs:    []string{"color.proto"},
+				outs:    []string{"color.pb.go"},
+				cmd:     "$(location @com_google_protobuf//:protoc) --plugin=protoc-gen-go=$(location @tools//github.com/golang/protobuf/protoc-gen-go) --go_out=plugins=grpc:$(GENDIR)/lib/color -I lib/color $(SRCS)",
+				tools:   []string{"@com_google_protobuf//:protoc", "@tools//github.com/golang/protobuf/protoc-gen-go"},
+				imports: []string{"github.com/golang/protobuf/proto", "golang.org/x/net/context", "google.golang.org/grpc"},
+			},
+		},
+	} {
+		got, err := g.translate("lib/color", pkg, directive{args: spec.args})
+		if err != nil {
+			t.Errorf("g.translate(%q) failed with %v; want success", spec.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, spec.want) {
+			t.Errorf("g.translate(%q) = %#v; want %#v", spec.args, got, spec.want)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"mockgen", "-destination=mock.go", "example.com/color", "Painter"},
+		{"mockgen", "-source=color.go", "-destination=mocks/mock.go"},
+		{"mockgen", "-source=color.go", "-destination=mock_color.go"},
+		{"mockgen", "-source=color.go", "-destination=mock_color.go", "-package=color_test"},
+		{"stringer", "-type=Color", "color.go"},
+		{"protoc", "--go_out=gen", "color.proto"},
+		{"sh", "-c", "echo hello > hello.go"},
+		{"stringer", "-type=$COLOR"},
+	} {
+		if got, err := g.translate("lib/color", pkg, directive{args: args}); err == nil {
+			t.Errorf("g.translate(%q) = %#v; want error", args, got)
+		}
+	}
+}
+
+func TestGeneratedSourcesDiagnostics(t *testing.T) {
+	var diags []*diag.Error
+	g := &generator{
+		r: resolverFunc(func(importpath, dir string) (label.Label, error) {
+			return label.New("tools", importpath, defaultLibName), nil
+		}),
+		onDiagnostic: func(e *diag.Error) {
+			diags = append(diags, e)
+		},
+	}
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{
+		"color.go":        "package color\n\n//go:generate stringer -type=Color\n//go:generate ./gen.sh\n//go:generate echo \"a\n",
+		"color_string.go": "package color\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pkg := &build.Package{
+		Dir:     dir,
+		Name:    "color",
+		GoFiles: []string{"color.go", "color_string.go"},
+	}
+	if _, _, err := g.generatedSources("lib/color", pkg); err != nil {
+		t.Fatalf("g.generatedSources(%q, pkg) failed with %v; want success", "lib/color", err)
+	}
+
+	var got []string
+	for _, e := range diags {
+		got = append(got, fmt.Sprintf("%s:%d", filepath.ToSlash(e.Path), e.Line))
+	}
+	want := []string{"lib/color/color.go:5", "lib/color/color.go:4", "lib/color/color_string.go:0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diagnostics at %q; want %q", got, want)
+	}
+}
+
+func TestWithGenerated(t *testing.T) {
+	pkg := &build.Package{
+		GoFiles:     []string{"color.go", "color_string.go"},
+		Imports:     []string{"fmt"},
+		TestGoFiles: []string{"color_test.go"},
+		TestImports: []string{"testing"},
+	}
+	got := withGenerated(pkg, []genrule{
+		{name: "color_string_gen", outs: []string{"color_string.go"}},
+		{name: "mock_color_test_gen", outs: []string{"mock_color_test.go"}, imports: []string{"github.com/golang/mock/gomock"}},
+		{name: "mock_paint_test_gen", outs: []string{"mock_paint_test.go"}, imports: []string{"github.com/golang/mock/gomock"}, xtest: true},
+	})
+	if want := []string{"color.go", ":color_string_gen"}; !reflect.DeepEqual(got.GoFiles, want) {
+		t.Errorf("withGenerated(...).GoFiles = %q; want %q", got.GoFiles, want)
+	}
+	if want := []string{"color_test.go", ":mock_color_test_gen"}; !reflect.DeepEqual(got.TestGoFiles, want) {
+		t.Errorf("withGenerated(...).TestGoFiles = %q; want %q", got.TestGoFiles, want)
+	}
+	if want := []string{"github.com/golang/mock/gomock", "testing"}; !reflect.DeepEqual(got.TestImports, want) {
+		t.Errorf("withGenerated(...).TestImports = %q; want %q", got.TestImports, want)
+	}
+	if want := []string{":mock_paint_test_gen"}; !reflect.DeepEqual(got.XTestGoFiles, want) {
+		t.Errorf("withGenerated(...).XTestGoFiles = %q; want %q", got.XTestGoFiles, want)
+	}
+	if want := []string{"github.com/golang/mock/gomock"}; !reflect.DeepEqual(got.XTestImports, want) {
+		t.Errorf("withGenerated(...).XTestImports = %q; want %q", got.XTestImports, want)
+	}
+	if want := []string{"color.go", "color_string.go"}; !reflect.DeepEqual(pkg.GoFiles, want) {
+		t.Errorf("pkg.GoFiles = %q; want %q after withGenerated", pkg.GoFiles, want)
+	}
+	if want := []string{"testing"}; !reflect.DeepEqual(pkg.TestImports, want) {
+		t.Errorf("pkg.TestImports = %q; want %q after withGenerated", pkg.TestImports, want)
+	}
+}
@@ -0,0 +1,94 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	"fmt"
+	"path"
+)
+
+// A Naming is a convention for naming the Go rules in a package.
+type Naming int
+
+const (
+	// DefaultNaming names the library of every package "go_default_library",
+	// and its tests "go_default_test" and "go_default_xtest".
+	DefaultNaming Naming = iota
+	// DirNaming names the library of a package after its directory, e.g.
+	// "//foo/bar:bar", and its tests "bar_test" and "bar_xtest". The library
+	// in the root of a repository is named after the last component of the
+	// import path of the repository.
+	DirNaming
+)
+
+// ParseNaming returns the Naming called "name", "default" or "dir".
+func ParseNaming(name string) (Naming, error) {
+	switch name {
+	case "default":
+		return DefaultNaming, nil
+	case "dir":
+		return DirNaming, nil
+	}
+	return 0, fmt.Errorf("unrecognized naming convention %q; want default or dir", name)
+}
+
+func (n Naming) String() string {
+	switch n {
+	case DefaultNaming:
+		return "default"
+	case DirNaming:
+		return "dir"
+	}
+	return fmt.Sprintf("Naming(%d)", int(n))
+}
+
+// LibName returns the name of the library rule in the package "pkg", a
+// slash-separated path from the root of a repository. "importpath" is the Go
+// import path of the package. It is used only if "pkg" is the root.
+func (n Naming) LibName(pkg, importpath string) string {
+	if n == DefaultNaming {
+		return defaultLibName
+	}
+	if pkg == "" {
+		return path.Base(importpath)
+	}
+	return path.Base(pkg)
+}
+
+// TestName returns the name of the internal test of the library "lib".
+func (n Naming) TestName(lib string) string {
+	if n == DefaultNaming {
+		return defaultTestName
+	}
+	return lib + "_test"
+}
+
+// XTestName returns the name of the external test of the library "lib".
+func (n Naming) XTestName(lib string) string {
+	if n == DefaultNaming {
+		return defaultXTestName
+	}
+	return lib + "_xtest"
+}
+
+// ProtosName returns the name of the filegroup of .proto files next to the
+// library "lib".
+func (n Naming) ProtosName(lib string) string {
+	if n == DefaultNaming {
+		return defaultProtosName
+	}
+	return lib + "_protos"
+}
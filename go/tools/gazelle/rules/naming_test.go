@@ -0,0 +1,119 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	"testing"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/label"
+)
+
+func TestParseNaming(t *testing.T) {
+	for _, n := range []Naming{DefaultNaming, DirNaming} {
+		if got, err := ParseNaming(n.String()); err != nil || got != n {
+			t.Errorf("ParseNaming(%q) = %v, %v; want %v, <nil>", n.String(), got, err, n)
+		}
+	}
+	if _, err := ParseNaming("go_default_library"); err == nil {
+		t.Errorf("ParseNaming(%q) succeeded; want failure", "go_default_library")
+	}
+}
+
+func TestNamingNames(t *testing.T) {
+	for _, spec := range []struct {
+		n                      Naming
+		pkg, importpath        string
+		lib, test, xtest, pbfg string
+	}{
+		{
+			n: DefaultNaming, pkg: "foo/bar", importpath: "example.com/repo/foo/bar",
+			lib: "go_default_library", test: "go_default_test", xtest: "go_default_xtest", pbfg: "go_default_library_protos",
+		},
+		{
+			n: DirNaming, pkg: "foo/bar", importpath: "example.com/repo/foo/bar",
+			lib: "bar", test: "bar_test", xtest: "bar_xtest", pbfg: "bar_protos",
+		},
+		{
+			n: DirNaming, pkg: "", importpath: "example.com/repo",
+			lib: "repo", test: "repo_test", xtest: "repo_xtest", pbfg: "repo_protos",
+		},
+	} {
+		lib := spec.n.LibName(spec.pkg, spec.importpath)
+		if lib != spec.lib {
+			t.Errorf("%v.LibName(%q, %q) = %q; want %q", spec.n, spec.pkg, spec.importpath, lib, spec.lib)
+		}
+		if got := spec.n.TestName(lib); got != spec.test {
+			t.Errorf("%v.TestName(%q) = %q; want %q", spec.n, lib, got, spec.test)
+		}
+		if got := spec.n.XTestName(lib); got != spec.xtest {
+			t.Errorf("%v.XTestName(%q) = %q; want %q", spec.n, lib, got, spec.xtest)
+		}
+		if got := spec.n.ProtosName(lib); got != spec.pbfg {
+			t.Errorf("%v.ProtosName(%q) = %q; want %q", spec.n, lib, got, spec.pbfg)
+		}
+	}
+}
+
+func TestResolversDirNaming(t *testing.T) {
+	repoRootForImportPath = stubRepoRootForImportPath
+
+	for _, spec := range []struct {
+		r          labelResolver
+		importpath string
+		want       string
+	}{
+		{
+			r:          structuredResolver{goPrefix: "example.com/repo", naming: DirNaming},
+			importpath: "example.com/repo",
+			want:       "//:repo",
+		},
+		{
+			r:          structuredResolver{goPrefix: "example.com/repo", naming: DirNaming},
+			importpath: "example.com/repo/foo/bar",
+			want:       "//foo/bar",
+		},
+		{
+			r:          structuredResolver{goPrefix: "example.com/repo", naming: DirNaming},
+			importpath: "example.com/repo/lib",
+			want:       ":lib",
+		},
+		{
+			r: importRootResolver{
+				roots:  []ImportRoot{{Repo: "examples", GoPrefix: "example.com/repo/examples"}},
+				naming: DirNaming,
+			},
+			importpath: "example.com/repo/examples",
+			want:       "@examples//:examples",
+		},
+		{
+			r:          externalResolver{naming: DirNaming},
+			importpath: "example.com/repo/foo",
+			want:       "@com_example_repo//foo",
+		},
+	} {
+		l, err := spec.r.resolve(spec.importpath, "lib")
+		if err != nil {
+			t.Errorf("%#v.resolve(%q) failed with %v; want success", spec.r, spec.importpath, err)
+			continue
+		}
+		if got := l.String(); got != spec.want {
+			t.Errorf("%#v.resolve(%q) = %s; want %s", spec.r, spec.importpath, got, spec.want)
+		}
+		if want, err := label.Parse(spec.want); err != nil || !l.Equal(want) {
+			t.Errorf("%#v.resolve(%q) = %#v; want a label equal to Parse(%q)", spec.r, spec.importpath, l, spec.want)
+		}
+	}
+}
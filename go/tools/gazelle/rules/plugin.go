@@ -0,0 +1,71 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	"go/build"
+
+	bzl "github.com/bazelbuild/buildifier/core"
+)
+
+// A Plugin generates rules of additional kinds, e.g. for protocol buffers or
+// code generators, in the package directories gazelle walks. Plugins run in
+// the same pass as the Go rule generator and share its import resolution.
+type Plugin interface {
+	// Kinds describes the rule kinds the plugin generates, keyed by kind.
+	Kinds() map[string]KindInfo
+	// Generate generates rules for the package directory "rel", in the same
+	// way as Generator.Generate. "r" resolves import paths into labels like
+	// for the Go rules. A directory without Go files is passed with a
+	// package which has no Go files.
+	Generate(rel string, pkg *build.Package, r Resolver) ([]*bzl.Rule, error)
+	// Resolve returns the label of a rule generated by the plugin which
+	// satisfies the Go import path "importpath". It returns false if the
+	// plugin generates no such rule. Imports satisfied by a plugin take
+	// precedence over the other resolution rules.
+	Resolve(importpath string) (label string, ok bool)
+}
+
+// KindInfo describes a rule kind generated by a Plugin.
+type KindInfo struct {
+	// Load is the label of the Skylark file which defines the kind. It is
+	// empty for native rules.
+	Load string
+	// MergeableAttrs are the attributes which gazelle owns in rules of the
+	// kind. When merging into an existing rule, their values are replaced
+	// with the generated ones and the other attributes are kept.
+	MergeableAttrs []string
+}
+
+// A Resolver resolves Go import paths into labels.
+type Resolver interface {
+	// Resolve returns the label of the rule which provides the Go package
+	// "importpath" to a rule in the package directory "dir". It returns an
+	// empty label if no dependency is needed, e.g. for packages in the
+	// standard library.
+	Resolve(importpath, dir string) (string, error)
+}
+
+// PluginKinds returns the rule kinds generated by "plugins".
+func PluginKinds(plugins []Plugin) map[string]KindInfo {
+	kinds := make(map[string]KindInfo)
+	for _, p := range plugins {
+		for k, info := range p.Kinds() {
+			kinds[k] = info
+		}
+	}
+	return kinds
+}
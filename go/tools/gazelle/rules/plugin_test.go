@@ -0,0 +1,123 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	"go/build"
+	"reflect"
+	"testing"
+
+	bzl "github.com/bazelbuild/buildifier/core"
+)
+
+// fakePlugin generates a "fake_library" rule which provides the Go packages
+// "example.com/repo/api" and "example.com/repo/api/v1", and depends on the
+// imports of the package.
+type fakePlugin struct{}
+
+func (fakePlugin) Kinds() map[string]KindInfo {
+	return map[string]KindInfo{
+		"fake_library": {Load: "//tools:fake.bzl", MergeableAttrs: []string{"deps"}},
+	}
+}
+
+func (fakePlugin) Generate(rel string, pkg *build.Package, r Resolver) ([]*bzl.Rule, error) {
+	var deps []string
+	for _, imp := range pkg.Imports {
+		l, err := r.Resolve(imp, rel)
+		if err != nil {
+			return nil, err
+		}
+		if l != "" {
+			deps = append(deps, l)
+		}
+	}
+	rule, err := newRule("fake_library", nil, []keyvalue{
+		{key: "name", value: "fake"},
+		{key: "deps", value: deps},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []*bzl.Rule{rule}, nil
+}
+
+func (fakePlugin) Resolve(importpath string) (string, bool) {
+	switch importpath {
+	case "example.com/repo/api":
+		return "//api/fake", true
+	case "example.com/repo/api/v1":
+		// The same rule as above, labeled in a different form.
+		return "//api/fake:fake", true
+	}
+	return "", false
+}
+
+func stringList(r *bzl.Rule, attr string) []string {
+	list, ok := r.Attr(attr).(*bzl.ListExpr)
+	if !ok {
+		return nil
+	}
+	var values []string
+	for _, e := range list.List {
+		if s, ok := e.(*bzl.StringExpr); ok {
+			values = append(values, s.Value)
+		}
+	}
+	return values
+}
+
+func TestPlugin(t *testing.T) {
+	g := NewGenerator(Config{
+		GoPrefix: "example.com/repo",
+		Plugins:  []Plugin{fakePlugin{}},
+	})
+	pkg := &build.Package{
+		Name:    "lib",
+		Dir:     "/repo/lib",
+		GoFiles: []string{"lib.go"},
+		Imports: []string{"example.com/repo/api", "example.com/repo/util", "example.com/repo/api/v1", "fmt"},
+	}
+	rs, err := g.Generate("lib", pkg)
+	if err != nil {
+		t.Fatalf("g.Generate(%q, %#v) failed with %v; want success", "lib", pkg, err)
+	}
+	var kinds []string
+	for _, r := range rs {
+		kinds = append(kinds, r.Kind())
+	}
+	if got, want := kinds, []string{"go_library", "fake_library"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("kinds of rules = %q; want %q", got, want)
+	}
+	want := []string{"//api/fake", "//util:go_default_library"}
+	if got := stringList(rs[0], "deps"); !reflect.DeepEqual(got, want) {
+		t.Errorf("deps of go_library = %q; want %q", got, want)
+	}
+	want = []string{"//api/fake", "//util:go_default_library", "//api/fake:fake"}
+	if got := stringList(rs[1], "deps"); !reflect.DeepEqual(got, want) {
+		t.Errorf("deps of fake_library = %q; want %q", got, want)
+	}
+}
+
+func TestPluginKinds(t *testing.T) {
+	got := PluginKinds([]Plugin{fakePlugin{}})
+	want := map[string]KindInfo{
+		"fake_library": {Load: "//tools:fake.bzl", MergeableAttrs: []string{"deps"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PluginKinds(%v) = %v; want %v", []Plugin{fakePlugin{}}, got, want)
+	}
+}
@@ -15,10 +15,7 @@ limitations under the License.
 
 package rules
 
-import (
-	"fmt"
-	"path"
-)
+import "github.com/bazelbuild/rules_go/go/tools/gazelle/label"
 
 // A labelResolver resolves a Go importpath into a label in Bazel.
 type labelResolver interface {
@@ -26,33 +23,11 @@ type labelResolver interface {
 	// a Go package directory "dir" in the current repository.
 	// "dir" is a relative slash-delimited path from the top level of the
 	// current repository.
-	resolve(importpath, dir string) (label, error)
+	resolve(importpath, dir string) (label.Label, error)
 }
 
-type resolverFunc func(importpath, dir string) (label, error)
+type resolverFunc func(importpath, dir string) (label.Label, error)
 
-func (f resolverFunc) resolve(importpath, dir string) (label, error) {
+func (f resolverFunc) resolve(importpath, dir string) (label.Label, error) {
 	return f(importpath, dir)
 }
-
-// A label represents a label of a build target in Bazel.
-type label struct {
-	repo, pkg, name string
-	relative        bool
-}
-
-func (l label) String() string {
-	if l.relative {
-		return fmt.Sprintf(":%s", l.name)
-	}
-
-	var repo string
-	if l.repo != "" {
-		repo = fmt.Sprintf("@%s", l.repo)
-	}
-
-	if path.Base(l.pkg) == l.name {
-		return fmt.Sprintf("%s//%s", repo, l.pkg)
-	}
-	return fmt.Sprintf("%s//%s:%s", repo, l.pkg, l.name)
-}
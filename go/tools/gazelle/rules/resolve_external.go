@@ -16,8 +16,10 @@ limitations under the License.
 package rules
 
 import (
+	"fmt"
 	"strings"
 
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/label"
 	"golang.org/x/tools/go/vcs"
 )
 
@@ -27,24 +29,52 @@ var (
 	repoRootForImportPath = vcs.RepoRootForImportPath
 )
 
-type externalResolver struct{}
+type externalResolver struct {
+	naming Naming
+	// repos are the names of the repositories declared in WORKSPACE. If not
+	// nil, labels in other repositories are not resolved.
+	repos map[string]bool
+}
 
 // resolve resolves "importpath" into a label, assuming that it is a label in an
 // external repository. It also assumes that the external repository follows the
 // recommended reverse-DNS form of workspace name as described in
 // http://bazel.io/docs/be/functions.html#workspace.
-func (e externalResolver) resolve(importpath, dir string) (label, error) {
+//
+// If the repository root of "importpath" cannot be found, or the repository
+// is not in e.repos, it returns an unresolvedError.
+func (e externalResolver) resolve(importpath, dir string) (label.Label, error) {
 	r, err := repoRootForImportPath(importpath, false)
 	if err != nil {
-		return label{}, err
+		// Guess that the import path is the repository root.
+		return label.Label{}, unresolvedError{placeholder: e.label(importpath, importpath), err: err}
+	}
+	l := e.label(importpath, r.Root)
+	if e.repos != nil && !e.repos[l.Repo] {
+		return label.Label{}, unresolvedError{placeholder: l, err: fmt.Errorf("repository @%s is not declared in WORKSPACE", l.Repo)}
 	}
+	return l, nil
+}
 
-	prefix := r.Root
+// label returns the label of the package "importpath" in the repository
+// whose root has the import path "prefix".
+func (e externalResolver) label(importpath, prefix string) label.Label {
 	var pkg string
 	if importpath != prefix {
 		pkg = strings.TrimPrefix(importpath, prefix+"/")
 	}
+	return label.Label{
+		Repo: RepositoryName(prefix),
+		Pkg:  pkg,
+		Name: e.naming.LibName(pkg, importpath),
+	}
+}
 
+// RepositoryName returns the conventional name of the repository whose root
+// has the import path "prefix": its host name reversed, followed by the rest
+// of the path, joined by underscores, e.g. "com_github_user_project" for
+// "github.com/user/project".
+func RepositoryName(prefix string) string {
 	components := strings.Split(prefix, "/")
 	labels := strings.Split(components[0], ".")
 	var reversed []string
@@ -53,11 +83,5 @@ func (e externalResolver) resolve(importpath, dir string) (label, error) {
 		reversed = append(reversed, l)
 	}
 	repo := strings.Join(append(reversed, components[1:]...), "_")
-	repo = strings.NewReplacer("-", "_", ".", "_").Replace(repo)
-
-	return label{
-		repo: repo,
-		pkg:  pkg,
-		name: defaultLibName,
-	}, nil
+	return strings.NewReplacer("-", "_", ".", "_").Replace(repo)
 }
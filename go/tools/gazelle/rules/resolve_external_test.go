@@ -20,6 +20,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/label"
 	"golang.org/x/tools/go/vcs"
 )
 
@@ -29,37 +30,37 @@ func TestExternalResolver(t *testing.T) {
 	var r externalResolver
 	for _, spec := range []struct {
 		importpath string
-		want       label
+		want       label.Label
 	}{
 		{
 			importpath: "example.com/repo",
-			want: label{
-				repo: "com_example_repo",
-				name: defaultLibName,
+			want: label.Label{
+				Repo: "com_example_repo",
+				Name: defaultLibName,
 			},
 		},
 		{
 			importpath: "example.com/repo/lib",
-			want: label{
-				repo: "com_example_repo",
-				pkg:  "lib",
-				name: defaultLibName,
+			want: label.Label{
+				Repo: "com_example_repo",
+				Pkg:  "lib",
+				Name: defaultLibName,
 			},
 		},
 		{
 			importpath: "example.com/repo.git/lib",
-			want: label{
-				repo: "com_example_repo_git",
-				pkg:  "lib",
-				name: defaultLibName,
+			want: label.Label{
+				Repo: "com_example_repo_git",
+				Pkg:  "lib",
+				Name: defaultLibName,
 			},
 		},
 		{
 			importpath: "example.com/lib",
-			want: label{
-				repo: "com_example",
-				pkg:  "lib",
-				name: defaultLibName,
+			want: label.Label{
+				Repo: "com_example",
+				Pkg:  "lib",
+				Name: defaultLibName,
 			},
 		},
 	} {
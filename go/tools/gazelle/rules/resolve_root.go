@@ -0,0 +1,99 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/label"
+)
+
+// An ImportRoot is a directory tree whose Go packages are imported with a
+// common prefix other than the go_prefix of the current repository, e.g. a
+// workspace nested in the current one or a subtree of the current repository
+// which holds a separate Go project.
+type ImportRoot struct {
+	// Repo is the name of the repository, used as @Repo in labels. It is
+	// empty for a subtree of the current repository.
+	Repo string
+	// Dir is a slash-separated path from the root of the repository to the
+	// root of the tree. It is empty if the tree is the whole repository.
+	Dir string
+	// GoPrefix is the import path prefix corresponding to Dir.
+	GoPrefix string
+}
+
+// importRootResolver resolves importpaths under the GoPrefix of import roots.
+type importRootResolver struct {
+	roots  []ImportRoot
+	naming Naming
+}
+
+// find returns the import root whose GoPrefix is the longest prefix of
+// "importpath".
+func (r importRootResolver) find(importpath string) (ImportRoot, bool) {
+	var (
+		found ImportRoot
+		ok    bool
+	)
+	for _, root := range r.roots {
+		if importpath != root.GoPrefix && !strings.HasPrefix(importpath, root.GoPrefix+"/") {
+			continue
+		}
+		if !ok || len(root.GoPrefix) > len(found.GoPrefix) {
+			found, ok = root, true
+		}
+	}
+	return found, ok
+}
+
+func (r importRootResolver) resolve(importpath, dir string) (label.Label, error) {
+	root, ok := r.find(importpath)
+	if !ok {
+		return label.Label{}, fmt.Errorf("importpath %q is not in any known import root", importpath)
+	}
+	pkg := path.Join(root.Dir, strings.TrimPrefix(importpath, root.GoPrefix))
+	pkg = strings.TrimPrefix(pkg, "/")
+	name := r.naming.LibName(pkg, importpath)
+	if root.Repo == "" && pkg == dir {
+		return label.Label{Name: name, Relative: true}, nil
+	}
+	return label.Label{Repo: root.Repo, Pkg: pkg, Name: name}, nil
+}
+
+// subtree returns the innermost import root which is a subtree of the
+// current repository containing the directory "rel", if any. The whole
+// repository is not a subtree.
+func (r importRootResolver) subtree(rel string) (ImportRoot, bool) {
+	var (
+		found ImportRoot
+		ok    bool
+	)
+	for _, root := range r.roots {
+		if root.Repo != "" || root.Dir == "" {
+			continue
+		}
+		if rel != root.Dir && !strings.HasPrefix(rel, root.Dir+"/") {
+			continue
+		}
+		if !ok || len(root.Dir) > len(found.Dir) {
+			found, ok = root, true
+		}
+	}
+	return found, ok
+}
@@ -0,0 +1,99 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/label"
+)
+
+func TestImportRootResolver(t *testing.T) {
+	r := importRootResolver{roots: []ImportRoot{
+		{Repo: "examples", GoPrefix: "example.com/repo/examples"},
+		{Repo: "deep", GoPrefix: "example.com/repo/examples/deep"},
+		{Dir: "projects/a", GoPrefix: "example.org/a"},
+	}}
+	for _, spec := range []struct {
+		importpath string
+		want       label.Label
+	}{
+		{
+			importpath: "example.com/repo/examples",
+			want:       label.Label{Repo: "examples", Name: defaultLibName},
+		},
+		{
+			importpath: "example.com/repo/examples/foo",
+			want:       label.Label{Repo: "examples", Pkg: "foo", Name: defaultLibName},
+		},
+		{
+			importpath: "example.com/repo/examples/deep/bar",
+			want:       label.Label{Repo: "deep", Pkg: "bar", Name: defaultLibName},
+		},
+		{
+			importpath: "example.org/a",
+			want:       label.Label{Pkg: "projects/a", Name: defaultLibName},
+		},
+		{
+			importpath: "example.org/a/lib",
+			want:       label.Label{Name: defaultLibName, Relative: true},
+		},
+		{
+			importpath: "example.org/a/other",
+			want:       label.Label{Pkg: "projects/a/other", Name: defaultLibName},
+		},
+	} {
+		l, err := r.resolve(spec.importpath, "projects/a/lib")
+		if err != nil {
+			t.Errorf("r.resolve(%q) failed with %v; want success", spec.importpath, err)
+			continue
+		}
+		if got, want := l, spec.want; !reflect.DeepEqual(got, want) {
+			t.Errorf("r.resolve(%q) = %s; want %s", spec.importpath, got, want)
+		}
+	}
+
+	if l, err := r.resolve("example.com/repo/examples_suffix", "lib"); err == nil {
+		t.Errorf("r.resolve(%q) = %s; want error", "example.com/repo/examples_suffix", l)
+	}
+}
+
+func TestImportRootResolverSubtree(t *testing.T) {
+	r := importRootResolver{roots: []ImportRoot{
+		{Repo: "examples", GoPrefix: "example.com/repo/examples"},
+		{GoPrefix: "example.com/repo"},
+		{Dir: "projects/a", GoPrefix: "example.org/a"},
+		{Dir: "projects/a/b", GoPrefix: "example.org/b"},
+	}}
+	for _, spec := range []struct {
+		rel    string
+		want   string
+		wantOK bool
+	}{
+		{rel: ""},
+		{rel: "projects"},
+		{rel: "projects/ab"},
+		{rel: "projects/a", want: "projects/a", wantOK: true},
+		{rel: "projects/a/lib", want: "projects/a", wantOK: true},
+		{rel: "projects/a/b/lib", want: "projects/a/b", wantOK: true},
+	} {
+		root, ok := r.subtree(spec.rel)
+		if ok != spec.wantOK || root.Dir != spec.want {
+			t.Errorf("r.subtree(%q) = %#v, %v; want Dir %q, %v", spec.rel, root, ok, spec.want, spec.wantOK)
+		}
+	}
+}
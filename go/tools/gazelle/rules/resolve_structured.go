@@ -19,32 +19,36 @@ import (
 	"fmt"
 	"path"
 	"strings"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/label"
 )
 
 // structuredResolver resolves go_library labels within the same repository as
 // the one of goPrefix.
 type structuredResolver struct {
 	goPrefix string
+	naming   Naming
 }
 
 // resolve takes a Go importpath within the same respository as r.goPrefix
 // and resolves it into a label in Bazel.
-func (r structuredResolver) resolve(importpath, dir string) (label, error) {
+func (r structuredResolver) resolve(importpath, dir string) (label.Label, error) {
 	if isRelative(importpath) {
 		importpath = path.Clean(path.Join(r.goPrefix, dir, importpath))
 	}
 
 	if importpath == r.goPrefix {
-		return label{name: defaultLibName}, nil
+		return label.Label{Name: r.naming.LibName("", importpath)}, nil
 	}
 
 	if prefix := r.goPrefix + "/"; strings.HasPrefix(importpath, prefix) {
 		pkg := strings.TrimPrefix(importpath, prefix)
+		name := r.naming.LibName(pkg, importpath)
 		if pkg == dir {
-			return label{name: defaultLibName, relative: true}, nil
+			return label.Label{Name: name, Relative: true}, nil
 		}
-		return label{pkg: pkg, name: defaultLibName}, nil
+		return label.Label{Pkg: pkg, Name: name}, nil
 	}
 
-	return label{}, fmt.Errorf("importpath %q does not start with goPrefix %q", importpath, r.goPrefix)
+	return label.Label{}, fmt.Errorf("importpath %q does not start with goPrefix %q", importpath, r.goPrefix)
 }
@@ -18,6 +18,8 @@ package rules
 import (
 	"reflect"
 	"testing"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/label"
 )
 
 func TestStructuredResolver(t *testing.T) {
@@ -25,43 +27,43 @@ func TestStructuredResolver(t *testing.T) {
 	for _, spec := range []struct {
 		importpath string
 		curPkg     string
-		want       label
+		want       label.Label
 	}{
 		{
 			importpath: "example.com/repo",
 			curPkg:     "",
-			want:       label{name: defaultLibName},
+			want:       label.Label{Name: defaultLibName},
 		},
 		{
 			importpath: "example.com/repo/lib",
 			curPkg:     "",
-			want:       label{pkg: "lib", name: defaultLibName},
+			want:       label.Label{Pkg: "lib", Name: defaultLibName},
 		},
 		{
 			importpath: "example.com/repo/another",
 			curPkg:     "",
-			want:       label{pkg: "another", name: defaultLibName},
+			want:       label.Label{Pkg: "another", Name: defaultLibName},
 		},
 
 		{
 			importpath: "example.com/repo",
 			curPkg:     "lib",
-			want:       label{name: defaultLibName},
+			want:       label.Label{Name: defaultLibName},
 		},
 		{
 			importpath: "example.com/repo/lib",
 			curPkg:     "lib",
-			want:       label{name: defaultLibName, relative: true},
+			want:       label.Label{Name: defaultLibName, Relative: true},
 		},
 		{
 			importpath: "example.com/repo/lib/sub",
 			curPkg:     "lib",
-			want:       label{pkg: "lib/sub", name: defaultLibName},
+			want:       label.Label{Pkg: "lib/sub", Name: defaultLibName},
 		},
 		{
 			importpath: "example.com/repo/another",
 			curPkg:     "lib",
-			want:       label{pkg: "another", name: defaultLibName},
+			want:       label.Label{Pkg: "another", Name: defaultLibName},
 		},
 	} {
 
@@ -89,3 +91,28 @@ func TestStructuredResolverError(t *testing.T) {
 		}
 	}
 }
+
+func TestResolveRepoName(t *testing.T) {
+	g := NewGenerator(Config{
+		GoPrefix:    "example.com/repo",
+		ImportRoots: []ImportRoot{{Repo: "com_example_nested", GoPrefix: "example.com/nested"}},
+		RepoName:    "com_example_repo",
+	}).(*generator)
+	for _, spec := range []struct {
+		importpath, want string
+	}{
+		{importpath: "example.com/repo/lib", want: "@com_example_repo//lib:go_default_library"},
+		{importpath: "example.com/repo", want: "@com_example_repo//:go_default_library"},
+		{importpath: "example.com/repo/a", want: ":go_default_library"},
+		{importpath: "example.com/nested/lib", want: "@com_example_nested//lib:go_default_library"},
+	} {
+		got, err := g.Resolve(spec.importpath, "a")
+		if err != nil {
+			t.Errorf("g.Resolve(%q, %q) failed with %v; want success", spec.importpath, "a", err)
+			continue
+		}
+		if got != spec.want {
+			t.Errorf("g.Resolve(%q, %q) = %q; want %q", spec.importpath, "a", got, spec.want)
+		}
+	}
+}
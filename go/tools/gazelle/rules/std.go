@@ -0,0 +1,304 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	"fmt"
+	"go/build"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// stdPackageVersions maps the import path of each package in the Go standard
+// library to the first Go release which contains it. "C" is the pseudo
+// package for cgo. Packages which were added after the last release listed
+// here are recognized only with LoadStdPackages. Packages which are built
+// only with a GOEXPERIMENT are not listed.
+var stdPackageVersions = map[string]string{
+	"C":                      "go1",
+	"archive/tar":            "go1",
+	"archive/zip":            "go1",
+	"bufio":                  "go1",
+	"bytes":                  "go1",
+	"cmp":                    "go1.21",
+	"compress/bzip2":         "go1",
+	"compress/flate":         "go1",
+	"compress/gzip":          "go1",
+	"compress/lzw":           "go1",
+	"compress/zlib":          "go1",
+	"container/heap":         "go1",
+	"container/list":         "go1",
+	"container/ring":         "go1",
+	"context":                "go1.7",
+	"crypto":                 "go1",
+	"crypto/aes":             "go1",
+	"crypto/cipher":          "go1",
+	"crypto/des":             "go1",
+	"crypto/dsa":             "go1",
+	"crypto/ecdh":            "go1.20",
+	"crypto/ecdsa":           "go1",
+	"crypto/ed25519":         "go1.13",
+	"crypto/elliptic":        "go1",
+	"crypto/fips140":         "go1.24",
+	"crypto/hkdf":            "go1.24",
+	"crypto/hmac":            "go1",
+	"crypto/hpke":            "go1.26",
+	"crypto/md5":             "go1",
+	"crypto/mldsa":           "go1.27",
+	"crypto/mlkem":           "go1.24",
+	"crypto/mlkem/mlkemtest": "go1.26",
+	"crypto/pbkdf2":          "go1.24",
+	"crypto/rand":            "go1",
+	"crypto/rc4":             "go1",
+	"crypto/rsa":             "go1",
+	"crypto/sha1":            "go1",
+	"crypto/sha256":          "go1",
+	"crypto/sha3":            "go1.24",
+	"crypto/sha512":          "go1",
+	"crypto/subtle":          "go1",
+	"crypto/tls":             "go1",
+	"crypto/x509":            "go1",
+	"crypto/x509/pkix":       "go1",
+	"database/sql":           "go1",
+	"database/sql/driver":    "go1",
+	"debug/buildinfo":        "go1.18",
+	"debug/dwarf":            "go1",
+	"debug/elf":              "go1",
+	"debug/gosym":            "go1",
+	"debug/macho":            "go1",
+	"debug/pe":               "go1",
+	"debug/plan9obj":         "go1.3",
+	"embed":                  "go1.16",
+	"encoding":               "go1.2",
+	"encoding/ascii85":       "go1",
+	"encoding/asn1":          "go1",
+	"encoding/base32":        "go1",
+	"encoding/base64":        "go1",
+	"encoding/binary":        "go1",
+	"encoding/csv":           "go1",
+	"encoding/gob":           "go1",
+	"encoding/hex":           "go1",
+	"encoding/json":          "go1",
+	"encoding/pem":           "go1",
+	"encoding/xml":           "go1",
+	"errors":                 "go1",
+	"expvar":                 "go1",
+	"flag":                   "go1",
+	"fmt":                    "go1",
+	"go/ast":                 "go1",
+	"go/build":               "go1",
+	"go/build/constraint":    "go1.16",
+	"go/constant":            "go1.5",
+	"go/doc":                 "go1",
+	"go/doc/comment":         "go1.19",
+	"go/format":              "go1.1",
+	"go/importer":            "go1.5",
+	"go/parser":              "go1",
+	"go/printer":             "go1",
+	"go/scanner":             "go1",
+	"go/token":               "go1",
+	"go/types":               "go1.5",
+	"go/version":             "go1.22",
+	"hash":                   "go1",
+	"hash/adler32":           "go1",
+	"hash/crc32":             "go1",
+	"hash/crc64":             "go1",
+	"hash/fnv":               "go1",
+	"hash/maphash":           "go1.14",
+	"html":                   "go1",
+	"html/template":          "go1",
+	"image":                  "go1",
+	"image/color":            "go1",
+	"image/color/palette":    "go1.2",
+	"image/draw":             "go1",
+	"image/gif":              "go1",
+	"image/jpeg":             "go1",
+	"image/png":              "go1",
+	"index/suffixarray":      "go1",
+	"io":                     "go1",
+	"io/fs":                  "go1.16",
+	"io/ioutil":              "go1",
+	"iter":                   "go1.23",
+	"log":                    "go1",
+	"log/slog":               "go1.21",
+	"log/syslog":             "go1",
+	"maps":                   "go1.21",
+	"math":                   "go1",
+	"math/big":               "go1",
+	"math/bits":              "go1.9",
+	"math/cmplx":             "go1",
+	"math/rand":              "go1",
+	"math/rand/v2":           "go1.22",
+	"mime":                   "go1",
+	"mime/multipart":         "go1",
+	"mime/quotedprintable":   "go1.5",
+	"net":                    "go1",
+	"net/http":               "go1",
+	"net/http/cgi":           "go1",
+	"net/http/cookiejar":     "go1.1",
+	"net/http/fcgi":          "go1",
+	"net/http/httptest":      "go1",
+	"net/http/httptrace":     "go1.7",
+	"net/http/httputil":      "go1",
+	"net/http/pprof":         "go1",
+	"net/mail":               "go1",
+	"net/netip":              "go1.18",
+	"net/rpc":                "go1",
+	"net/rpc/jsonrpc":        "go1",
+	"net/smtp":               "go1",
+	"net/textproto":          "go1",
+	"net/url":                "go1",
+	"os":                     "go1",
+	"os/exec":                "go1",
+	"os/signal":              "go1",
+	"os/user":                "go1",
+	"path":                   "go1",
+	"path/filepath":          "go1",
+	"plugin":                 "go1.8",
+	"reflect":                "go1",
+	"regexp":                 "go1",
+	"regexp/syntax":          "go1",
+	"runtime":                "go1",
+	"runtime/cgo":            "go1",
+	"runtime/coverage":       "go1.20",
+	"runtime/debug":          "go1",
+	"runtime/metrics":        "go1.16",
+	"runtime/pprof":          "go1",
+	"runtime/race":           "go1.1",
+	"runtime/trace":          "go1.5",
+	"slices":                 "go1.21",
+	"sort":                   "go1",
+	"strconv":                "go1",
+	"strings":                "go1",
+	"structs":                "go1.23",
+	"sync":                   "go1",
+	"sync/atomic":            "go1",
+	"syscall":                "go1",
+	"syscall/js":             "go1.11",
+	"testing":                "go1",
+	"testing/cryptotest":     "go1.26",
+	"testing/fstest":         "go1.16",
+	"testing/iotest":         "go1",
+	"testing/quick":          "go1",
+	"testing/slogtest":       "go1.21",
+	"testing/synctest":       "go1.25",
+	"text/scanner":           "go1",
+	"text/tabwriter":         "go1",
+	"text/template":          "go1",
+	"text/template/parse":    "go1",
+	"time":                   "go1",
+	"time/tzdata":            "go1.15",
+	"unicode":                "go1",
+	"unicode/utf16":          "go1",
+	"unicode/utf8":           "go1",
+	"unique":                 "go1.23",
+	"unsafe":                 "go1",
+	"uuid":                   "go1.27",
+	"weak":                   "go1.24",
+}
+
+// StdPackages returns the set of import paths of the standard library in the
+// Go release "version", e.g. "go1.8".
+func StdPackages(version string) (map[string]bool, error) {
+	v, err := minorVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	pkgs := make(map[string]bool)
+	for p, added := range stdPackageVersions {
+		if a, _ := minorVersion(added); a <= v {
+			pkgs[p] = true
+		}
+	}
+	return pkgs, nil
+}
+
+// DefaultStdPackages returns the standard library of the Go installation
+// gazelle was built with, as LoadStdPackages finds it. If the installation
+// is not available, it returns the standard library of the Go release
+// gazelle was built with, or an error if the release is unknown.
+func DefaultStdPackages() (map[string]bool, error) {
+	goroot := runtime.GOROOT()
+	if _, err := os.Stat(filepath.Join(goroot, "src")); goroot != "" && err == nil {
+		return LoadStdPackages(goroot)
+	}
+	tags := build.Default.ReleaseTags
+	return StdPackages(tags[len(tags)-1])
+}
+
+// LoadStdPackages returns the set of import paths of the standard library
+// in the Go installation at "goroot".
+func LoadStdPackages(goroot string) (map[string]bool, error) {
+	src := filepath.Join(goroot, "src")
+	pkgs := map[string]bool{"C": true}
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		switch base := info.Name(); {
+		case rel == "cmd" || rel == "vendor" || base == "internal" || base == "testdata":
+			return filepath.SkipDir
+		case base[0] == '.' || base[0] == '_':
+			return filepath.SkipDir
+		case rel == ".":
+			return nil
+		}
+		matches, err := filepath.Glob(filepath.Join(path, "*.go"))
+		if err != nil {
+			return err
+		}
+		if len(matches) > 0 {
+			pkgs[rel] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pkgs, nil
+}
+
+// minorVersion returns the minor version number of a Go release like
+// "go1.8". It returns 0 for "go1".
+func minorVersion(version string) (int, error) {
+	if version == "go1" {
+		return 0, nil
+	}
+	if !strings.HasPrefix(version, "go1.") {
+		return 0, fmt.Errorf("unrecognized Go version %q; want go1.N", version)
+	}
+	minor := strings.TrimPrefix(version, "go1.")
+	if i := strings.IndexAny(minor, ".rb"); i >= 0 {
+		// Drop the patch level or the pre-release suffix, e.g. go1.8.1, go1.9rc2.
+		minor = minor[:i]
+	}
+	v, err := strconv.Atoi(minor)
+	if err != nil {
+		return 0, fmt.Errorf("unrecognized Go version %q; want go1.N", version)
+	}
+	return v, nil
+}
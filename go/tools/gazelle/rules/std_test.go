@@ -0,0 +1,110 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStdPackages(t *testing.T) {
+	for _, spec := range []struct {
+		version string
+		pkg     string
+		want    bool
+	}{
+		{version: "go1.6", pkg: "fmt", want: true},
+		{version: "go1.6", pkg: "C", want: true},
+		{version: "go1.6", pkg: "context", want: false},
+		{version: "go1.7", pkg: "context", want: true},
+		{version: "go1.7.5", pkg: "context", want: true},
+		{version: "go1.9rc1", pkg: "math/bits", want: true},
+		{version: "go1.8", pkg: "math/bits", want: false},
+		{version: "go1.8", pkg: "github.com/foo/bar", want: false},
+		{version: "go1.26", pkg: "crypto/hpke", want: true},
+		{version: "go1.26", pkg: "uuid", want: false},
+		{version: "go1.27", pkg: "uuid", want: true},
+		{version: "go1.27", pkg: "encoding/json/v2", want: false},
+	} {
+		pkgs, err := StdPackages(spec.version)
+		if err != nil {
+			t.Errorf("StdPackages(%q) failed with %v; want success", spec.version, err)
+			continue
+		}
+		if got := pkgs[spec.pkg]; got != spec.want {
+			t.Errorf("StdPackages(%q)[%q] = %v; want %v", spec.version, spec.pkg, got, spec.want)
+		}
+	}
+
+	for _, version := range []string{"", "1.8", "go2", "go1.x"} {
+		if _, err := StdPackages(version); err == nil {
+			t.Errorf("StdPackages(%q) succeeded; want failure", version)
+		}
+	}
+}
+
+func TestDefaultStdPackages(t *testing.T) {
+	pkgs, err := DefaultStdPackages()
+	if err != nil {
+		t.Fatalf("DefaultStdPackages() failed with %v; want success", err)
+	}
+	for _, p := range []string{"C", "fmt", "net/http"} {
+		if !pkgs[p] {
+			t.Errorf("DefaultStdPackages()[%q] = false; want true", p)
+		}
+	}
+}
+
+func TestLoadStdPackages(t *testing.T) {
+	goroot, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "goroot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(goroot)
+	for _, p := range []string{
+		"fmt/print.go",
+		"net/http/server.go",
+		"net/http/internal/chunked.go",
+		"net/http/testdata/file.go",
+		"cmd/go/main.go",
+		"vendor/golang.org/x/net/http2/hpack/hpack.go",
+		"sort/README",
+	} {
+		path := filepath.Join(goroot, "src", filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := LoadStdPackages(goroot)
+	if err != nil {
+		t.Fatalf("LoadStdPackages(%q) failed with %v; want success", goroot, err)
+	}
+	want := map[string]bool{
+		"C":        true,
+		"fmt":      true,
+		"net/http": true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadStdPackages(%q) = %v; want %v", goroot, got, want)
+	}
+}
@@ -0,0 +1,47 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	"strings"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/label"
+)
+
+// canonicalLabel returns "l", a label for "importpath" referenced from the
+// directory "dir", with the Bazel package rewritten from a path through the
+// symbolic link to a directory "symlink" finds on the way to it to the path
+// of its real directory, where the package gets its BUILD file. The name of
+// a library is the one it has in the real directory. See Config.Symlink.
+func canonicalLabel(l label.Label, importpath, dir string, symlink func(rel string) (link, real string, err error), naming Naming) (label.Label, error) {
+	if symlink == nil || l.Repo != "" || l.Relative || l.Name != naming.LibName(l.Pkg, importpath) {
+		return l, nil
+	}
+	link, real, err := symlink(l.Pkg)
+	if err != nil {
+		return label.Label{}, err
+	}
+	if link == "" {
+		return l, nil
+	}
+	pkg := strings.TrimPrefix(real+strings.TrimPrefix(l.Pkg, link), "/")
+	l.Name = naming.LibName(pkg, importpath)
+	if pkg == dir {
+		return label.Label{Name: l.Name, Relative: true}, nil
+	}
+	l.Pkg = pkg
+	return l, nil
+}
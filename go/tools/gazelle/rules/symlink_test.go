@@ -0,0 +1,87 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/label"
+)
+
+func TestCanonicalLabel(t *testing.T) {
+	links := map[string]string{
+		"tree/shared": "real/lib",
+		"tree/lib":    "real/lib",
+	}
+	symlink := func(rel string) (link, real string, err error) {
+		for from, to := range links {
+			if rel == from || strings.HasPrefix(rel, from+"/") {
+				return from, to, nil
+			}
+		}
+		return "", "", nil
+	}
+	for _, spec := range []struct {
+		l      label.Label
+		naming Naming
+		dir    string
+		want   label.Label
+	}{
+		{
+			l:    label.Label{Pkg: "tree/shared", Name: defaultLibName},
+			want: label.Label{Pkg: "real/lib", Name: defaultLibName},
+		},
+		{
+			l:    label.Label{Pkg: "tree/shared/sub", Name: defaultLibName},
+			want: label.Label{Pkg: "real/lib/sub", Name: defaultLibName},
+		},
+		{
+			l:      label.Label{Pkg: "tree/shared", Name: "shared"},
+			naming: DirNaming,
+			want:   label.Label{Pkg: "real/lib", Name: "lib"},
+		},
+		{
+			l:    label.Label{Pkg: "tree/shared", Name: defaultLibName},
+			dir:  "real/lib",
+			want: label.Label{Name: defaultLibName, Relative: true},
+		},
+		{
+			l:      label.Label{Pkg: "tree/shared", Name: "proto"},
+			naming: DirNaming,
+			want:   label.Label{Pkg: "tree/shared", Name: "proto"},
+		},
+		{
+			l:      label.Label{Pkg: "treehouse", Name: "treehouse"},
+			naming: DirNaming,
+			want:   label.Label{Pkg: "treehouse", Name: "treehouse"},
+		},
+		{
+			l:      label.Label{Repo: "ext", Pkg: "tree/shared", Name: "shared"},
+			naming: DirNaming,
+			want:   label.Label{Repo: "ext", Pkg: "tree/shared", Name: "shared"},
+		},
+	} {
+		got, err := canonicalLabel(spec.l, "example.com/repo/"+spec.l.Pkg, spec.dir, symlink, spec.naming)
+		if err != nil {
+			t.Errorf("canonicalLabel(%#v) failed with %v; want success", spec.l, err)
+			continue
+		}
+		if got != spec.want {
+			t.Errorf("canonicalLabel(%#v) = %#v; want %#v", spec.l, got, spec.want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/label"
+)
+
+// An UnresolvedPolicy tells what to do with an import which cannot be
+// resolved into a label, e.g. because the repository root of its import path
+// cannot be found, or because it resolves into a repository which is not
+// declared in WORKSPACE.
+type UnresolvedPolicy int
+
+const (
+	// UnresolvedError makes the package fail to generate.
+	UnresolvedError UnresolvedPolicy = iota
+	// UnresolvedWarn leaves the import out of the deps and reports it.
+	UnresolvedWarn
+	// UnresolvedPlaceholder puts a label guessed from the import path in the
+	// deps and reports it. The label resolves once the repository is
+	// declared in WORKSPACE.
+	UnresolvedPlaceholder
+)
+
+// ParseUnresolvedPolicy returns the UnresolvedPolicy called "name", "error",
+// "warn" or "placeholder".
+func ParseUnresolvedPolicy(name string) (UnresolvedPolicy, error) {
+	switch name {
+	case "error":
+		return UnresolvedError, nil
+	case "warn":
+		return UnresolvedWarn, nil
+	case "placeholder":
+		return UnresolvedPlaceholder, nil
+	}
+	return 0, fmt.Errorf("unrecognized policy for unresolved imports %q; want error, warn or placeholder", name)
+}
+
+func (p UnresolvedPolicy) String() string {
+	switch p {
+	case UnresolvedError:
+		return "error"
+	case UnresolvedWarn:
+		return "warn"
+	case UnresolvedPlaceholder:
+		return "placeholder"
+	}
+	return fmt.Sprintf("UnresolvedPolicy(%d)", int(p))
+}
+
+// An UnresolvedImport is an import which could not be resolved into a label.
+type UnresolvedImport struct {
+	// ImportPath is the import path of the imported package.
+	ImportPath string
+	// Dir is the slash-separated path from the repository root to the
+	// directory of the importing package.
+	Dir string
+	// Label is the placeholder label put in the deps with
+	// UnresolvedPlaceholder. It is empty otherwise.
+	Label string
+	// Err tells why the import could not be resolved.
+	Err error
+}
+
+func (u UnresolvedImport) Error() string {
+	return fmt.Sprintf("//%s: cannot resolve import %q: %v", u.Dir, u.ImportPath, u.Err)
+}
+
+// unresolvedError is returned by labelResolvers for imports which cannot be
+// resolved. placeholder is the label to use with UnresolvedPlaceholder.
+type unresolvedError struct {
+	placeholder label.Label
+	err         error
+}
+
+func (e unresolvedError) Error() string {
+	return e.err.Error()
+}
+
+// unresolved handles the import of "importpath" from "dir" which failed to
+// resolve with "err" according to the policy of the generator. It returns
+// the label to put in the deps, if any.
+func (g *generator) unresolved(importpath, dir string, err unresolvedError) (string, error) {
+	u := UnresolvedImport{ImportPath: importpath, Dir: dir, Err: err.err}
+	if g.unresolvedPolicy == UnresolvedPlaceholder {
+		u.Label = err.placeholder.String()
+	}
+	if g.onUnresolved != nil {
+		g.onUnresolved(u)
+		return u.Label, nil
+	}
+	if g.unresolvedPolicy == UnresolvedError {
+		return "", u
+	}
+	log.Print(u)
+	return u.Label, nil
+}
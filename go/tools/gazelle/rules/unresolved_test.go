@@ -0,0 +1,153 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/diag"
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestParseUnresolvedPolicy(t *testing.T) {
+	for _, p := range []UnresolvedPolicy{UnresolvedError, UnresolvedWarn, UnresolvedPlaceholder} {
+		if got, err := ParseUnresolvedPolicy(p.String()); err != nil || got != p {
+			t.Errorf("ParseUnresolvedPolicy(%q) = %v, %v; want %v, <nil>", p.String(), got, err, p)
+		}
+	}
+	if _, err := ParseUnresolvedPolicy("ignore"); err == nil {
+		t.Errorf("ParseUnresolvedPolicy(%q) succeeded; want failure", "ignore")
+	}
+}
+
+func TestResolveUnresolved(t *testing.T) {
+	repoRootForImportPath = func(importpath string, verbose bool) (*vcs.RepoRoot, error) {
+		if strings.HasPrefix(importpath, "bad.example/") {
+			return nil, errors.New("unrecognized import path")
+		}
+		return stubRepoRootForImportPath(importpath, verbose)
+	}
+	defer func() { repoRootForImportPath = vcs.RepoRootForImportPath }()
+
+	for _, spec := range []struct {
+		policy     UnresolvedPolicy
+		importpath string
+		want       string
+		wantErr    bool
+		collected  []UnresolvedImport
+	}{
+		{
+			policy:     UnresolvedError,
+			importpath: "example.com/repo/lib",
+			want:       "@com_example_repo//lib:go_default_library",
+		},
+		{
+			policy:     UnresolvedError,
+			importpath: "example.com/other/lib",
+			wantErr:    true,
+		},
+		{
+			policy:     UnresolvedWarn,
+			importpath: "bad.example/x/y",
+			collected: []UnresolvedImport{
+				{ImportPath: "bad.example/x/y", Dir: "a", Err: errors.New("unrecognized import path")},
+			},
+		},
+		{
+			policy:     UnresolvedPlaceholder,
+			importpath: "example.com/other/lib",
+			want:       "@com_example//other/lib:go_default_library",
+			collected: []UnresolvedImport{
+				{
+					ImportPath: "example.com/other/lib",
+					Dir:        "a",
+					Label:      "@com_example//other/lib:go_default_library",
+					Err:        errors.New("repository @com_example is not declared in WORKSPACE"),
+				},
+			},
+		},
+		{
+			policy:     UnresolvedPlaceholder,
+			importpath: "bad.example/x/y",
+			want:       "@example_bad_x_y//:go_default_library",
+			collected: []UnresolvedImport{
+				{
+					ImportPath: "bad.example/x/y",
+					Dir:        "a",
+					Label:      "@example_bad_x_y//:go_default_library",
+					Err:        errors.New("unrecognized import path"),
+				},
+			},
+		},
+	} {
+		var collected []UnresolvedImport
+		c := Config{
+			GoPrefix:     "example.com/main",
+			Repositories: map[string]bool{"com_example_repo": true},
+			Unresolved:   spec.policy,
+		}
+		if spec.policy != UnresolvedError {
+			c.OnUnresolved = func(u UnresolvedImport) { collected = append(collected, u) }
+		}
+		g := NewGenerator(c).(*generator)
+		got, err := g.Resolve(spec.importpath, "a")
+		if spec.wantErr {
+			if err == nil {
+				t.Errorf("Resolve(%q) with %v succeeded; want failure", spec.importpath, spec.policy)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Resolve(%q) with %v failed with %v; want success", spec.importpath, spec.policy, err)
+			continue
+		}
+		if got != spec.want {
+			t.Errorf("Resolve(%q) with %v = %q; want %q", spec.importpath, spec.policy, got, spec.want)
+		}
+		if !reflect.DeepEqual(collected, spec.collected) {
+			t.Errorf("Resolve(%q) with %v collected %v; want %v", spec.importpath, spec.policy, collected, spec.collected)
+		}
+	}
+}
+
+func TestResolveUnknownDotless(t *testing.T) {
+	for _, policy := range []UnresolvedPolicy{UnresolvedError, UnresolvedWarn, UnresolvedPlaceholder} {
+		var (
+			unresolved []UnresolvedImport
+			diags      []*diag.Error
+		)
+		g := NewGenerator(Config{
+			GoPrefix:     "example.com/main",
+			Unresolved:   policy,
+			OnUnresolved: func(u UnresolvedImport) { unresolved = append(unresolved, u) },
+			OnDiagnostic: func(e *diag.Error) { diags = append(diags, e) },
+		}).(*generator)
+		got, err := g.Resolve("mycorp/lib", "a")
+		if err != nil || got != "" {
+			t.Errorf("Resolve(%q) with %v = %q, %v; want \"\", nil", "mycorp/lib", policy, got, err)
+		}
+		if unresolved != nil {
+			t.Errorf("Resolve(%q) with %v collected %v; want none", "mycorp/lib", policy, unresolved)
+		}
+		if len(diags) != 1 || diags[0].Path != filepath.Join("a", "BUILD") {
+			t.Errorf("Resolve(%q) with %v reported %v; want one diagnostic about %s", "mycorp/lib", policy, diags, filepath.Join("a", "BUILD"))
+		}
+	}
+}
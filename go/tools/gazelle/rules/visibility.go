@@ -0,0 +1,139 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/diag"
+)
+
+const publicVisibility = "//visibility:public"
+
+// A VisibilityRule sets the visibility of the rules generated in the
+// directories which match a pattern.
+type VisibilityRule struct {
+	// Pattern is a slash-separated path of a directory relative to the
+	// repository root, or "." for the root. It matches the directory only,
+	// unless it is followed by "/...", which matches the subdirectories too.
+	// "..." matches every directory.
+	Pattern string
+	// Visibility is the value of the visibility attribute, e.g.
+	// ["//visibility:private"].
+	Visibility []string
+}
+
+// Match determines if the directory "rel" matches the pattern of the rule.
+func (v VisibilityRule) Match(rel string) bool {
+	if rel == "" {
+		rel = "."
+	}
+	switch {
+	case v.Pattern == "...":
+		return true
+	case strings.HasSuffix(v.Pattern, "/..."):
+		dir := strings.TrimSuffix(v.Pattern, "/...")
+		return rel == dir || strings.HasPrefix(rel, dir+"/")
+	default:
+		return rel == v.Pattern
+	}
+}
+
+// visibility returns the visibility of the rules generated in the directory
+// "rel". The last of g.visibility which matches "rel" decides it, and rules
+// are public if none matches. A public package under an "internal" or
+// "vendor" directory is visible only to the tree of its parent instead, as
+// Go allows only that tree to import it.
+func (g *generator) visibility(rel string) []string {
+	vis := []string{publicVisibility}
+	for _, v := range g.visibilityRules {
+		if v.Match(rel) {
+			vis = v.Visibility
+		}
+	}
+	if len(vis) == 1 && vis[0] == publicVisibility {
+		if parent, ok := restrictedParent(rel); ok {
+			vis = []string{fmt.Sprintf("//%s:__subpackages__", parent)}
+		}
+	}
+	return vis
+}
+
+// restrictedParent returns the parent of the innermost "internal" or
+// "vendor" element of the slash-separated path "p", if any. Only the tree of
+// the parent can import a package in "p".
+func restrictedParent(p string) (string, bool) {
+	elems := strings.Split(p, "/")
+	for i := len(elems) - 1; i >= 0; i-- {
+		if elems[i] == "internal" || elems[i] == "vendor" {
+			return strings.Join(elems[:i], "/"), true
+		}
+	}
+	return "", false
+}
+
+// checkRestrictedImport reports an import which Go does not allow: an import of
+// a package in a "vendor" directory by its full path, or of a package under
+// an "internal" directory from outside the tree of its parent. The check
+// applies to packages in external repositories as well.
+func (g *generator) checkRestrictedImport(importpath, dir string) {
+	if isRelative(importpath) {
+		return
+	}
+	parent, ok := restrictedParent(importpath)
+	if !ok {
+		return
+	}
+	if strings.HasSuffix(importpath, "/vendor") || strings.Contains(importpath, "/vendor/") || strings.HasPrefix(importpath, "vendor/") {
+		e := &diag.Error{
+			Path: filepath.Join(filepath.FromSlash(dir), "BUILD"),
+			Msg:  fmt.Sprintf("import %q must be imported without the vendor directory", importpath),
+		}
+		if i := strings.LastIndex("/"+importpath, "/vendor/"); i >= 0 {
+			e.Fix = fmt.Sprintf("import %q", importpath[i+len("vendor/"):])
+		}
+		g.diagnose(e)
+		return
+	}
+	if importer := g.importPathOf(dir); !inPrefix(importer, parent) {
+		g.diagnose(&diag.Error{
+			Path: filepath.Join(filepath.FromSlash(dir), "BUILD"),
+			Msg:  fmt.Sprintf("import %q is internal to %q and cannot be imported from here", importpath, parent),
+		})
+	}
+}
+
+// importPathOf returns the import path of the package in the directory "rel"
+// of the repository, implied by the go_prefix of the repository or of the
+// subtree which contains the directory.
+func (g *generator) importPathOf(rel string) string {
+	prefix, dir := g.goPrefix, ""
+	for _, r := range g.roots.roots {
+		if r.Repo != "" {
+			continue
+		}
+		if r.Dir != "" && rel != r.Dir && !strings.HasPrefix(rel, r.Dir+"/") {
+			continue
+		}
+		if len(r.Dir) >= len(dir) {
+			prefix, dir = r.GoPrefix, r.Dir
+		}
+	}
+	return path.Join(prefix, strings.TrimPrefix(strings.TrimPrefix(rel, dir), "/"))
+}
@@ -0,0 +1,114 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/diag"
+)
+
+func TestVisibility(t *testing.T) {
+	g := &generator{
+		goPrefix: "example.com/repo",
+		visibilityRules: []VisibilityRule{
+			{Pattern: "...", Visibility: []string{"//visibility:private"}},
+			{Pattern: "api/...", Visibility: []string{"//visibility:public"}},
+			{Pattern: "tools", Visibility: []string{"//build:__pkg__"}},
+		},
+	}
+	for _, spec := range []struct {
+		rel  string
+		want []string
+	}{
+		{rel: "", want: []string{"//visibility:private"}},
+		{rel: "lib", want: []string{"//visibility:private"}},
+		{rel: "api", want: []string{"//visibility:public"}},
+		{rel: "api/v1", want: []string{"//visibility:public"}},
+		{rel: "apis", want: []string{"//visibility:private"}},
+		{rel: "api/internal/auth", want: []string{"//api:__subpackages__"}},
+		{rel: "api/v1/internal", want: []string{"//api/v1:__subpackages__"}},
+		{rel: "api/vendor/example.org/x", want: []string{"//api:__subpackages__"}},
+		{rel: "tools", want: []string{"//build:__pkg__"}},
+		{rel: "tools/sub", want: []string{"//visibility:private"}},
+	} {
+		if got := g.visibility(spec.rel); !reflect.DeepEqual(got, spec.want) {
+			t.Errorf("g.visibility(%q) = %q; want %q", spec.rel, got, spec.want)
+		}
+	}
+
+	g = &generator{}
+	for _, spec := range []struct {
+		rel  string
+		want []string
+	}{
+		{rel: "lib", want: []string{"//visibility:public"}},
+		{rel: "internal", want: []string{"//:__subpackages__"}},
+		{rel: "internal/x", want: []string{"//:__subpackages__"}},
+		{rel: "vendor/example.org/x/internal/y", want: []string{"//vendor/example.org/x:__subpackages__"}},
+		{rel: "internals", want: []string{"//visibility:public"}},
+	} {
+		if got := g.visibility(spec.rel); !reflect.DeepEqual(got, spec.want) {
+			t.Errorf("g.visibility(%q) = %q; want %q", spec.rel, got, spec.want)
+		}
+	}
+}
+
+func TestCheckRestrictedImport(t *testing.T) {
+	var got []*diag.Error
+	g := &generator{
+		goPrefix: "example.com/repo",
+		onDiagnostic: func(e *diag.Error) {
+			got = append(got, e)
+		},
+	}
+	for _, spec := range []struct {
+		importpath, dir string
+		want            *diag.Error
+	}{
+		{importpath: "fmt", dir: "cmd"},
+		{importpath: "example.com/repo/lib/internal/a", dir: "lib/sub"},
+		{
+			importpath: "example.com/repo/lib/internal/a",
+			dir:        "cmd",
+			want: &diag.Error{
+				Path: filepath.Join("cmd", "BUILD"),
+				Msg:  `import "example.com/repo/lib/internal/a" is internal to "example.com/repo/lib" and cannot be imported from here`,
+			},
+		},
+		{
+			importpath: "example.com/repo/vendor/example.org/x",
+			dir:        "lib",
+			want: &diag.Error{
+				Path: filepath.Join("lib", "BUILD"),
+				Msg:  `import "example.com/repo/vendor/example.org/x" must be imported without the vendor directory`,
+				Fix:  `import "example.org/x"`,
+			},
+		},
+	} {
+		got = nil
+		g.checkRestrictedImport(spec.importpath, spec.dir)
+		var want []*diag.Error
+		if spec.want != nil {
+			want = []*diag.Error{spec.want}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("g.checkRestrictedImport(%q, %q) reported %v; want %v", spec.importpath, spec.dir, got, want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	bzl "github.com/bazelbuild/buildifier/core"
+)
+
+// WalkStrings calls "f" for each string literal in "e", including the ones
+// in lists, dicts, concatenations and calls like select({...}).
+func WalkStrings(e bzl.Expr, f func(*bzl.StringExpr)) {
+	switch e := e.(type) {
+	case *bzl.StringExpr:
+		f(e)
+	case *bzl.ListExpr:
+		for _, x := range e.List {
+			WalkStrings(x, f)
+		}
+	case *bzl.TupleExpr:
+		for _, x := range e.List {
+			WalkStrings(x, f)
+		}
+	case *bzl.DictExpr:
+		for _, x := range e.List {
+			WalkStrings(x, f)
+		}
+	case *bzl.KeyValueExpr:
+		WalkStrings(e.Key, f)
+		WalkStrings(e.Value, f)
+	case *bzl.BinaryExpr:
+		WalkStrings(e.X, f)
+		WalkStrings(e.Y, f)
+	case *bzl.ParenExpr:
+		WalkStrings(e.X, f)
+	case *bzl.CallExpr:
+		for _, x := range e.List {
+			WalkStrings(x, f)
+		}
+	}
+}
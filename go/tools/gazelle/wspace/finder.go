@@ -10,7 +10,8 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-// Package wspace provides functions to locate and modify a bazel WORKSPACE file.
+// Package wspace provides functions to locate, read and modify a bazel
+// WORKSPACE file.
 package wspace
 
 import (
@@ -35,3 +36,36 @@ func Find(dir string) (string, error) {
 	}
 	return Find(filepath.Dir(dir))
 }
+
+// IsRoot reports whether dir contains a WORKSPACE file.
+func IsRoot(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, workspaceFile))
+	return err == nil
+}
+
+// FindNested returns the directories under root, excluding root itself,
+// which contain a WORKSPACE file. It does not look into the nested
+// workspaces it finds, nor into hidden and testdata directories.
+func FindNested(root string) ([]string, error) {
+	var dirs []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() || path == root {
+			return nil
+		}
+		if base := info.Name(); base[0] == '.' || base[0] == '_' || base == "testdata" {
+			return filepath.SkipDir
+		}
+		if IsRoot(path) {
+			dirs = append(dirs, path)
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dirs, nil
+}
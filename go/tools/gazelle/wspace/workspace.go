@@ -0,0 +1,457 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+   http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wspace
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	bzl "github.com/bazelbuild/buildifier/core"
+)
+
+// GoRepositoryBzl is the label of the Skylark file which defines
+// go_repository.
+const GoRepositoryBzl = "@io_bazel_rules_go//go:def.bzl"
+
+// A Workspace is a parsed WORKSPACE file.
+type Workspace struct {
+	// Dir is the directory which contains the WORKSPACE file.
+	Dir string
+	// File is the parsed WORKSPACE file.
+	File *bzl.File
+
+	macros   []Repository
+	modified bool
+}
+
+// A Repository is a repository rule, e.g. go_repository, declared in a
+// WORKSPACE file or in a macro which the WORKSPACE file loads and calls.
+type Repository struct {
+	Kind, Name string
+	// Rule is the call of the repository rule.
+	Rule *bzl.Rule
+	// Path is the path to the file which declares the repository.
+	Path string
+	// Macro is the name of the macro which declares the repository, or "" if
+	// the WORKSPACE file declares it directly.
+	Macro string
+}
+
+// ImportPath returns the importpath attribute of the rule, which
+// go_repository and new_go_repository have.
+func (r Repository) ImportPath() string {
+	return r.Rule.AttrString("importpath")
+}
+
+// A GoRepository describes the attributes of a go_repository rule.
+type GoRepository struct {
+	Name, ImportPath string
+	// Remote and VCS optionally override the repository location which is
+	// otherwise derived from ImportPath.
+	Remote, VCS string
+	// Commit or Tag selects the revision to fetch.
+	Commit, Tag string
+}
+
+// Load parses the WORKSPACE file in "dir". It also reads the .bzl files in
+// the repository which the WORKSPACE file loads, to find the repositories
+// declared by the macros it calls.
+func Load(dir string) (*Workspace, error) {
+	f, err := parse(dir)
+	if err != nil {
+		return nil, err
+	}
+	w := &Workspace{Dir: dir, File: f}
+	if err := w.loadMacros(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// parse parses the WORKSPACE file in "dir".
+func parse(dir string) (*bzl.File, error) {
+	p := filepath.Join(dir, workspaceFile)
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	return bzl.Parse(p, b)
+}
+
+// LocalRepositories returns the names of the local_repository and
+// new_local_repository rules declared directly in the WORKSPACE file in
+// "dir", keyed by the cleaned absolute paths of the repositories. Relative
+// paths are relative to "dir". Macros are not read.
+func LocalRepositories(dir string) (map[string]string, error) {
+	f, err := parse(dir)
+	if err != nil {
+		return nil, err
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	repos := make(map[string]string)
+	for _, r := range repositoryRules(f) {
+		if r.Kind() != "local_repository" && r.Kind() != "new_local_repository" {
+			continue
+		}
+		path := r.AttrString("path")
+		if path == "" {
+			continue
+		}
+		path = filepath.FromSlash(path)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		repos[filepath.Clean(path)] = r.Name()
+	}
+	return repos, nil
+}
+
+// Name returns the name which the WORKSPACE file in dir declares with
+// workspace(name = ...), or "" if it does not declare one. Unlike Load, it
+// does not read the .bzl files which the WORKSPACE file loads, so a broken
+// macro does not keep the name from being read.
+func Name(dir string) (string, error) {
+	f, err := parse(dir)
+	if err != nil {
+		return "", err
+	}
+	return (&Workspace{Dir: dir, File: f}).Name(), nil
+}
+
+// Name returns the name declared with workspace(name = ...), or "" if the
+// file does not declare one.
+func (w *Workspace) Name() string {
+	for _, r := range w.File.Rules("workspace") {
+		if name := r.AttrString("name"); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// Repositories returns the repository rules of the given kind in the order
+// they are declared, including those declared in macros. If kind is "", it
+// returns repository rules of all kinds.
+func (w *Workspace) Repositories(kind string) []Repository {
+	var repos []Repository
+	for _, r := range repositoryRules(w.File) {
+		if kind == "" || r.Kind() == kind {
+			repos = append(repos, Repository{Kind: r.Kind(), Name: r.Name(), Rule: r, Path: w.File.Path})
+		}
+	}
+	for _, r := range w.macros {
+		if kind == "" || r.Kind == kind {
+			repos = append(repos, r)
+		}
+	}
+	return repos
+}
+
+// Repository returns the repository rule named "name".
+func (w *Workspace) Repository(name string) (Repository, bool) {
+	for _, r := range w.Repositories("") {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return Repository{}, false
+}
+
+// RepositoryForImportPath returns the repository whose importpath attribute
+// is the longest prefix of "importpath".
+func (w *Workspace) RepositoryForImportPath(importpath string) (Repository, bool) {
+	var (
+		found Repository
+		ok    bool
+	)
+	for _, r := range w.Repositories("") {
+		p := r.ImportPath()
+		if p == "" || importpath != p && !strings.HasPrefix(importpath, p+"/") {
+			continue
+		}
+		if !ok || len(p) > len(found.ImportPath()) {
+			found, ok = r, true
+		}
+	}
+	return found, ok
+}
+
+// SetGoRepository adds a go_repository rule to the WORKSPACE file, or
+// updates the attributes of the existing one with the same name. Attributes
+// which are empty in "repo" are removed. A new rule comes with a load of
+// go_repository from GoRepositoryBzl, unless the file loads it already. It returns an error if the existing
+// repository is declared in a macro, since gazelle does not rewrite macros.
+func (w *Workspace) SetGoRepository(repo GoRepository) error {
+	var r *bzl.Rule
+	if existing, ok := w.Repository(repo.Name); ok {
+		if existing.Macro != "" {
+			return fmt.Errorf("repository %q is declared by macro %s in %s; edit it there", repo.Name, existing.Macro, existing.Path)
+		}
+		if existing.Kind != "go_repository" {
+			return fmt.Errorf("repository %q is a %s, not a go_repository", repo.Name, existing.Kind)
+		}
+		r = existing.Rule
+	} else {
+		w.addLoad(GoRepositoryBzl, "go_repository")
+		r = &bzl.Rule{Call: &bzl.CallExpr{X: &bzl.LiteralExpr{Token: "go_repository"}}}
+		w.File.Stmt = append(w.File.Stmt, r.Call)
+	}
+	for _, a := range []struct{ key, value string }{
+		{"name", repo.Name},
+		{"importpath", repo.ImportPath},
+		{"remote", repo.Remote},
+		{"vcs", repo.VCS},
+		{"commit", repo.Commit},
+		{"tag", repo.Tag},
+	} {
+		if a.value == "" {
+			r.DelAttr(a.key)
+			continue
+		}
+		r.SetAttr(a.key, &bzl.StringExpr{Value: a.value})
+	}
+	w.modified = true
+	return nil
+}
+
+// addLoad makes the WORKSPACE file load "symbol" from "file", unless it
+// loads a symbol of that name from any file already. The symbol is added to
+// an existing load of "file", or else a new load statement is inserted after
+// the last one, or after workspace() if there is none.
+func (w *Workspace) addLoad(file, symbol string) {
+	var (
+		existing *bzl.CallExpr
+		insert   int
+	)
+	for i, s := range w.File.Stmt {
+		c, ok := s.(*bzl.CallExpr)
+		if !ok {
+			continue
+		}
+		switch (&bzl.Rule{Call: c}).Kind() {
+		case "workspace":
+			if insert == 0 {
+				insert = i + 1
+			}
+		case "load":
+			insert = i + 1
+			if len(c.List) == 0 {
+				continue
+			}
+			for _, arg := range c.List[1:] {
+				if s, ok := arg.(*bzl.StringExpr); ok && s.Value == symbol {
+					return
+				}
+			}
+			if l, ok := c.List[0].(*bzl.StringExpr); ok && l.Value == file && existing == nil {
+				existing = c
+			}
+		}
+	}
+	if existing != nil {
+		existing.List = append(existing.List, &bzl.StringExpr{Value: symbol})
+		return
+	}
+	load := &bzl.CallExpr{
+		X:            &bzl.LiteralExpr{Token: "load"},
+		List:         []bzl.Expr{&bzl.StringExpr{Value: file}, &bzl.StringExpr{Value: symbol}},
+		ForceCompact: true,
+	}
+	stmt := append([]bzl.Expr{}, w.File.Stmt[:insert]...)
+	stmt = append(stmt, load)
+	w.File.Stmt = append(stmt, w.File.Stmt[insert:]...)
+}
+
+// RemoveRepository removes the repository rule named "name" from the
+// WORKSPACE file. It reports whether there was such a rule. Repositories
+// declared in macros are not removed.
+func (w *Workspace) RemoveRepository(name string) bool {
+	for i, s := range w.File.Stmt {
+		c, ok := s.(*bzl.CallExpr)
+		if !ok {
+			continue
+		}
+		if r := (&bzl.Rule{Call: c}); r.Kind() != "workspace" && r.Name() == name {
+			w.File.Stmt = append(w.File.Stmt[:i], w.File.Stmt[i+1:]...)
+			w.modified = true
+			return true
+		}
+	}
+	return false
+}
+
+// Save writes the WORKSPACE file back if it was modified. The file is
+// replaced atomically: the new content is written into a temporary file in
+// the same directory first, which is then renamed over the file.
+func (w *Workspace) Save() error {
+	if !w.modified {
+		return nil
+	}
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(w.File.Path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	f, err := ioutil.TempFile(filepath.Dir(w.File.Path), workspaceFile+".gazelle")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(bzl.Format(w.File))
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), mode)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), w.File.Path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	w.modified = false
+	return nil
+}
+
+// repositoryRules returns the top-level calls in "f" which have a name,
+// except workspace().
+func repositoryRules(f *bzl.File) []*bzl.Rule {
+	var rules []*bzl.Rule
+	for _, r := range f.Rules("") {
+		if r.Kind() == "workspace" || r.Kind() == "load" || r.Name() == "" {
+			continue
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// loadMacros finds the repositories declared by macros which the WORKSPACE
+// file loads from .bzl files in the workspace and calls at the top level.
+func (w *Workspace) loadMacros() error {
+	called := make(map[string]bool)
+	for _, r := range w.File.Rules("") {
+		called[r.Kind()] = true
+	}
+	for _, load := range w.File.Rules("load") {
+		if len(load.Call.List) == 0 {
+			continue
+		}
+		l, ok := load.Call.List[0].(*bzl.StringExpr)
+		if !ok {
+			continue
+		}
+		path := w.labelPath(l.Value)
+		if path == "" {
+			continue
+		}
+		var names []string
+		for _, arg := range load.Call.List[1:] {
+			if s, ok := arg.(*bzl.StringExpr); ok && called[s.Value] {
+				names = append(names, s.Value)
+			}
+		}
+		if len(names) == 0 {
+			continue
+		}
+		repos, err := macroRepositories(path, names)
+		if err != nil {
+			return err
+		}
+		w.macros = append(w.macros, repos...)
+	}
+	return nil
+}
+
+// labelPath returns the path to the file which "label" refers to in the
+// workspace, or "" if the label refers to another repository.
+func (w *Workspace) labelPath(label string) string {
+	if strings.HasPrefix(label, "@") {
+		return ""
+	}
+	label = strings.TrimPrefix(label, "//")
+	label = strings.TrimPrefix(label, ":")
+	return filepath.Join(w.Dir, filepath.FromSlash(strings.Replace(label, ":", "/", 1)))
+}
+
+var defRE = regexp.MustCompile(`^def\s+(\w+)\s*\(`)
+
+// macroRepositories returns the repository rules called directly in the
+// bodies of the functions "names" defined in the .bzl file at "path".
+func macroRepositories(path string, names []string) ([]Repository, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	f, err := bzl.Parse(path, b)
+	if err != nil {
+		return nil, err
+	}
+	want := make(map[string]bool)
+	for _, n := range names {
+		want[n] = true
+	}
+
+	var repos []Repository
+	for _, s := range f.Stmt {
+		// Function definitions are not parsed by buildifier but kept as
+		// blocks of Python code. Parse their bodies as BUILD files.
+		block, ok := s.(*bzl.PythonBlock)
+		if !ok {
+			continue
+		}
+		m := defRE.FindStringSubmatch(block.Token)
+		if m == nil || !want[m[1]] {
+			continue
+		}
+		body, err := bzl.Parse(path, []byte(dedentBody(block.Token)))
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range repositoryRules(body) {
+			repos = append(repos, Repository{Kind: r.Kind(), Name: r.Name(), Rule: r, Path: path, Macro: m[1]})
+		}
+	}
+	return repos, nil
+}
+
+// dedentBody returns the body of a Python function definition without the
+// def line and with the indentation of the body removed.
+func dedentBody(def string) string {
+	lines := strings.Split(def, "\n")[1:]
+	indent := ""
+	for _, l := range lines {
+		if strings.TrimSpace(l) == "" {
+			continue
+		}
+		indent = l[:len(l)-len(strings.TrimLeft(l, " \t"))]
+		break
+	}
+	for i, l := range lines {
+		lines[i] = strings.TrimPrefix(l, indent)
+	}
+	return strings.Join(lines, "\n")
+}
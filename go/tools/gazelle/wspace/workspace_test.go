@@ -0,0 +1,274 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+   http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wspace
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFindNestedAndName(t *testing.T) {
+	tmp, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	for _, f := range []struct {
+		path, content string
+	}{
+		{path: workspaceFile, content: `workspace(name = "outer")`},
+		{path: "a/b/" + workspaceFile, content: `workspace(name = "inner")`},
+		{path: "a/b/c/" + workspaceFile, content: ""},
+		{path: "d/" + workspaceFile, content: "# no name\n"},
+		{path: "testdata/" + workspaceFile, content: ""},
+	} {
+		p := filepath.Join(tmp, filepath.FromSlash(f.path))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(f.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dirs, err := FindNested(tmp)
+	if err != nil {
+		t.Fatalf("FindNested(%q) failed with %v", tmp, err)
+	}
+	if want := []string{filepath.Join(tmp, "a", "b"), filepath.Join(tmp, "d")}; !reflect.DeepEqual(dirs, want) {
+		t.Errorf("FindNested(%q) got %q, want %q", tmp, dirs, want)
+	}
+
+	for _, tc := range []testCase{
+		{tmp, "outer"},
+		{filepath.Join(tmp, "a", "b"), "inner"},
+		{filepath.Join(tmp, "d"), ""},
+	} {
+		name, err := Name(tc.dir)
+		if err != nil {
+			t.Errorf("Name(%q) failed with %v", tc.dir, err)
+			continue
+		}
+		if name != tc.want {
+			t.Errorf("Name(%q) got %q, want %q", tc.dir, name, tc.want)
+		}
+	}
+}
+
+func TestNameWithBrokenMacro(t *testing.T) {
+	tmp, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	for name, content := range map[string]string{
+		workspaceFile: "workspace(name = \"broken\")\n\nload(\"//:deps.bzl\", \"deps\")\n\ndeps()\n",
+		"deps.bzl":    "def deps():\n    go_repository(\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(tmp, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := Load(tmp); err == nil {
+		t.Errorf("Load(%q) succeeded; want failure", tmp)
+	}
+	if name, err := Name(tmp); err != nil || name != "broken" {
+		t.Errorf("Name(%q) = %q, %v; want %q, nil", tmp, name, err, "broken")
+	}
+}
+
+const workspaceData = `workspace(name = "com_example_repo")
+
+load("//:repos.bzl", "go_deps")
+
+# A comment which must be preserved.
+go_repository(
+    name = "com_example_a",
+    importpath = "example.com/a",
+    commit = "1234",
+)
+
+git_repository(
+    name = "io_bazel_rules_go",
+    remote = "https://github.com/bazelbuild/rules_go.git",
+    tag = "0.3.3",
+)
+
+go_deps()
+`
+
+const reposData = `def go_deps():
+    new_go_repository(
+        name = "com_example_a_b",
+        importpath = "example.com/a/b",
+        tag = "v1.0",
+    )
+
+def unused():
+    go_repository(
+        name = "com_example_unused",
+        importpath = "example.com/unused",
+    )
+`
+
+func TestWorkspace(t *testing.T) {
+	tmp, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	if err := ioutil.WriteFile(filepath.Join(tmp, workspaceFile), []byte(workspaceData), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmp, "repos.bzl"), []byte(reposData), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := Load(tmp)
+	if err != nil {
+		t.Fatalf("Load(%q) failed with %v", tmp, err)
+	}
+	if got, want := w.Name(), "com_example_repo"; got != want {
+		t.Errorf("w.Name() got %q, want %q", got, want)
+	}
+
+	var names []string
+	for _, r := range w.Repositories("") {
+		names = append(names, r.Name)
+	}
+	if want := []string{"com_example_a", "io_bazel_rules_go", "com_example_a_b"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("w.Repositories(\"\") got %q, want %q", names, want)
+	}
+	if got := len(w.Repositories("git_repository")); got != 1 {
+		t.Errorf("len(w.Repositories(\"git_repository\")) got %d, want 1", got)
+	}
+
+	for _, tc := range []struct {
+		importpath, want string
+	}{
+		{"example.com/a", "com_example_a"},
+		{"example.com/a/c", "com_example_a"},
+		{"example.com/a/b/c", "com_example_a_b"},
+		{"example.com/unused", ""},
+	} {
+		r, _ := w.RepositoryForImportPath(tc.importpath)
+		if r.Name != tc.want {
+			t.Errorf("w.RepositoryForImportPath(%q) got %q, want %q", tc.importpath, r.Name, tc.want)
+		}
+	}
+
+	if err := w.SetGoRepository(GoRepository{Name: "com_example_a_b", ImportPath: "example.com/a/b"}); err == nil {
+		t.Errorf("w.SetGoRepository for a repository in a macro succeeded, want error")
+	}
+	if err := w.SetGoRepository(GoRepository{Name: "com_example_a", ImportPath: "example.com/a", Tag: "v2"}); err != nil {
+		t.Errorf("w.SetGoRepository failed with %v", err)
+	}
+	if err := w.SetGoRepository(GoRepository{Name: "com_example_c", ImportPath: "example.com/c", Commit: "abcd"}); err != nil {
+		t.Errorf("w.SetGoRepository failed with %v", err)
+	}
+	if !w.RemoveRepository("io_bazel_rules_go") {
+		t.Errorf("w.RemoveRepository(%q) got false, want true", "io_bazel_rules_go")
+	}
+	if err := w.Save(); err != nil {
+		t.Fatalf("w.Save() failed with %v", err)
+	}
+
+	w, err = Load(tmp)
+	if err != nil {
+		t.Fatalf("Load(%q) failed with %v", tmp, err)
+	}
+	for _, tc := range []struct {
+		name, attr, want string
+	}{
+		{"com_example_a", "tag", "v2"},
+		{"com_example_a", "commit", ""},
+		{"com_example_c", "importpath", "example.com/c"},
+		{"com_example_c", "commit", "abcd"},
+	} {
+		r, ok := w.Repository(tc.name)
+		if !ok {
+			t.Errorf("w.Repository(%q) not found", tc.name)
+			continue
+		}
+		if got := r.Rule.AttrString(tc.attr); got != tc.want {
+			t.Errorf("%s.%s got %q, want %q", tc.name, tc.attr, got, tc.want)
+		}
+	}
+	if _, ok := w.Repository("io_bazel_rules_go"); ok {
+		t.Errorf("w.Repository(%q) found after removal", "io_bazel_rules_go")
+	}
+	b, err := ioutil.ReadFile(filepath.Join(tmp, workspaceFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "# A comment which must be preserved.") {
+		t.Errorf("comment lost in saved WORKSPACE:\n%s", b)
+	}
+	if want := "load(\"//:repos.bzl\", \"go_deps\")\nload(\"" + GoRepositoryBzl + "\", \"go_repository\")\n"; !strings.Contains(string(b), want) {
+		t.Errorf("saved WORKSPACE does not load go_repository after the other load:\n%s", b)
+	}
+	if tmps, _ := filepath.Glob(filepath.Join(tmp, workspaceFile+".gazelle*")); len(tmps) > 0 {
+		t.Errorf("temporary files %q left by w.Save()", tmps)
+	}
+}
+
+func TestSetGoRepositoryLoad(t *testing.T) {
+	tmp, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	for _, tc := range []struct {
+		data, want string
+	}{
+		{
+			data: "workspace(name = \"repo\")\n",
+			want: "workspace(name = \"repo\")\n\nload(\"" + GoRepositoryBzl + "\", \"go_repository\")\n",
+		},
+		{
+			data: "load(\"" + GoRepositoryBzl + "\", \"go_rules_dependencies\")\n",
+			want: "load(\"" + GoRepositoryBzl + "\", \"go_rules_dependencies\", \"go_repository\")\n",
+		},
+		{
+			data: "load(\"//:deps.bzl\", \"go_repository\")\n",
+			want: "load(\"//:deps.bzl\", \"go_repository\")\n\ngo_repository(",
+		},
+	} {
+		if err := ioutil.WriteFile(filepath.Join(tmp, workspaceFile), []byte(tc.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		w, err := Load(tmp)
+		if err != nil {
+			t.Fatalf("Load(%q) failed with %v", tmp, err)
+		}
+		if err := w.SetGoRepository(GoRepository{Name: "com_example_c", ImportPath: "example.com/c"}); err != nil {
+			t.Errorf("w.SetGoRepository failed with %v", err)
+		}
+		if err := w.Save(); err != nil {
+			t.Fatalf("w.Save() failed with %v", err)
+		}
+		b, err := ioutil.ReadFile(filepath.Join(tmp, workspaceFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(b), tc.want) {
+			t.Errorf("saved WORKSPACE for %q:\n%s\nwant it to start with:\n%s", tc.data, b, tc.want)
+		}
+	}
+}